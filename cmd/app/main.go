@@ -1,92 +1,389 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 
 	"donos-hrm/internal/auth"
 	"donos-hrm/internal/handlers"
+	"donos-hrm/internal/logging"
+	"donos-hrm/internal/metrics"
+	"donos-hrm/internal/notify"
 	"donos-hrm/internal/ratelimit"
-	"donos-hrm/internal/storage"
+	"donos-hrm/internal/webhook"
 	"time"
 
 	templ "donos-hrm/internal/templates"
 )
 
 func main() {
+	startedAt := time.Now()
 	_ = godotenv.Load()
 
+	if _, err := logging.Setup(os.Stderr, logging.Config{
+		Level:   os.Getenv("LOG_LEVEL"),
+		Format:  os.Getenv("LOG_FORMAT"),
+		ShowPII: os.Getenv("LOG_SHOW_PII") == "true",
+	}); err != nil {
+		fatal("invalid logging configuration", err)
+	}
+
+	// Корневой контекст отменяется по SIGINT/SIGTERM и запускает остановку. Почта и
+	// webhooks по нему сразу перестают брать новые отправки; то, что поставят в очередь
+	// последние запросы, уйдет после следующего запуска.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	clientID := os.Getenv("GOOGLE_CLIENT_ID")
 	clientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
 	baseURL := os.Getenv("BASE_URL")
 
 	if clientID == "" || clientSecret == "" || baseURL == "" {
-		log.Fatal("GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET, and BASE_URL must be set")
+		fatal("GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET, and BASE_URL must be set", nil)
 	}
 
 	tmpl, err := templ.Load()
 	if err != nil {
-		log.Fatalf("load templates: %v", err)
+		fatal("failed to load templates", err)
 	}
 
-	// Используем файловое хранилище
-	dataFile := os.Getenv("DATA_FILE")
-	if dataFile == "" {
-		dataFile = "data/complaints.json"
+	st, err := openStores()
+	if err != nil {
+		fatal("failed to open store", err)
 	}
 
-	// Создаем директорию если не существует
-	if err := os.MkdirAll("data", 0755); err != nil {
-		log.Fatalf("failed to create data directory: %v", err)
+	// ADMIN_EMAIL - владелец: получает роль admin при каждом старте
+	if adminEmail := os.Getenv("ADMIN_EMAIL"); adminEmail != "" {
+		if err := st.roles.Grant(adminEmail, auth.RoleAdmin, "ADMIN_EMAIL"); err != nil {
+			fatal("failed to grant admin role", err)
+		}
+		slog.Info("admin email configured", logging.Email("email", adminEmail))
 	}
 
-	store, err := storage.NewFileStore(dataFile)
+	policy, err := auth.LoadAccessPolicy(os.Getenv("ACCESS_POLICY_FILE"))
 	if err != nil {
-		log.Fatalf("failed to create file store: %v", err)
+		fatal("failed to load access policy", err)
+	}
+	if policy.IsEmpty() {
+		slog.Warn("access policy is empty, all logins will be denied; set AUTH_ALLOWED_DOMAINS or ACCESS_POLICY_FILE")
 	}
-	log.Printf("using data file: %s", dataFile)
 
-	authManager := auth.NewManager(clientID, clientSecret, baseURL)
+	pseudonymSecret := os.Getenv("PSEUDONYM_SECRET")
+	if pseudonymSecret == "" {
+		slog.Warn("PSEUDONYM_SECRET is not set, anonymous reporters will lose access to their complaints after restart")
+	}
 
-	// Rate limiter: 5 запросов в минуту по IP и email
+	// Адрес клиента из заголовков берется, только если запрос пришел от доверенного прокси
+	trustedProxies, err := ratelimit.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		fatal("invalid TRUSTED_PROXIES", err)
+	}
+	proxyHeader := os.Getenv("TRUSTED_PROXY_HEADER")
+	if proxyHeader != "" && !ratelimit.ValidProxyHeader(proxyHeader) {
+		fatal("invalid TRUSTED_PROXY_HEADER", fmt.Errorf("%q: want X-Forwarded-For, Forwarded or X-Real-IP", proxyHeader))
+	}
+	// Лимиты политик переопределяются переменными RATE_LIMIT_<ИМЯ>, например RATE_LIMIT_LOGIN=10/1m,burst=20
+	var policies []ratelimit.Policy
+	for _, def := range ratelimit.DefaultPolicies {
+		name := "RATE_LIMIT_" + strings.ToUpper(def.Name)
+		spec := os.Getenv(name)
+		if spec == "" {
+			continue
+		}
+		p, err := ratelimit.ParsePolicy(def.Name, spec)
+		if err != nil {
+			fatal("invalid "+name, err)
+		}
+		policies = append(policies, p)
+	}
+	// Блокировка входа после неудач: LOGIN_LOCKOUT_THRESHOLD взвешенных неудач за
+	// LOGIN_LOCKOUT_WINDOW блокируют на LOGIN_LOCKOUT_BASE, вдвое дольше с каждым разом
+	lockout := ratelimit.LockoutPolicy{
+		Threshold: envInt("LOGIN_LOCKOUT_THRESHOLD", ratelimit.DefaultLockout.Threshold),
+		Window:    envDuration("LOGIN_LOCKOUT_WINDOW", ratelimit.DefaultLockout.Window),
+		Base:      envDuration("LOGIN_LOCKOUT_BASE", ratelimit.DefaultLockout.Base),
+		Max:       envDuration("LOGIN_LOCKOUT_MAX", ratelimit.DefaultLockout.Max),
+	}
+	if err := lockout.Validate(); err != nil {
+		fatal("invalid LOGIN_LOCKOUT_*", err)
+	}
 	rateLimiter := ratelimit.NewLimiter(ratelimit.Config{
-		MaxRequests: 5,
-		Window:      1 * time.Minute,
-		CleanupInt:  5 * time.Minute,
+		Policies:       policies,
+		CleanupInt:     5 * time.Minute,
+		TrustedProxies: trustedProxies,
+		ProxyHeader:    proxyHeader,
+		Backend:        openRateLimitBackend(ctx),
+		FailOpen:       os.Getenv("RATE_LIMIT_FAIL_OPEN") == "true",
+		Lockout:        lockout,
+		Access:         st.access,
 	})
 
-	adminEmail := os.Getenv("ADMIN_EMAIL")
-	if adminEmail != "" {
-		log.Printf("admin email: %s", adminEmail)
+	authManager := auth.NewManager(auth.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		BaseURL:      baseURL,
+		Policy:       policy,
+		Sessions:     st.sessions,
+		AbsoluteTTL:  envDuration("SESSION_ABSOLUTE_TTL", 7*24*time.Hour),
+		IdleTTL:      envDuration("SESSION_IDLE_TTL", 24*time.Hour),
+		ClientIP:     rateLimiter.GetIP,
+		Tokens:       st.tokens,
+		PseudonymKey: []byte(pseudonymSecret),
+	})
+
+	notifier, err := openNotifier(ctx, st.mail, baseURL)
+	if err != nil {
+		fatal("failed to configure email", err)
 	}
 
-	h := handlers.New(tmpl, store, authManager, rateLimiter, adminEmail)
+	webhooks := webhook.New(webhook.Config{
+		Context:     ctx,
+		Store:       st.webhooks,
+		MaxAttempts: envInt("WEBHOOK_MAX_ATTEMPTS", 10),
+	})
+
+	quota := ratelimit.Quota{
+		Hourly: envInt("SUBMISSION_QUOTA_HOURLY", ratelimit.DefaultQuota.Hourly),
+		Daily:  envInt("SUBMISSION_QUOTA_DAILY", ratelimit.DefaultQuota.Daily),
+	}
+	if err := quota.Validate(); err != nil {
+		fatal("invalid SUBMISSION_QUOTA_HOURLY/SUBMISSION_QUOTA_DAILY", err)
+	}
+
+	metrics.NewGaugeFunc("donos_active_sessions", "Sessions that have not expired yet.", func() (float64, error) {
+		n, err := authManager.ActiveSessions()
+		return float64(n), err
+	})
+
+	h := handlers.New(tmpl, st.complaints, authManager, rateLimiter, st.roles, handlers.UploadConfig{
+		Blobs:       st.blobs,
+		MaxFileSize: int64(envInt("ATTACHMENT_MAX_MB", 10)) << 20,
+		MaxFiles:    envInt("ATTACHMENT_MAX_FILES", 5),
+	}, st.audit, notifier, webhooks, handlers.QuotaConfig{
+		Store:   st.quotas,
+		Default: quota,
+	})
+	rateLimiter.RejectHandler = h.TooManyRequests
+	rateLimiter.ForbidHandler = h.Forbidden
+	// Вложения, оставшиеся без жалобы после сбоев
+	h.CollectGarbage()
 
 	r := mux.NewRouter()
+	r.Use(h.LimitBody)
+	r.Use(h.CSRF)
+	// Пробы оркестратора: без входа и без rate limiter
+	r.HandleFunc("/healthz", h.HandleHealthz()).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", h.HandleReadyz(st.checkWritable)).Methods(http.MethodGet)
+	r.HandleFunc("/version", h.HandleVersion(st.driver, startedAt)).Methods(http.MethodGet)
+	r.HandleFunc("/metrics", h.HandleMetrics(os.Getenv("METRICS_TOKEN"))).Methods(http.MethodGet)
 	r.HandleFunc("/", h.RequireAuth(h.HandleForm())).Methods(http.MethodGet, http.MethodPost)
 	r.HandleFunc("/form", h.RequireAuth(h.HandleForm())).Methods(http.MethodGet, http.MethodPost)
 	r.HandleFunc("/complaints", h.RequireAuth(h.HandleList())).Methods(http.MethodGet)
+	r.HandleFunc("/complaints/{id:[0-9]+}", h.RequireAuth(h.HandleComplaint())).Methods(http.MethodGet)
+	r.HandleFunc("/complaints/{id:[0-9]+}/comments", h.RequireAuth(h.HandleAddComment())).Methods(http.MethodPost)
+	r.HandleFunc("/complaints/{id:[0-9]+}/attachments/{key:[0-9a-f]+}", h.RequireAuth(h.HandleAttachment())).Methods(http.MethodGet)
 	r.HandleFunc("/login", h.HandleLogin()).Methods(http.MethodGet)
 	r.HandleFunc("/auth/google/callback", h.HandleCallback()).Methods(http.MethodGet)
-	r.HandleFunc("/logout", h.HandleLogout()).Methods(http.MethodPost)
+	r.HandleFunc(handlers.LogoutPath, h.HandleLogout()).Methods(http.MethodPost)
+	r.HandleFunc(handlers.CSPReportPath, h.HandleCSPReport()).Methods(http.MethodPost)
+	r.HandleFunc("/sessions", h.RequireSession(h.HandleSessions())).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/settings/tokens", h.RequireSession(h.HandleTokens())).Methods(http.MethodGet, http.MethodPost)
 
 	// Admin routes
-	r.HandleFunc("/admin", h.RequireAdmin(h.HandleAdmin())).Methods(http.MethodGet)
-	r.HandleFunc("/admin/toggle", h.RequireAdmin(h.HandleToggleHidden())).Methods(http.MethodPost)
+	r.HandleFunc("/admin", h.RequireRole(auth.RoleReviewer, h.HandleAdmin())).Methods(http.MethodGet)
+	r.HandleFunc("/admin/toggle", h.RequireRole(auth.RoleModerator, h.HandleToggleHidden())).Methods(http.MethodPost)
+	r.HandleFunc("/admin/status", h.RequireRole(auth.RoleModerator, h.HandleSetStatus())).Methods(http.MethodPost)
+	r.HandleFunc("/admin/purge", h.RequireAdmin(h.HandlePurge())).Methods(http.MethodPost)
+	r.HandleFunc("/admin/roles", h.RequireAdmin(h.HandleRoles())).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/admin/audit", h.RequireAdmin(h.HandleAudit())).Methods(http.MethodGet)
+	r.HandleFunc("/admin/export.csv", h.RequireRole(auth.RoleReviewer, h.HandleExport())).Methods(http.MethodGet)
+	r.HandleFunc("/admin/webhooks", h.RequireAdmin(h.HandleWebhooks())).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/admin/quotas", h.RequireAdmin(h.HandleQuotas())).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/admin/access", h.RequireAdmin(h.HandleAccess())).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/admin/sessions/revoke", h.RequireAdmin(h.HandleForceLogout())).Methods(http.MethodPost)
+
+	// JSON API. Маршруты регистрируются на корневом роутере: у саброутеров mux 1.8
+	// путаются 404 и 405, когда путь совпадает, а метод нет.
+	const api = "/api/v1"
+	r.HandleFunc(api+"/complaints", h.APIRequire(auth.RoleReporter, h.APICreateComplaint())).Methods(http.MethodPost)
+	r.HandleFunc(api+"/complaints", h.APIRequire(auth.RoleReporter, h.APIListComplaints())).Methods(http.MethodGet)
+	r.HandleFunc(api+"/complaints/{id:[0-9]+}", h.APIRequire(auth.RoleReporter, h.APIGetComplaint())).Methods(http.MethodGet)
+	r.HandleFunc(api+"/admin/complaints", h.APIRequire(auth.RoleReviewer, h.APIListAllComplaints())).Methods(http.MethodGet)
+	r.HandleFunc(api+"/admin/complaints/{id:[0-9]+}", h.APIRequire(auth.RoleModerator, h.APIUpdateComplaint())).Methods(http.MethodPatch)
+	r.NotFoundHandler = h.NotFound()
+	r.MethodNotAllowedHandler = h.MethodNotAllowed()
 
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
+	security := handlers.SecurityConfig{
+		CSP:        os.Getenv("CSP_POLICY"),
+		ReportOnly: os.Getenv("CSP_REPORT_ONLY") == "true",
+		HSTS:       strings.HasPrefix(strings.ToLower(baseURL), "https://"),
+	}
+
 	addr := ":8045"
 	if port := os.Getenv("PORT"); port != "" {
 		addr = ":" + port
 	}
 
-	log.Printf("listening on %s", addr)
-	if err := http.ListenAndServe(addr, r); err != nil {
-		log.Fatalf("server error: %v", err)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           rateLimiter.ResolveClientIP(h.RequestID(h.SecurityHeaders(security)(h.Instrument(r)))),
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+		ReadHeaderTimeout: 10 * time.Second,
+		// Загрузка вложений и выгрузка CSV должны укладываться в эти пределы
+		ReadTimeout:  envDuration("HTTP_READ_TIMEOUT", time.Minute),
+		WriteTimeout: envDuration("HTTP_WRITE_TIMEOUT", time.Minute),
+		IdleTimeout:  2 * time.Minute,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	failed := false
+	select {
+	case err := <-serveErr:
+		slog.Error("server error", "err", err)
+		failed = true
+	case <-ctx.Done():
+		// Повторный сигнал завершит процесс сразу, не дожидаясь остановки
+		stop()
+	}
+
+	// Сначала перестаем принимать запросы и ждем текущие, затем останавливаем
+	// фоновые задачи и только потом закрываем хранилища, с которыми они работают.
+	timeout := envDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	slog.Info("shutting down, waiting for in-flight requests", "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("graceful shutdown failed, closing connections", "err", err)
+		srv.Close()
+	}
+	rateLimiter.Stop()
+	authManager.Stop()
+	notifier.Stop()
+	webhooks.Stop()
+	if err := st.Close(); err != nil {
+		slog.Error("failed to close stores", "err", err)
+		failed = true
+	}
+	slog.Info("stopped")
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// fatal пишет ошибку запуска и завершает процесс.
+func fatal(msg string, err error) {
+	if err != nil {
+		slog.Error(msg, "err", err)
+	} else {
+		slog.Error(msg)
+	}
+	os.Exit(1)
+}
+
+// envDuration читает длительность вида "12h" или "30m" из переменной окружения.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		fatal("invalid "+name, err)
+	}
+	return d
+}
+
+// envInt читает целое число из переменной окружения.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		fatal("invalid "+name, fmt.Errorf("%q is not a positive integer", v))
+	}
+	return n
+}
+
+// openRateLimitBackend возвращает общий бэкенд лимитов, если задан RATE_LIMIT_REDIS_URL;
+// иначе nil - счетчики в памяти процесса. Недоступный при старте Redis не фатален:
+// запросы решаются по RATE_LIMIT_FAIL_OPEN, пока он не вернется.
+func openRateLimitBackend(ctx context.Context) ratelimit.Backend {
+	redisURL := os.Getenv("RATE_LIMIT_REDIS_URL")
+	if redisURL == "" {
+		return nil
+	}
+	backend, err := ratelimit.NewRedisBackend(ratelimit.RedisConfig{
+		URL:     redisURL,
+		Timeout: envDuration("RATE_LIMIT_REDIS_TIMEOUT", 250*time.Millisecond),
+	})
+	if err != nil {
+		fatal("invalid RATE_LIMIT_REDIS_URL", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := backend.Ping(ctx); err != nil {
+		slog.Warn("rate limit redis is unreachable", "err", err)
+	} else {
+		slog.Info("rate limit counters are shared via redis")
+	}
+	return backend
+}
+
+// openNotifier включает письма, если задан SMTP_ADDR; иначе возвращает nil.
+func openNotifier(ctx context.Context, queue notify.Queue, baseURL string) (*notify.Notifier, error) {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		slog.Info("SMTP_ADDR is not set, email notifications are disabled")
+		return nil, nil
+	}
+	sender, err := notify.NewSMTPSender(notify.SMTPConfig{
+		Addr:     addr,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+		Timeout:  envDuration("SMTP_TIMEOUT", 30*time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := templ.LoadEmail()
+	if err != nil {
+		return nil, err
+	}
+	var hrMailbox []string
+	for _, addr := range strings.Split(os.Getenv("HR_MAILBOX"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			hrMailbox = append(hrMailbox, addr)
+		}
+	}
+	if len(hrMailbox) == 0 {
+		slog.Warn("HR_MAILBOX is not set, nobody will be notified about new complaints")
 	}
+	slog.Info("sending email", "smtp", addr)
+	return notify.New(notify.Config{
+		Context:   ctx,
+		Queue:     queue,
+		Sender:    sender,
+		Templates: tmpl,
+		HRMailbox: hrMailbox,
+		BaseURL:   baseURL,
+	}), nil
 }
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/notify"
+	"donos-hrm/internal/ratelimit"
+	"donos-hrm/internal/storage"
+	"donos-hrm/internal/webhook"
+)
+
+// stores - все хранилища приложения, открытые одним драйвером STORAGE_DRIVER.
+type stores struct {
+	complaints storage.Store
+	roles      auth.RoleStore
+	sessions   auth.SessionStore
+	tokens     auth.TokenStore
+	blobs      storage.BlobStore
+	audit      storage.AuditStore
+	mail       notify.Queue
+	webhooks   webhook.Store
+	quotas     ratelimit.QuotaStore
+	access     ratelimit.AccessStore
+
+	driver  string
+	dataDir string  // каталог данных file-драйвера
+	db      *sql.DB // база sqlite-драйвера
+}
+
+// openStores выбирает хранилище по STORAGE_DRIVER: file (по умолчанию), sqlite или memory.
+func openStores() (*stores, error) {
+	driver := strings.ToLower(os.Getenv("STORAGE_DRIVER"))
+	switch driver {
+	case "", "file":
+		dataFile := os.Getenv("DATA_FILE")
+		if dataFile == "" {
+			dataFile = "data/complaints.json"
+		}
+		dataDir := filepath.Dir(dataFile)
+		// Создаем директорию если не существует
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return nil, fmt.Errorf("create data directory: %w", err)
+		}
+		slog.Info("using data file", "path", dataFile)
+
+		complaints, err := storage.NewFileStore(dataFile)
+		if err != nil {
+			return nil, err
+		}
+		roles, err := auth.NewFileRoleStore(filepath.Join(dataDir, "roles.json"))
+		if err != nil {
+			return nil, err
+		}
+		sessions, err := auth.NewFileSessionStore(filepath.Join(dataDir, "sessions.json"))
+		if err != nil {
+			return nil, err
+		}
+		tokens, err := auth.NewFileTokenStore(filepath.Join(dataDir, "tokens.json"))
+		if err != nil {
+			return nil, err
+		}
+		blobs, err := openBlobStore(dataDir)
+		if err != nil {
+			return nil, err
+		}
+		audit, err := storage.NewFileAuditStore(filepath.Join(dataDir, "audit.log"))
+		if err != nil {
+			return nil, err
+		}
+		mail, err := notify.NewFileQueue(filepath.Join(dataDir, "mail_queue.json"))
+		if err != nil {
+			return nil, err
+		}
+		webhooks, err := webhook.NewFileStore(filepath.Join(dataDir, "webhooks.json"))
+		if err != nil {
+			return nil, err
+		}
+		quotas, err := ratelimit.NewFileQuotaStore(filepath.Join(dataDir, "quotas.json"))
+		if err != nil {
+			return nil, err
+		}
+		access, err := ratelimit.NewFileAccessStore(filepath.Join(dataDir, "access.json"))
+		if err != nil {
+			return nil, err
+		}
+		return &stores{
+			complaints: complaints, roles: roles, sessions: sessions, tokens: tokens, blobs: blobs, audit: audit, mail: mail, webhooks: webhooks,
+			quotas: quotas, access: access,
+			driver: "file", dataDir: dataDir,
+		}, nil
+	case "sqlite":
+		dsn := os.Getenv("DATABASE_URL")
+		if dsn == "" {
+			dsn = "data/complaints.db"
+		}
+		if path := sqlitePath(dsn); path != "" {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return nil, fmt.Errorf("create data directory: %w", err)
+			}
+		}
+		slog.Info("using sqlite database", "dsn", dsn)
+
+		complaints, err := storage.NewSQLiteStore(dsn)
+		if err != nil {
+			return nil, err
+		}
+		roles, err := auth.NewSQLRoleStore(complaints.DB())
+		if err != nil {
+			return nil, err
+		}
+		sessions, err := auth.NewSQLSessionStore(complaints.DB())
+		if err != nil {
+			return nil, err
+		}
+		tokens, err := auth.NewSQLTokenStore(complaints.DB())
+		if err != nil {
+			return nil, err
+		}
+		dataDir := "data"
+		if path := sqlitePath(dsn); path != "" {
+			dataDir = filepath.Dir(path)
+		}
+		blobs, err := openBlobStore(dataDir)
+		if err != nil {
+			return nil, err
+		}
+		audit, err := storage.NewSQLiteAuditStore(complaints.DB())
+		if err != nil {
+			return nil, err
+		}
+		mail, err := notify.NewSQLQueue(complaints.DB())
+		if err != nil {
+			return nil, err
+		}
+		webhooks, err := webhook.NewSQLStore(complaints.DB())
+		if err != nil {
+			return nil, err
+		}
+		quotas, err := ratelimit.NewSQLQuotaStore(complaints.DB())
+		if err != nil {
+			return nil, err
+		}
+		access, err := ratelimit.NewSQLAccessStore(complaints.DB())
+		if err != nil {
+			return nil, err
+		}
+		return &stores{
+			complaints: complaints, roles: roles, sessions: sessions, tokens: tokens, blobs: blobs, audit: audit, mail: mail, webhooks: webhooks,
+			quotas: quotas, access: access,
+			driver: "sqlite", db: complaints.DB(),
+		}, nil
+	case "memory":
+		slog.Warn("using in-memory store, data will be lost on restart")
+		return &stores{
+			complaints: storage.NewMemoryStore(),
+			roles:      auth.NewMemoryRoleStore(),
+			sessions:   auth.NewMemorySessionStore(),
+			tokens:     auth.NewMemoryTokenStore(),
+			blobs:      storage.NewMemoryBlobStore(),
+			audit:      storage.NewMemoryAuditStore(),
+			mail:       notify.NewMemoryQueue(),
+			webhooks:   webhook.NewMemoryStore(),
+			quotas:     ratelimit.NewMemoryQuotaStore(),
+			access:     ratelimit.NewMemoryAccessStore(),
+			driver:     "memory",
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_DRIVER %q", driver)
+	}
+}
+
+// Close закрывает хранилища, которые держат файлы или соединения: журнал аудита
+// и базу SQLite. Остальные пишут на диск синхронно, им сбрасывать нечего.
+// Вызывается после остановки фоновых задач, которые с ними работают.
+func (st *stores) Close() error {
+	var errs []error
+	// Журнал аудита первым: SQLiteAuditStore работает через базу жалоб
+	for _, s := range []any{st.audit, st.complaints} {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkWritable проверяет, что хранилище принимает запись: file-драйвер создает и
+// удаляет пробный файл в каталоге данных, sqlite пишет в базу внутри отмененной транзакции.
+func (st *stores) checkWritable() error {
+	switch st.driver {
+	case "file":
+		f, err := os.CreateTemp(st.dataDir, ".readyz-*")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	case "sqlite":
+		tx, err := st.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		// Запись в служебную таблицу берет блокировку на запись, как и настоящие изменения
+		_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS readiness_probe (id INTEGER PRIMARY KEY);
+			INSERT OR REPLACE INTO readiness_probe (id) VALUES (1);`)
+		return err
+	}
+	return nil
+}
+
+// openBlobStore открывает каталог вложений: BLOB_DIR или blobs рядом с данными.
+func openBlobStore(dataDir string) (storage.BlobStore, error) {
+	dir := os.Getenv("BLOB_DIR")
+	if dir == "" {
+		dir = filepath.Join(dataDir, "blobs")
+	}
+	slog.Info("using blob directory", "path", dir)
+	return storage.NewLocalBlobStore(dir)
+}
+
+// sqlitePath достает путь к файлу базы из DATABASE_URL, чтобы создать каталог.
+func sqlitePath(dsn string) string {
+	dsn = strings.TrimPrefix(dsn, "sqlite://")
+	dsn = strings.TrimPrefix(dsn, "file:")
+	if i := strings.IndexByte(dsn, '?'); i >= 0 {
+		dsn = dsn[:i]
+	}
+	if dsn == "" || dsn == ":memory:" {
+		return ""
+	}
+	return dsn
+}
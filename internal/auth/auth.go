@@ -3,10 +3,16 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -15,27 +21,148 @@ import (
 
 const sessionCookie = "session_token"
 
+const (
+	defaultAbsoluteTTL     = 7 * 24 * time.Hour
+	defaultIdleTTL         = 24 * time.Hour
+	defaultJanitorInterval = 10 * time.Minute
+	// LastSeen обновляется не чаще раза в минуту, чтобы не писать в хранилище на каждый запрос
+	touchInterval = time.Minute
+)
+
+type Config struct {
+	ClientID     string
+	ClientSecret string
+	BaseURL      string
+	Policy       *AccessPolicy
+	Sessions     SessionStore  // По умолчанию MemorySessionStore
+	AbsoluteTTL  time.Duration // Максимальное время жизни сессии
+	IdleTTL      time.Duration // Время жизни сессии без активности
+	JanitorInt   time.Duration // Интервал удаления истекших сессий
+	// ClientIP определяет адрес клиента для метаданных сессии; по умолчанию RemoteAddr.
+	ClientIP func(r *http.Request) string
+	Tokens   TokenStore // Персональные API-токены; по умолчанию MemoryTokenStore
+	// PseudonymKey - секрет для псевдонимов анонимных авторов. Без него генерируется
+	// случайный ключ, и после перезапуска авторы перестают видеть свои анонимные жалобы.
+	PseudonymKey []byte
+}
+
 type Manager struct {
 	config       *oauth2.Config
 	store        SessionStore
+	tokens       TokenStore
+	pseudonymKey []byte
+	logIDKey     []byte
+	policy       *AccessPolicy
 	secureCookie bool
+	absoluteTTL  time.Duration
+	idleTTL      time.Duration
+	clientIP     func(r *http.Request) string
+	janitor      *time.Ticker
+	stopJanitor  chan struct{}
+	janitorDone  chan struct{}
+	now          func() time.Time // часы; подменяются в тестах
 }
 
-func NewManager(clientID, clientSecret, baseURL string) *Manager {
-	secure := strings.HasPrefix(strings.ToLower(baseURL), "https://")
-	return &Manager{
+func NewManager(cfg Config) *Manager {
+	if cfg.Policy == nil {
+		cfg.Policy = &AccessPolicy{}
+	}
+	if cfg.Sessions == nil {
+		cfg.Sessions = NewMemorySessionStore()
+	}
+	if cfg.AbsoluteTTL == 0 {
+		cfg.AbsoluteTTL = defaultAbsoluteTTL
+	}
+	if cfg.IdleTTL == 0 {
+		cfg.IdleTTL = defaultIdleTTL
+	}
+	if cfg.JanitorInt == 0 {
+		cfg.JanitorInt = defaultJanitorInterval
+	}
+	if cfg.ClientIP == nil {
+		cfg.ClientIP = remoteIP
+	}
+	if cfg.Tokens == nil {
+		cfg.Tokens = NewMemoryTokenStore()
+	}
+	if len(cfg.PseudonymKey) == 0 {
+		cfg.PseudonymKey = make([]byte, 32)
+		rand.Read(cfg.PseudonymKey)
+	}
+	secure := strings.HasPrefix(strings.ToLower(cfg.BaseURL), "https://")
+	m := &Manager{
 		config: &oauth2.Config{
-			ClientID:     clientID,
-			ClientSecret: clientSecret,
-			RedirectURL:  fmt.Sprintf("%s/auth/google/callback", baseURL),
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  fmt.Sprintf("%s/auth/google/callback", cfg.BaseURL),
 			Scopes: []string{
 				oauth2v2.UserinfoEmailScope,
 			},
 			Endpoint: google.Endpoint,
 		},
-		store:        NewMemorySessionStore(),
+		store:        cfg.Sessions,
+		tokens:       cfg.Tokens,
+		pseudonymKey: cfg.PseudonymKey,
+		logIDKey:     deriveLogIDKey(cfg.PseudonymKey),
+		policy:       cfg.Policy,
 		secureCookie: secure,
+		absoluteTTL:  cfg.AbsoluteTTL,
+		idleTTL:      cfg.IdleTTL,
+		clientIP:     cfg.ClientIP,
+		janitor:      time.NewTicker(cfg.JanitorInt),
+		stopJanitor:  make(chan struct{}),
+		janitorDone:  make(chan struct{}),
+		now:          time.Now,
+	}
+	go m.janitorLoop()
+	return m
+}
+
+func (m *Manager) janitorLoop() {
+	defer close(m.janitorDone)
+	for {
+		select {
+		case <-m.janitor.C:
+			m.deleteExpired()
+		case <-m.stopJanitor:
+			return
+		}
+	}
+}
+
+func (m *Manager) deleteExpired() {
+	now := m.now()
+	n, err := m.store.DeleteExpired(now.Add(-m.absoluteTTL), now.Add(-m.idleTTL))
+	if err != nil {
+		slog.Error("session janitor failed", "err", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("session janitor: removed expired sessions", "count", n)
+	}
+}
+
+// Stop останавливает фоновую очистку сессий и ждет, пока текущий проход закончится,
+// чтобы хранилище сессий можно было закрыть сразу после.
+func (m *Manager) Stop() {
+	m.janitor.Stop()
+	close(m.stopJanitor)
+	<-m.janitorDone
+}
+
+// ActiveSessions - число сессий, срок которых еще не истек.
+func (m *Manager) ActiveSessions() (int, error) {
+	now := m.now()
+	return m.store.CountActive(now.Add(-m.absoluteTTL), now.Add(-m.idleTTL))
+}
+
+// CheckSessions проверяет, что хранилище сессий отвечает: ищет заведомо несуществующую сессию.
+func (m *Manager) CheckSessions() error {
+	_, err := m.store.Get("readiness-probe")
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
 	}
+	return err
 }
 
 func (m *Manager) LoginURL(state string) string {
@@ -50,51 +177,125 @@ func (m *Manager) Client(ctx context.Context, token *oauth2.Token) *http.Client
 	return m.config.Client(ctx, token)
 }
 
-func (m *Manager) GetEmail(ctx context.Context, client *http.Client) (string, error) {
+func (m *Manager) GetUserInfo(ctx context.Context, client *http.Client) (UserInfo, error) {
 	svc, err := oauth2v2.New(client)
 	if err != nil {
-		return "", err
+		return UserInfo{}, err
 	}
 	info, err := svc.Userinfo.Get().Context(ctx).Do()
 	if err != nil {
-		return "", err
+		return UserInfo{}, err
 	}
 	if info == nil || info.Email == "" {
-		return "", fmt.Errorf("no email found")
+		return UserInfo{}, fmt.Errorf("no email found")
 	}
-	return info.Email, nil
+	verified := info.VerifiedEmail != nil && *info.VerifiedEmail
+	return UserInfo{Email: info.Email, VerifiedEmail: verified, HostedDomain: info.Hd}, nil
 }
 
-func (m *Manager) CreateSession(w http.ResponseWriter, email string) (string, error) {
+// Authorize проверяет пользователя по политике доступа; см. AccessPolicy.Authorize.
+func (m *Manager) Authorize(info UserInfo) error {
+	return m.policy.Authorize(info)
+}
+
+func (m *Manager) CreateSession(w http.ResponseWriter, r *http.Request, email string) (Session, error) {
 	token, err := randomToken(32)
 	if err != nil {
-		return "", err
+		return Session{}, err
+	}
+	csrf, err := randomToken(32)
+	if err != nil {
+		return Session{}, err
+	}
+	now := m.now()
+	sess := Session{
+		ID:        sessionID(token),
+		Email:     email,
+		CreatedAt: now,
+		LastSeen:  now,
+		IP:        m.clientIP(r),
+		UserAgent: r.UserAgent(),
+		CSRFToken: csrf,
+	}
+	if err := m.store.Save(sess); err != nil {
+		return Session{}, err
 	}
-	m.store.Set(token, email)
 	http.SetCookie(w, &http.Cookie{
 		Name:     sessionCookie,
 		Value:    token,
 		Path:     "/",
+		MaxAge:   int(m.absoluteTTL.Seconds()),
 		HttpOnly: true,
 		Secure:   m.secureCookie,
 		SameSite: http.SameSiteLaxMode,
 	})
-	return token, nil
+	return sess, nil
+}
+
+// HasSessionCookie сообщает, передал ли запрос cookie сессии, не проверяя саму сессию.
+func HasSessionCookie(r *http.Request) bool {
+	_, err := r.Cookie(sessionCookie)
+	return err == nil
 }
 
 func (m *Manager) GetSession(r *http.Request) (string, bool) {
+	sess, ok := m.CurrentSession(r)
+	return sess.Email, ok
+}
+
+// CurrentSession возвращает действующую сессию запроса, продлевая ее по активности.
+func (m *Manager) CurrentSession(r *http.Request) (Session, bool) {
 	c, err := r.Cookie(sessionCookie)
 	if err != nil {
-		return "", false
+		return Session{}, false
+	}
+	sess, err := m.store.Get(sessionID(c.Value))
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			slog.ErrorContext(r.Context(), "load session", "err", err)
+		}
+		return Session{}, false
+	}
+
+	now := m.now()
+	if m.expired(sess, now) {
+		if err := m.store.Delete(sess.ID); err != nil {
+			slog.ErrorContext(r.Context(), "delete expired session", "err", err)
+		}
+		return Session{}, false
+	}
+
+	touch := now.Sub(sess.LastSeen) > touchInterval
+	// Сессии, созданные до появления CSRF-токенов, получают токен при первом обращении
+	if sess.CSRFToken == "" {
+		if sess.CSRFToken, err = randomToken(32); err != nil {
+			slog.ErrorContext(r.Context(), "generate csrf token", "err", err)
+			return Session{}, false
+		}
+		touch = true
 	}
-	email, ok := m.store.Get(c.Value)
-	return email, ok
+
+	if touch {
+		sess.LastSeen = now
+		sess.IP = m.clientIP(r)
+		sess.UserAgent = r.UserAgent()
+		if err := m.store.Save(sess); err != nil {
+			slog.ErrorContext(r.Context(), "touch session", "err", err)
+		}
+	}
+	return sess, true
+}
+
+func (m *Manager) expired(sess Session, now time.Time) bool {
+	return now.Sub(sess.CreatedAt) > m.absoluteTTL || now.Sub(sess.LastSeen) > m.idleTTL
 }
 
 func (m *Manager) DeleteSession(w http.ResponseWriter, r *http.Request) {
 	c, err := r.Cookie(sessionCookie)
 	if err == nil {
-		m.store.Delete(c.Value)
+		if err := m.store.Delete(sessionID(c.Value)); err != nil {
+			slog.ErrorContext(r.Context(), "delete session", "err", err)
+		}
 	}
 	http.SetCookie(w, &http.Cookie{
 		Name:   sessionCookie,
@@ -105,6 +306,144 @@ func (m *Manager) DeleteSession(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// ListSessions возвращает действующие сессии пользователя, последние активные первыми.
+func (m *Manager) ListSessions(email string) ([]Session, error) {
+	all, err := m.store.ListByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	active := all[:0]
+	for _, sess := range all {
+		if !m.expired(sess, now) {
+			active = append(active, sess)
+		}
+	}
+	return active, nil
+}
+
+// RevokeSession завершает сессию id, только если она принадлежит email.
+func (m *Manager) RevokeSession(email, id string) error {
+	sess, err := m.store.Get(id)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(sess.Email, email) {
+		return ErrSessionNotFound
+	}
+	return m.store.Delete(id)
+}
+
+// RevokeOtherSessions завершает все сессии пользователя, кроме keepID.
+func (m *Manager) RevokeOtherSessions(email, keepID string) error {
+	sessions, err := m.store.ListByEmail(email)
+	if err != nil {
+		return err
+	}
+	for _, sess := range sessions {
+		if sess.ID == keepID {
+			continue
+		}
+		if err := m.store.Delete(sess.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeAllSessions принудительно разлогинивает пользователя на всех устройствах.
+func (m *Manager) RevokeAllSessions(email string) error {
+	return m.store.DeleteByEmail(email)
+}
+
+// CreateToken выпускает персональный токен. Открытое значение возвращается только здесь,
+// в хранилище попадает лишь его хеш. ttl == 0 - токен без срока действия.
+func (m *Manager) CreateToken(email, name string, scopes []Scope, ttl time.Duration) (string, APIToken, error) {
+	if len(scopes) == 0 {
+		return "", APIToken{}, errors.New("at least one scope required")
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	id, err := randomToken(9)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	raw := tokenPrefix + secret
+	now := m.now()
+	t := APIToken{
+		ID:        id,
+		Email:     email,
+		Name:      name,
+		Hash:      sessionID(raw),
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		t.ExpiresAt = now.Add(ttl)
+	}
+	if err := m.tokens.Create(t); err != nil {
+		return "", APIToken{}, err
+	}
+	return raw, t, nil
+}
+
+// AuthenticateToken находит действующий токен по его открытому значению.
+func (m *Manager) AuthenticateToken(raw string) (APIToken, error) {
+	if !strings.HasPrefix(raw, tokenPrefix) {
+		return APIToken{}, ErrTokenNotFound
+	}
+	t, err := m.tokens.GetByHash(sessionID(raw))
+	if err != nil {
+		return APIToken{}, err
+	}
+	now := m.now()
+	if t.Expired(now) {
+		return APIToken{}, ErrTokenExpired
+	}
+	if now.Sub(t.LastUsed) > touchInterval {
+		if err := m.tokens.Touch(t.ID, now); err != nil {
+			slog.Error("touch token", "err", err)
+		}
+		t.LastUsed = now
+	}
+	return t, nil
+}
+
+func (m *Manager) ListTokens(email string) ([]APIToken, error) {
+	return m.tokens.ListByEmail(email)
+}
+
+// RevokeToken удаляет токен id, только если он принадлежит email.
+func (m *Manager) RevokeToken(email, id string) error {
+	return m.tokens.Delete(email, id)
+}
+
+// BearerToken достает токен из заголовка Authorization: Bearer.
+func BearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	scheme, token, ok := strings.Cut(h, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
 func randomToken(length int) (string, error) {
 	buf := make([]byte, length)
 	if _, err := rand.Read(buf); err != nil {
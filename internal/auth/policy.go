@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AccessPolicy решает, кого пускать после входа через Google.
+// Доступ дает любое из правил Domains, HostedDomains или AllowEmails; DenyEmails побеждает все.
+// Пустая политика никого не пускает.
+type AccessPolicy struct {
+	// Domains - домены почты: "example.com" совпадает только точно,
+	// "*.example.com" - с любым поддоменом (но не с самим example.com).
+	Domains []string `json:"domains"`
+	// HostedDomains сверяются с claim hd аккаунта Google Workspace.
+	HostedDomains []string `json:"hosted_domains"`
+	AllowEmails   []string `json:"allow_emails"`
+	DenyEmails    []string `json:"deny_emails"`
+}
+
+// UserInfo - данные аккаунта, по которым принимается решение о доступе.
+type UserInfo struct {
+	Email         string
+	VerifiedEmail bool
+	HostedDomain  string
+}
+
+// AccessDeniedError возвращается Authorize; Reason пишется в лог, пользователю не показывается.
+type AccessDeniedError struct {
+	Reason string
+}
+
+func (e *AccessDeniedError) Error() string {
+	return "access denied: " + e.Reason
+}
+
+// LoadAccessPolicy читает политику из JSON-файла (если path не пустой)
+// и дополняет ее списками из AUTH_ALLOWED_DOMAINS, AUTH_HOSTED_DOMAINS,
+// AUTH_ALLOWED_EMAILS и AUTH_DENIED_EMAILS (через запятую).
+func LoadAccessPolicy(path string) (*AccessPolicy, error) {
+	p := &AccessPolicy{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read access policy: %w", err)
+		}
+		if err := json.Unmarshal(data, p); err != nil {
+			return nil, fmt.Errorf("parse access policy: %w", err)
+		}
+	}
+	p.Domains = append(p.Domains, splitList(os.Getenv("AUTH_ALLOWED_DOMAINS"))...)
+	p.HostedDomains = append(p.HostedDomains, splitList(os.Getenv("AUTH_HOSTED_DOMAINS"))...)
+	p.AllowEmails = append(p.AllowEmails, splitList(os.Getenv("AUTH_ALLOWED_EMAILS"))...)
+	p.DenyEmails = append(p.DenyEmails, splitList(os.Getenv("AUTH_DENIED_EMAILS"))...)
+	p.normalize()
+	return p, nil
+}
+
+func (p *AccessPolicy) normalize() {
+	for _, list := range [][]string{p.Domains, p.HostedDomains, p.AllowEmails, p.DenyEmails} {
+		for i := range list {
+			list[i] = strings.ToLower(strings.TrimSpace(list[i]))
+		}
+	}
+}
+
+func (p *AccessPolicy) IsEmpty() bool {
+	return len(p.Domains) == 0 && len(p.HostedDomains) == 0 && len(p.AllowEmails) == 0
+}
+
+// Authorize возвращает nil, если пользователь допущен, иначе *AccessDeniedError с причиной.
+func (p *AccessPolicy) Authorize(info UserInfo) error {
+	email := strings.ToLower(strings.TrimSpace(info.Email))
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 || at == len(email)-1 {
+		return &AccessDeniedError{Reason: "malformed email"}
+	}
+	if !info.VerifiedEmail {
+		return &AccessDeniedError{Reason: "email not verified"}
+	}
+	if contains(p.DenyEmails, email) {
+		return &AccessDeniedError{Reason: "email is on deny list"}
+	}
+	if contains(p.AllowEmails, email) {
+		return nil
+	}
+
+	domain := email[at+1:]
+	for _, pattern := range p.Domains {
+		if matchDomain(pattern, domain) {
+			return nil
+		}
+	}
+
+	if hd := strings.ToLower(info.HostedDomain); hd != "" && contains(p.HostedDomains, hd) {
+		return nil
+	}
+
+	if info.HostedDomain == "" {
+		return &AccessDeniedError{Reason: fmt.Sprintf("domain %q not allowed", domain)}
+	}
+	return &AccessDeniedError{Reason: fmt.Sprintf("domain %q (hd %q) not allowed", domain, info.HostedDomain)}
+}
+
+func matchDomain(pattern, domain string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(domain, "."+suffix)
+	}
+	return pattern == domain
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	var result []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	p := &AccessPolicy{
+		Domains:       []string{"pynest.com", "*.corp.example"},
+		HostedDomains: []string{"workspace.example"},
+		AllowEmails:   []string{"contractor@gmail.com", "boss@pynest.org"},
+		DenyEmails:    []string{"fired@pynest.com", "bad@eu.corp.example", "boss@pynest.org"},
+	}
+	tests := []struct {
+		name  string
+		email string
+		hd    string
+		allow bool
+	}{
+		{"exact domain", "user@pynest.com", "", true},
+		{"prefix of the domain", "user@evilpynest.com", "", false},
+		{"domain as a subdomain", "user@pynest.com.evil.io", "", false},
+		{"subdomain of an exact domain", "user@mail.pynest.com", "", false},
+		{"pynest in the local part", "pynest@gmail.com", "", false},
+		{"wildcard subdomain", "user@eu.corp.example", "", true},
+		{"wildcard nested subdomain", "user@a.b.corp.example", "", true},
+		{"wildcard apex", "user@corp.example", "", false},
+		{"wildcard look-alike", "user@evilcorp.example", "", false},
+		{"allowed email", "contractor@gmail.com", "", true},
+		{"other email on an allowed email's domain", "someone@gmail.com", "", false},
+		{"denied email in an allowed domain", "fired@pynest.com", "", false},
+		{"denied email in a wildcard domain", "bad@eu.corp.example", "", false},
+		{"deny beats allow email", "boss@pynest.org", "", false},
+		{"hosted domain", "user@custom.example", "workspace.example", true},
+		{"hosted domain case", "user@custom.example", "Workspace.Example", true},
+		{"other hosted domain", "user@custom.example", "other.example", false},
+		{"hd does not stand in for the domain list", "user@custom.example", "pynest.com", false},
+		{"email case", "User@PyNest.COM", "", true},
+		{"denied email case", "Fired@PYNEST.com", "", false},
+		{"surrounding spaces", " user@pynest.com ", "", true},
+		{"no domain", "user@", "", false},
+		{"no local part", "@pynest.com", "", false},
+		{"no at sign", "pynest.com", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Authorize(UserInfo{Email: tt.email, VerifiedEmail: true, HostedDomain: tt.hd})
+			if allowed := err == nil; allowed != tt.allow {
+				t.Fatalf("Authorize(%q, hd %q) = %v, want allowed %v", tt.email, tt.hd, err, tt.allow)
+			}
+			var denied *AccessDeniedError
+			if err != nil && !errors.As(err, &denied) {
+				t.Fatalf("error %T, want *AccessDeniedError", err)
+			}
+		})
+	}
+
+	if err := p.Authorize(UserInfo{Email: "user@pynest.com"}); err == nil {
+		t.Error("unverified email allowed")
+	}
+	if err := (&AccessPolicy{}).Authorize(UserInfo{Email: "user@pynest.com", VerifiedEmail: true}); err == nil {
+		t.Error("empty policy allowed a user")
+	}
+}
+
+func TestLoadAccessPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	data := `{"domains": [" PyNest.com "], "deny_emails": ["Fired@PyNest.com"]}`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AUTH_ALLOWED_DOMAINS", "*.Corp.Example, ")
+	t.Setenv("AUTH_HOSTED_DOMAINS", "Workspace.Example")
+	t.Setenv("AUTH_ALLOWED_EMAILS", "Contractor@Gmail.com")
+	t.Setenv("AUTH_DENIED_EMAILS", " bad@eu.corp.example ")
+
+	p, err := LoadAccessPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.IsEmpty() {
+		t.Fatal("policy is empty")
+	}
+	allowed := map[string]bool{
+		"user@pynest.com":      true,
+		"fired@pynest.com":     false,
+		"user@eu.corp.example": true,
+		"bad@eu.corp.example":  false,
+		"contractor@gmail.com": true,
+		"user@evilpynest.com":  false,
+		"user@custom.example":  false,
+	}
+	for email, want := range allowed {
+		if got := p.Authorize(UserInfo{Email: email, VerifiedEmail: true}) == nil; got != want {
+			t.Errorf("Authorize(%q) allowed = %v, want %v", email, got, want)
+		}
+	}
+	if err := p.Authorize(UserInfo{Email: "user@custom.example", VerifiedEmail: true, HostedDomain: "workspace.example"}); err != nil {
+		t.Errorf("hosted domain from the environment: %v", err)
+	}
+
+	if _, err := LoadAccessPolicy(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing policy file accepted")
+	}
+	if err := os.WriteFile(path, []byte(`{"domains": "pynest.com"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAccessPolicy(path); err == nil {
+		t.Error("malformed policy file accepted")
+	}
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	pseudonymPrefix = "anon-"
+	logIDPrefix     = "user-"
+	// logIDDomain отделяет ключ идентификаторов для логов от ключа псевдонимов.
+	logIDDomain = "donos-hrm log id v1"
+)
+
+// Pseudonym возвращает стабильный псевдоним пользователя: HMAC-SHA256 от email на серверном
+// секрете. Один и тот же автор всегда получает один псевдоним, поэтому его жалобы можно
+// связать и ограничить, но без секрета email по псевдониму не восстановить.
+func (m *Manager) Pseudonym(email string) string {
+	return keyedID(m.pseudonymKey, pseudonymPrefix, email)
+}
+
+// LogID возвращает стабильный идентификатор пользователя для логов. Ключ выведен из
+// секрета псевдонимов под другим доменом, поэтому LogID не совпадает с псевдонимом
+// анонимных жалоб: по логу с IP нельзя найти, кто написал жалобу.
+func (m *Manager) LogID(email string) string {
+	return keyedID(m.logIDKey, logIDPrefix, email)
+}
+
+func deriveLogIDKey(pseudonymKey []byte) []byte {
+	mac := hmac.New(sha256.New, pseudonymKey)
+	mac.Write([]byte(logIDDomain))
+	return mac.Sum(nil)
+}
+
+func keyedID(key []byte, prefix, email string) string {
+	if email == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(normalizeEmail(email)))
+	return prefix + hex.EncodeToString(mac.Sum(nil))[:20]
+}
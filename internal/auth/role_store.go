@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RoleStore хранит назначенные роли. Пользователь без записи считается RoleReporter.
+type RoleStore interface {
+	Role(email string) (Role, error)
+	// Grant назначает роль; назначение RoleReporter равносильно Revoke.
+	Grant(email string, role Role, grantedBy string) error
+	Revoke(email string) error
+	List() ([]RoleAssignment, error)
+}
+
+type MemoryRoleStore struct {
+	mu    sync.RWMutex
+	roles map[string]RoleAssignment
+}
+
+func NewMemoryRoleStore() *MemoryRoleStore {
+	return &MemoryRoleStore{roles: make(map[string]RoleAssignment)}
+}
+
+func (s *MemoryRoleStore) Role(email string) (Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if a, ok := s.roles[normalizeEmail(email)]; ok {
+		return a.Role, nil
+	}
+	return RoleReporter, nil
+}
+
+func (s *MemoryRoleStore) Grant(email string, role Role, grantedBy string) error {
+	if role == RoleReporter {
+		return s.Revoke(email)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	email = normalizeEmail(email)
+	s.roles[email] = RoleAssignment{Email: email, Role: role, GrantedBy: grantedBy, GrantedAt: time.Now()}
+	return nil
+}
+
+func (s *MemoryRoleStore) Revoke(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.roles, normalizeEmail(email))
+	return nil
+}
+
+func (s *MemoryRoleStore) List() ([]RoleAssignment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedAssignments(s.roles), nil
+}
+
+// FileRoleStore держит роли в памяти и переписывает JSON-файл при каждом изменении.
+type FileRoleStore struct {
+	mu       sync.RWMutex
+	filePath string
+	roles    map[string]RoleAssignment
+}
+
+func NewFileRoleStore(filePath string) (*FileRoleStore, error) {
+	s := &FileRoleStore{filePath: filePath, roles: make(map[string]RoleAssignment)}
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var list []RoleAssignment
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		for _, a := range list {
+			s.roles[normalizeEmail(a.Email)] = a
+		}
+	}
+	return s, nil
+}
+
+// save вызывается под s.mu.
+func (s *FileRoleStore) save() error {
+	data, err := json.MarshalIndent(sortedAssignments(s.roles), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filePath, data)
+}
+
+func (s *FileRoleStore) Role(email string) (Role, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if a, ok := s.roles[normalizeEmail(email)]; ok {
+		return a.Role, nil
+	}
+	return RoleReporter, nil
+}
+
+func (s *FileRoleStore) Grant(email string, role Role, grantedBy string) error {
+	if role == RoleReporter {
+		return s.Revoke(email)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	email = normalizeEmail(email)
+	prev, had := s.roles[email]
+	s.roles[email] = RoleAssignment{Email: email, Role: role, GrantedBy: grantedBy, GrantedAt: time.Now()}
+	if err := s.save(); err != nil {
+		// Откатываем изменение
+		if had {
+			s.roles[email] = prev
+		} else {
+			delete(s.roles, email)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileRoleStore) Revoke(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	email = normalizeEmail(email)
+	prev, had := s.roles[email]
+	if !had {
+		return nil
+	}
+	delete(s.roles, email)
+	if err := s.save(); err != nil {
+		s.roles[email] = prev
+		return err
+	}
+	return nil
+}
+
+func (s *FileRoleStore) List() ([]RoleAssignment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedAssignments(s.roles), nil
+}
+
+// SQLRoleStore хранит роли в таблице user_roles общей базы.
+type SQLRoleStore struct {
+	db *sql.DB
+}
+
+func NewSQLRoleStore(db *sql.DB) (*SQLRoleStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS user_roles (
+		email      TEXT    PRIMARY KEY,
+		role       TEXT    NOT NULL,
+		granted_by TEXT    NOT NULL,
+		granted_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLRoleStore{db: db}, nil
+}
+
+func (s *SQLRoleStore) Role(email string) (Role, error) {
+	var role Role
+	err := s.db.QueryRow(`SELECT role FROM user_roles WHERE email = ?`, normalizeEmail(email)).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RoleReporter, nil
+	}
+	return role, err
+}
+
+func (s *SQLRoleStore) Grant(email string, role Role, grantedBy string) error {
+	if role == RoleReporter {
+		return s.Revoke(email)
+	}
+	_, err := s.db.Exec(`INSERT INTO user_roles (email, role, granted_by, granted_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(email) DO UPDATE SET role = excluded.role, granted_by = excluded.granted_by, granted_at = excluded.granted_at`,
+		normalizeEmail(email), role, grantedBy, time.Now().UnixNano())
+	return err
+}
+
+func (s *SQLRoleStore) Revoke(email string) error {
+	_, err := s.db.Exec(`DELETE FROM user_roles WHERE email = ?`, normalizeEmail(email))
+	return err
+}
+
+func (s *SQLRoleStore) List() ([]RoleAssignment, error) {
+	rows, err := s.db.Query(`SELECT email, role, granted_by, granted_at FROM user_roles ORDER BY email`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []RoleAssignment
+	for rows.Next() {
+		var (
+			a  RoleAssignment
+			at int64
+		)
+		if err := rows.Scan(&a.Email, &a.Role, &a.GrantedBy, &at); err != nil {
+			return nil, err
+		}
+		a.GrantedAt = time.Unix(0, at)
+		result = append(result, a)
+	}
+	return result, rows.Err()
+}
+
+func sortedAssignments(m map[string]RoleAssignment) []RoleAssignment {
+	result := make([]RoleAssignment, 0, len(m))
+	for _, a := range m {
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Email < result[j].Email })
+	return result
+}
+
+// writeFileAtomic пишет во временный файл и переименовывает его поверх целевого.
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileAtomicMode(path, data, 0644)
+}
+
+func writeFileAtomicMode(path string, data []byte, perm os.FileMode) error {
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, path)
+}
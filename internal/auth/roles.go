@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"strings"
+	"time"
+)
+
+type Role string
+
+const (
+	// RoleReporter - роль по умолчанию: подавать жалобы и видеть открытые.
+	RoleReporter Role = "reporter"
+	// RoleReviewer читает все жалобы, включая скрытые, и ничего не меняет.
+	RoleReviewer Role = "reviewer"
+	// RoleModerator дополнительно меняет статус, скрывает жалобы и ведет переписку.
+	RoleModerator Role = "moderator"
+	// RoleAdmin дополнительно управляет ролями.
+	RoleAdmin Role = "admin"
+)
+
+// Roles перечисляет роли от младшей к старшей.
+var Roles = []Role{RoleReporter, RoleReviewer, RoleModerator, RoleAdmin}
+
+func ParseRole(s string) (Role, bool) {
+	for _, r := range Roles {
+		if string(r) == s {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if role == r {
+			return i
+		}
+	}
+	return 0
+}
+
+// AtLeast сообщает, что роль не младше required.
+func (r Role) AtLeast(required Role) bool {
+	return r.rank() >= required.rank()
+}
+
+func (r Role) Label() string {
+	if r == "" {
+		return "Reporter"
+	}
+	return strings.ToUpper(string(r[:1])) + string(r[1:])
+}
+
+type Permission uint
+
+const (
+	PermSubmit Permission = 1 << iota
+	PermReadAll
+	PermModerate
+	PermManageRoles
+	PermPurge
+	PermComment // отвечать в переписке по чужим жалобам и оставлять внутренние заметки
+)
+
+// PermissionSet - набор прав, который получают шаблоны и обработчики.
+type PermissionSet uint
+
+func (r Role) Permissions() PermissionSet {
+	p := PermissionSet(PermSubmit)
+	if r.AtLeast(RoleReviewer) {
+		p |= PermissionSet(PermReadAll)
+	}
+	if r.AtLeast(RoleModerator) {
+		p |= PermissionSet(PermModerate) | PermissionSet(PermComment)
+	}
+	if r.AtLeast(RoleAdmin) {
+		p |= PermissionSet(PermManageRoles) | PermissionSet(PermPurge)
+	}
+	return p
+}
+
+func (p PermissionSet) Has(perm Permission) bool {
+	return uint(p)&uint(perm) != 0
+}
+
+// Методы ниже удобно вызывать из шаблонов: {{if .Perms.CanModerate}}.
+
+func (p PermissionSet) CanSubmit() bool      { return p.Has(PermSubmit) }
+func (p PermissionSet) CanReadAll() bool     { return p.Has(PermReadAll) }
+func (p PermissionSet) CanModerate() bool    { return p.Has(PermModerate) }
+func (p PermissionSet) CanManageRoles() bool { return p.Has(PermManageRoles) }
+func (p PermissionSet) CanPurge() bool       { return p.Has(PermPurge) }
+func (p PermissionSet) CanComment() bool     { return p.Has(PermComment) }
+
+type RoleAssignment struct {
+	Email     string    `json:"email"`
+	Role      Role      `json:"role"`
+	GrantedBy string    `json:"granted_by"`
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -1,37 +1,379 @@
 package auth
 
-import "sync"
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session хранится по ID - SHA-256 от токена из cookie, сам токен на сервере не сохраняется.
+type Session struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	// CSRFToken - synchronizer token для форм, живет столько же, сколько сессия.
+	CSRFToken string `json:"csrf_token"`
+}
 
 type SessionStore interface {
-	Set(token, email string)
-	Get(token string) (string, bool)
-	Delete(token string)
+	// Save создает сессию или обновляет существующую с тем же ID.
+	Save(s Session) error
+	Get(id string) (Session, error)
+	Delete(id string) error
+	ListByEmail(email string) ([]Session, error)
+	DeleteByEmail(email string) error
+	// DeleteExpired удаляет сессии, созданные до createdBefore или неактивные с idleBefore.
+	DeleteExpired(createdBefore, idleBefore time.Time) (int, error)
+	// CountActive считает сессии, которые DeleteExpired с теми же аргументами оставил бы.
+	CountActive(createdBefore, idleBefore time.Time) (int, error)
 }
 
 type MemorySessionStore struct {
 	mu    sync.RWMutex
-	store map[string]string
+	store map[string]Session
 }
 
 func NewMemorySessionStore() *MemorySessionStore {
-	return &MemorySessionStore{store: make(map[string]string)}
+	return &MemorySessionStore{store: make(map[string]Session)}
+}
+
+func (s *MemorySessionStore) Save(sess Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store[sess.ID] = sess
+	return nil
+}
+
+func (s *MemorySessionStore) Get(id string) (Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess, ok := s.store[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return sess, nil
+}
+
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.store, id)
+	return nil
+}
+
+func (s *MemorySessionStore) ListByEmail(email string) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sessionsByEmail(s.store, email), nil
+}
+
+func (s *MemorySessionStore) DeleteByEmail(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleteByEmail(s.store, email)
+	return nil
 }
 
-func (s *MemorySessionStore) Set(token, email string) {
+func (s *MemorySessionStore) DeleteExpired(createdBefore, idleBefore time.Time) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.store[token] = email
+	return deleteExpired(s.store, createdBefore, idleBefore), nil
 }
 
-func (s *MemorySessionStore) Get(token string) (string, bool) {
+func (s *MemorySessionStore) CountActive(createdBefore, idleBefore time.Time) (int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	email, ok := s.store[token]
-	return email, ok
+	return countActive(s.store, createdBefore, idleBefore), nil
+}
+
+// FileSessionStore держит сессии в памяти и переписывает JSON-файл при каждом изменении.
+type FileSessionStore struct {
+	mu       sync.RWMutex
+	filePath string
+	store    map[string]Session
 }
 
-func (s *MemorySessionStore) Delete(token string) {
+func NewFileSessionStore(filePath string) (*FileSessionStore, error) {
+	s := &FileSessionStore{filePath: filePath, store: make(map[string]Session)}
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var list []Session
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		for _, sess := range list {
+			s.store[sess.ID] = sess
+		}
+	}
+	return s, nil
+}
+
+// save вызывается под s.mu.
+func (s *FileSessionStore) save() error {
+	list := make([]Session, 0, len(s.store))
+	for _, sess := range s.store {
+		list = append(list, sess)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Файл содержит email и IP, поэтому только для владельца
+	return writeFileAtomicMode(s.filePath, data, 0600)
+}
+
+// update применяет изменение и сохраняет файл, откатывая изменение при ошибке записи.
+func (s *FileSessionStore) update(change func(map[string]Session) bool) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.store, token)
+	prev := make(map[string]Session, len(s.store))
+	for k, v := range s.store {
+		prev[k] = v
+	}
+	if !change(s.store) {
+		return nil
+	}
+	if err := s.save(); err != nil {
+		s.store = prev
+		return err
+	}
+	return nil
+}
+
+func (s *FileSessionStore) Save(sess Session) error {
+	return s.update(func(m map[string]Session) bool {
+		m[sess.ID] = sess
+		return true
+	})
+}
+
+func (s *FileSessionStore) Get(id string) (Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sess, ok := s.store[id]
+	if !ok {
+		return Session{}, ErrSessionNotFound
+	}
+	return sess, nil
+}
+
+func (s *FileSessionStore) Delete(id string) error {
+	return s.update(func(m map[string]Session) bool {
+		if _, ok := m[id]; !ok {
+			return false
+		}
+		delete(m, id)
+		return true
+	})
+}
+
+func (s *FileSessionStore) ListByEmail(email string) ([]Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sessionsByEmail(s.store, email), nil
+}
+
+func (s *FileSessionStore) DeleteByEmail(email string) error {
+	return s.update(func(m map[string]Session) bool {
+		return deleteByEmail(m, email) > 0
+	})
+}
+
+func (s *FileSessionStore) DeleteExpired(createdBefore, idleBefore time.Time) (int, error) {
+	var n int
+	err := s.update(func(m map[string]Session) bool {
+		n = deleteExpired(m, createdBefore, idleBefore)
+		return n > 0
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *FileSessionStore) CountActive(createdBefore, idleBefore time.Time) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return countActive(s.store, createdBefore, idleBefore), nil
+}
+
+// SQLSessionStore хранит сессии в таблице sessions общей базы.
+type SQLSessionStore struct {
+	db *sql.DB
+}
+
+func NewSQLSessionStore(db *sql.DB) (*SQLSessionStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+		id         TEXT    PRIMARY KEY,
+		email      TEXT    NOT NULL,
+		created_at INTEGER NOT NULL,
+		last_seen  INTEGER NOT NULL,
+		ip         TEXT    NOT NULL DEFAULT '',
+		user_agent TEXT    NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_email ON sessions(email);`)
+	if err != nil {
+		return nil, err
+	}
+	if err := addColumnIfMissing(db, "sessions", "csrf_token", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return nil, err
+	}
+	return &SQLSessionStore{db: db}, nil
+}
+
+// addColumnIfMissing добавляет колонку в таблицу, созданную предыдущей версией схемы.
+func addColumnIfMissing(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
+	return err
+}
+
+func (s *SQLSessionStore) Save(sess Session) error {
+	_, err := s.db.Exec(`INSERT INTO sessions (id, email, created_at, last_seen, ip, user_agent, csrf_token) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET last_seen = excluded.last_seen, ip = excluded.ip, user_agent = excluded.user_agent, csrf_token = excluded.csrf_token`,
+		sess.ID, normalizeEmail(sess.Email), sess.CreatedAt.UnixNano(), sess.LastSeen.UnixNano(), sess.IP, sess.UserAgent, sess.CSRFToken)
+	return err
+}
+
+func (s *SQLSessionStore) Get(id string) (Session, error) {
+	row := s.db.QueryRow(`SELECT id, email, created_at, last_seen, ip, user_agent, csrf_token FROM sessions WHERE id = ?`, id)
+	sess, err := scanSession(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, ErrSessionNotFound
+	}
+	return sess, err
+}
+
+func (s *SQLSessionStore) Delete(id string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	return err
+}
+
+func (s *SQLSessionStore) ListByEmail(email string) ([]Session, error) {
+	rows, err := s.db.Query(`SELECT id, email, created_at, last_seen, ip, user_agent, csrf_token
+		FROM sessions WHERE email = ? ORDER BY last_seen DESC`, normalizeEmail(email))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Session
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, sess)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLSessionStore) DeleteByEmail(email string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE email = ?`, normalizeEmail(email))
+	return err
+}
+
+func (s *SQLSessionStore) DeleteExpired(createdBefore, idleBefore time.Time) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE created_at < ? OR last_seen < ?`,
+		createdBefore.UnixNano(), idleBefore.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLSessionStore) CountActive(createdBefore, idleBefore time.Time) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sessions WHERE created_at >= ? AND last_seen >= ?`,
+		createdBefore.UnixNano(), idleBefore.UnixNano()).Scan(&n)
+	return n, err
+}
+
+func scanSession(row interface{ Scan(...any) error }) (Session, error) {
+	var (
+		sess              Session
+		created, lastSeen int64
+	)
+	if err := row.Scan(&sess.ID, &sess.Email, &created, &lastSeen, &sess.IP, &sess.UserAgent, &sess.CSRFToken); err != nil {
+		return Session{}, err
+	}
+	sess.CreatedAt = time.Unix(0, created)
+	sess.LastSeen = time.Unix(0, lastSeen)
+	return sess, nil
+}
+
+func sessionsByEmail(m map[string]Session, email string) []Session {
+	email = normalizeEmail(email)
+	var result []Session
+	for _, sess := range m {
+		if normalizeEmail(sess.Email) == email {
+			result = append(result, sess)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].LastSeen.After(result[j].LastSeen) })
+	return result
+}
+
+func deleteByEmail(m map[string]Session, email string) int {
+	email = normalizeEmail(email)
+	n := 0
+	for id, sess := range m {
+		if normalizeEmail(sess.Email) == email {
+			delete(m, id)
+			n++
+		}
+	}
+	return n
+}
+
+func deleteExpired(m map[string]Session, createdBefore, idleBefore time.Time) int {
+	n := 0
+	for id, sess := range m {
+		if sess.CreatedAt.Before(createdBefore) || sess.LastSeen.Before(idleBefore) {
+			delete(m, id)
+			n++
+		}
+	}
+	return n
+}
+
+func countActive(m map[string]Session, createdBefore, idleBefore time.Time) int {
+	n := 0
+	for _, sess := range m {
+		if !sess.CreatedAt.Before(createdBefore) && !sess.LastSeen.Before(idleBefore) {
+			n++
+		}
+	}
+	return n
 }
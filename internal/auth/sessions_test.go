@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"donos-hrm/internal/storage"
+)
+
+// fakeClock - часы Manager, которые двигает тест.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newSessionManager - Manager с подменными часами. Janitor с интервалом по умолчанию
+// за время теста не срабатывает и часов не читает.
+func newSessionManager(t *testing.T, cfg Config) (*Manager, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)}
+	cfg.BaseURL = "http://localhost"
+	m := NewManager(cfg)
+	m.now = clock.Now
+	t.Cleanup(m.Stop)
+	return m, clock
+}
+
+// login открывает сессию и возвращает ее cookie.
+func login(t *testing.T, m *Manager, email string) (*http.Cookie, Session) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	sess, err := m.CreateSession(rec, httptest.NewRequest(http.MethodGet, "/", nil), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("CreateSession set %d cookies, want 1", len(cookies))
+	}
+	return cookies[0], sess
+}
+
+func current(m *Manager, cookie *http.Cookie) (Session, bool) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	return m.CurrentSession(r)
+}
+
+// eachSessionStore запускает test для всех реализаций SessionStore.
+func eachSessionStore(t *testing.T, test func(t *testing.T, s SessionStore)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemorySessionStore())
+	})
+	t.Run("file", func(t *testing.T) {
+		s, err := NewFileSessionStore(filepath.Join(t.TempDir(), "sessions.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, s)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "sessions.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		s, err := NewSQLSessionStore(db.DB())
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, s)
+	})
+}
+
+func TestSessionIdleExpiry(t *testing.T) {
+	m, clock := newSessionManager(t, Config{AbsoluteTTL: 24 * time.Hour, IdleTTL: time.Hour})
+	cookie, created := login(t, m, "user@example.com")
+
+	// Активность продлевает сессию
+	for range 3 {
+		clock.Advance(59 * time.Minute)
+		sess, ok := current(m, cookie)
+		if !ok {
+			t.Fatalf("session expired while in use at %s", clock.Now())
+		}
+		if !sess.LastSeen.Equal(clock.Now()) {
+			t.Fatalf("LastSeen = %s, want %s", sess.LastSeen, clock.Now())
+		}
+	}
+
+	clock.Advance(61 * time.Minute)
+	if _, ok := current(m, cookie); ok {
+		t.Fatal("idle session still valid")
+	}
+	// Истекшая сессия удаляется при обращении
+	if _, err := m.store.Get(created.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expired session still stored: %v", err)
+	}
+}
+
+func TestSessionAbsoluteExpiry(t *testing.T) {
+	m, clock := newSessionManager(t, Config{AbsoluteTTL: 2 * time.Hour, IdleTTL: time.Hour})
+	cookie, _ := login(t, m, "user@example.com")
+
+	for range 4 {
+		clock.Advance(30 * time.Minute)
+		if _, ok := current(m, cookie); !ok {
+			t.Fatalf("session expired early at %s", clock.Now())
+		}
+	}
+	// Через AbsoluteTTL сессия истекает, как бы активно ею ни пользовались
+	clock.Advance(time.Second)
+	if _, ok := current(m, cookie); ok {
+		t.Fatal("session outlived AbsoluteTTL")
+	}
+}
+
+func TestSessionTouchInterval(t *testing.T) {
+	m, clock := newSessionManager(t, Config{})
+	cookie, created := login(t, m, "user@example.com")
+
+	clock.Advance(30 * time.Second)
+	if _, ok := current(m, cookie); !ok {
+		t.Fatal("session not found")
+	}
+	stored, err := m.store.Get(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.LastSeen.Equal(created.LastSeen) {
+		t.Fatalf("LastSeen written after %s, want at most once per %s", 30*time.Second, touchInterval)
+	}
+
+	clock.Advance(touchInterval)
+	if _, ok := current(m, cookie); !ok {
+		t.Fatal("session not found")
+	}
+	if stored, _ = m.store.Get(created.ID); !stored.LastSeen.Equal(clock.Now()) {
+		t.Fatalf("LastSeen = %s, want %s", stored.LastSeen, clock.Now())
+	}
+}
+
+func TestSessionJanitor(t *testing.T) {
+	eachSessionStore(t, func(t *testing.T, s SessionStore) {
+		m, clock := newSessionManager(t, Config{Sessions: s, AbsoluteTTL: 3 * time.Hour, IdleTTL: time.Hour})
+		oldCookie, old := login(t, m, "old@example.com") // доживет до AbsoluteTTL
+		clock.Advance(30 * time.Minute)
+		_, idle := login(t, m, "idle@example.com") // будет неактивна больше IdleTTL
+		clock.Advance(30 * time.Minute)
+		freshCookie, fresh := login(t, m, "fresh@example.com")
+
+		for range 4 {
+			clock.Advance(35 * time.Minute)
+			current(m, oldCookie)
+			current(m, freshCookie)
+		}
+		// Прошло 3ч20м от первой сессии; idle молчит 2ч50м, fresh создана 2ч20м назад и активна
+		if n, err := m.ActiveSessions(); err != nil || n != 1 {
+			t.Fatalf("ActiveSessions = %d, %v; want 1", n, err)
+		}
+		m.deleteExpired()
+		for _, sess := range []Session{old, idle} {
+			if _, err := s.Get(sess.ID); !errors.Is(err, ErrSessionNotFound) {
+				t.Errorf("session of %s survived the janitor: %v", sess.Email, err)
+			}
+		}
+		if _, err := s.Get(fresh.ID); err != nil {
+			t.Fatalf("active session removed: %v", err)
+		}
+	})
+}
+
+// Фоновая очистка сама удаляет истекшие сессии, а Stop ее останавливает.
+func TestSessionJanitorLoop(t *testing.T) {
+	s := NewMemorySessionStore()
+	m := NewManager(Config{BaseURL: "http://localhost", Sessions: s, IdleTTL: 10 * time.Millisecond, JanitorInt: 5 * time.Millisecond})
+	_, sess := login(t, m, "user@example.com")
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, err := s.Get(sess.ID); errors.Is(err, ErrSessionNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not remove the idle session")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	m.Stop()
+}
+
+func TestRevokeSessions(t *testing.T) {
+	m, clock := newSessionManager(t, Config{IdleTTL: time.Hour})
+	first, firstSess := login(t, m, "user@example.com")
+	second, secondSess := login(t, m, "User@Example.com")
+	third, _ := login(t, m, "user@example.com")
+	other, otherSess := login(t, m, "other@example.com")
+
+	if list, err := m.ListSessions("user@example.com"); err != nil || len(list) != 3 {
+		t.Fatalf("ListSessions = %d sessions, %v; want 3", len(list), err)
+	}
+
+	// Чужую сессию завершить нельзя
+	if err := m.RevokeSession("user@example.com", otherSess.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("RevokeSession of another user = %v, want ErrSessionNotFound", err)
+	}
+	if _, ok := current(m, other); !ok {
+		t.Fatal("another user's session was revoked")
+	}
+
+	if err := m.RevokeSession("USER@example.com", secondSess.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := current(m, second); ok {
+		t.Fatal("revoked session still valid")
+	}
+
+	if err := m.RevokeOtherSessions("user@example.com", firstSess.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := current(m, third); ok {
+		t.Fatal("RevokeOtherSessions kept another session")
+	}
+	if _, ok := current(m, first); !ok {
+		t.Fatal("RevokeOtherSessions ended the current session")
+	}
+
+	if err := m.RevokeAllSessions("user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := current(m, first); ok {
+		t.Fatal("RevokeAllSessions kept a session")
+	}
+	if _, ok := current(m, other); !ok {
+		t.Fatal("RevokeAllSessions ended another user's session")
+	}
+
+	// Истекшие сессии в списке не показываются
+	clock.Advance(2 * time.Hour)
+	if list, err := m.ListSessions("other@example.com"); err != nil || len(list) != 0 {
+		t.Fatalf("ListSessions after expiry = %d sessions, %v; want 0", len(list), err)
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	m, _ := newSessionManager(t, Config{})
+	cookie, _ := login(t, m, "user@example.com")
+
+	r := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	r.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	m.DeleteSession(rec, r)
+	if _, ok := current(m, cookie); ok {
+		t.Fatal("session valid after logout")
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || cookies[0].MaxAge >= 0 {
+		t.Fatalf("logout cookies %+v, want the session cookie cleared", cookies)
+	}
+}
@@ -0,0 +1,377 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTokenNotFound = errors.New("token not found")
+	ErrTokenExpired  = errors.New("token expired")
+)
+
+// Префикс помогает узнать токен в логах и сканерах секретов.
+const tokenPrefix = "dhrm_"
+
+type Scope string
+
+const (
+	ScopeReadOwn    Scope = "read:own"
+	ScopeSubmit     Scope = "submit"
+	ScopeAdminRead  Scope = "admin:read"
+	ScopeAdminWrite Scope = "admin:write"
+)
+
+// Scopes перечисляет все области действия токенов.
+var Scopes = []Scope{ScopeReadOwn, ScopeSubmit, ScopeAdminRead, ScopeAdminWrite}
+
+func ParseScope(s string) (Scope, bool) {
+	for _, sc := range Scopes {
+		if string(sc) == s {
+			return sc, true
+		}
+	}
+	return "", false
+}
+
+// MinRole - роль, без которой scope бесполезен: токен не дает больше прав, чем у владельца.
+func (s Scope) MinRole() Role {
+	switch s {
+	case ScopeAdminRead:
+		return RoleReviewer
+	case ScopeAdminWrite:
+		return RoleModerator
+	}
+	return RoleReporter
+}
+
+// APIToken - персональный токен. Хранится только SHA-256 хеш, сам токен показывается один раз.
+type APIToken struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"`
+	Scopes    []Scope   `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // нулевое значение - бессрочный
+	LastUsed  time.Time `json:"last_used,omitempty"`
+}
+
+func (t APIToken) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t APIToken) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && now.After(t.ExpiresAt)
+}
+
+type TokenStore interface {
+	Create(t APIToken) error
+	GetByHash(hash string) (APIToken, error)
+	ListByEmail(email string) ([]APIToken, error)
+	// Delete удаляет токен id, только если он принадлежит email.
+	Delete(email, id string) error
+	Touch(id string, at time.Time) error
+}
+
+type MemoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[string]APIToken // по ID
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: make(map[string]APIToken)}
+}
+
+func (s *MemoryTokenStore) Create(t APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[t.ID] = t
+	return nil
+}
+
+func (s *MemoryTokenStore) GetByHash(hash string) (APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return tokenByHash(s.tokens, hash)
+}
+
+func (s *MemoryTokenStore) ListByEmail(email string) ([]APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return tokensByEmail(s.tokens, email), nil
+}
+
+func (s *MemoryTokenStore) Delete(email, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return deleteToken(s.tokens, email, id)
+}
+
+func (s *MemoryTokenStore) Touch(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tokens[id]; ok {
+		t.LastUsed = at
+		s.tokens[id] = t
+	}
+	return nil
+}
+
+// FileTokenStore держит токены в памяти и переписывает JSON-файл при каждом изменении.
+type FileTokenStore struct {
+	mu       sync.RWMutex
+	filePath string
+	tokens   map[string]APIToken
+}
+
+func NewFileTokenStore(filePath string) (*FileTokenStore, error) {
+	s := &FileTokenStore{filePath: filePath, tokens: make(map[string]APIToken)}
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var list []APIToken
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		for _, t := range list {
+			s.tokens[t.ID] = t
+		}
+	}
+	return s, nil
+}
+
+// save вызывается под s.mu.
+func (s *FileTokenStore) save() error {
+	list := make([]APIToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomicMode(s.filePath, data, 0600)
+}
+
+func (s *FileTokenStore) Create(t APIToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[t.ID] = t
+	if err := s.save(); err != nil {
+		delete(s.tokens, t.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *FileTokenStore) GetByHash(hash string) (APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return tokenByHash(s.tokens, hash)
+}
+
+func (s *FileTokenStore) ListByEmail(email string) ([]APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return tokensByEmail(s.tokens, email), nil
+}
+
+func (s *FileTokenStore) Delete(email, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.tokens[id]
+	if err := deleteToken(s.tokens, email, id); err != nil {
+		return err
+	}
+	if err := s.save(); err != nil {
+		if ok {
+			s.tokens[id] = prev
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileTokenStore) Touch(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok {
+		return nil
+	}
+	prev := t
+	t.LastUsed = at
+	s.tokens[id] = t
+	if err := s.save(); err != nil {
+		s.tokens[id] = prev
+		return err
+	}
+	return nil
+}
+
+// SQLTokenStore хранит токены в таблице api_tokens общей базы.
+type SQLTokenStore struct {
+	db *sql.DB
+}
+
+func NewSQLTokenStore(db *sql.DB) (*SQLTokenStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS api_tokens (
+		id         TEXT    PRIMARY KEY,
+		email      TEXT    NOT NULL,
+		name       TEXT    NOT NULL,
+		hash       TEXT    NOT NULL UNIQUE,
+		scopes     TEXT    NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL DEFAULT 0,
+		last_used  INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_api_tokens_email ON api_tokens(email);`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLTokenStore{db: db}, nil
+}
+
+func (s *SQLTokenStore) Create(t APIToken) error {
+	_, err := s.db.Exec(`INSERT INTO api_tokens (id, email, name, hash, scopes, created_at, expires_at, last_used)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 0)`,
+		t.ID, normalizeEmail(t.Email), t.Name, t.Hash, joinScopes(t.Scopes), t.CreatedAt.UnixNano(), unixOrZero(t.ExpiresAt))
+	return err
+}
+
+func (s *SQLTokenStore) GetByHash(hash string) (APIToken, error) {
+	row := s.db.QueryRow(`SELECT id, email, name, hash, scopes, created_at, expires_at, last_used
+		FROM api_tokens WHERE hash = ?`, hash)
+	t, err := scanToken(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIToken{}, ErrTokenNotFound
+	}
+	return t, err
+}
+
+func (s *SQLTokenStore) ListByEmail(email string) ([]APIToken, error) {
+	rows, err := s.db.Query(`SELECT id, email, name, hash, scopes, created_at, expires_at, last_used
+		FROM api_tokens WHERE email = ? ORDER BY created_at`, normalizeEmail(email))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []APIToken
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLTokenStore) Delete(email, id string) error {
+	res, err := s.db.Exec(`DELETE FROM api_tokens WHERE id = ? AND email = ?`, id, normalizeEmail(email))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+func (s *SQLTokenStore) Touch(id string, at time.Time) error {
+	_, err := s.db.Exec(`UPDATE api_tokens SET last_used = ? WHERE id = ?`, at.UnixNano(), id)
+	return err
+}
+
+func scanToken(row interface{ Scan(...any) error }) (APIToken, error) {
+	var (
+		t                          APIToken
+		scopes                     string
+		created, expires, lastUsed int64
+	)
+	if err := row.Scan(&t.ID, &t.Email, &t.Name, &t.Hash, &scopes, &created, &expires, &lastUsed); err != nil {
+		return APIToken{}, err
+	}
+	t.Scopes = splitScopes(scopes)
+	t.CreatedAt = time.Unix(0, created)
+	if expires != 0 {
+		t.ExpiresAt = time.Unix(0, expires)
+	}
+	if lastUsed != 0 {
+		t.LastUsed = time.Unix(0, lastUsed)
+	}
+	return t, nil
+}
+
+func joinScopes(scopes []Scope) string {
+	parts := make([]string, len(scopes))
+	for i, s := range scopes {
+		parts[i] = string(s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func splitScopes(s string) []Scope {
+	var result []Scope
+	for _, part := range strings.Fields(s) {
+		result = append(result, Scope(part))
+	}
+	return result
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func tokenByHash(m map[string]APIToken, hash string) (APIToken, error) {
+	for _, t := range m {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
+	return APIToken{}, ErrTokenNotFound
+}
+
+func tokensByEmail(m map[string]APIToken, email string) []APIToken {
+	email = normalizeEmail(email)
+	var result []APIToken
+	for _, t := range m {
+		if normalizeEmail(t.Email) == email {
+			result = append(result, t)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	return result
+}
+
+func deleteToken(m map[string]APIToken, email, id string) error {
+	t, ok := m[id]
+	if !ok || normalizeEmail(t.Email) != normalizeEmail(email) {
+		return ErrTokenNotFound
+	}
+	delete(m, id)
+	return nil
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"donos-hrm/internal/storage"
+)
+
+// eachTokenStore запускает test для всех реализаций TokenStore.
+func eachTokenStore(t *testing.T, test func(t *testing.T, s TokenStore)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryTokenStore())
+	})
+	t.Run("file", func(t *testing.T) {
+		s, err := NewFileTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, s)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := storage.NewSQLiteStore(filepath.Join(t.TempDir(), "tokens.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		s, err := NewSQLTokenStore(db.DB())
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, s)
+	})
+}
+
+func newTokenManager(t *testing.T, tokens TokenStore) *Manager {
+	t.Helper()
+	m := NewManager(Config{BaseURL: "http://localhost", Tokens: tokens})
+	t.Cleanup(m.Stop)
+	return m
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	eachTokenStore(t, func(t *testing.T, s TokenStore) {
+		m := newTokenManager(t, s)
+		raw, created, err := m.CreateToken("User@Example.com", "ci", []Scope{ScopeReadOwn}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(raw, tokenPrefix) {
+			t.Fatalf("token %q lacks prefix %q", raw, tokenPrefix)
+		}
+		// Хранится только хеш
+		if created.Hash != sessionID(raw) || strings.Contains(created.Hash, strings.TrimPrefix(raw, tokenPrefix)) {
+			t.Fatalf("stored hash %q for token %q", created.Hash, raw)
+		}
+		if !created.ExpiresAt.IsZero() {
+			t.Fatalf("token without ttl expires at %s", created.ExpiresAt)
+		}
+
+		got, err := m.AuthenticateToken(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != created.ID || !strings.EqualFold(got.Email, "user@example.com") || got.LastUsed.IsZero() {
+			t.Fatalf("AuthenticateToken = %+v", got)
+		}
+
+		for _, bad := range []string{
+			"",
+			strings.TrimPrefix(raw, tokenPrefix), // без префикса
+			raw + "x",
+			tokenPrefix + "unknown",
+			created.Hash, // хеш из базы не подходит как токен
+		} {
+			if _, err := m.AuthenticateToken(bad); !errors.Is(err, ErrTokenNotFound) {
+				t.Errorf("AuthenticateToken(%q) = %v, want ErrTokenNotFound", bad, err)
+			}
+		}
+	})
+}
+
+func TestTokenExpiry(t *testing.T) {
+	eachTokenStore(t, func(t *testing.T, s TokenStore) {
+		m := newTokenManager(t, s)
+		raw, created, err := m.CreateToken("user@example.com", "ci", []Scope{ScopeSubmit}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Until(created.ExpiresAt); d <= 59*time.Minute || d > time.Hour {
+			t.Fatalf("token expires in %s, want 1h", d)
+		}
+		if _, err := m.AuthenticateToken(raw); err != nil {
+			t.Fatal(err)
+		}
+
+		expired := tokenPrefix + "expired"
+		err = s.Create(APIToken{
+			ID:        "old",
+			Email:     "user@example.com",
+			Hash:      sessionID(expired),
+			Scopes:    []Scope{ScopeSubmit},
+			CreatedAt: time.Now().Add(-2 * time.Hour),
+			ExpiresAt: time.Now().Add(-time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.AuthenticateToken(expired); !errors.Is(err, ErrTokenExpired) {
+			t.Fatalf("expired token: %v, want ErrTokenExpired", err)
+		}
+	})
+}
+
+func TestRevokeToken(t *testing.T) {
+	eachTokenStore(t, func(t *testing.T, s TokenStore) {
+		m := newTokenManager(t, s)
+		raw, created, err := m.CreateToken("user@example.com", "ci", []Scope{ScopeReadOwn}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := m.CreateToken("other@example.com", "ci", []Scope{ScopeReadOwn}, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		// Чужой токен отозвать нельзя
+		if err := m.RevokeToken("other@example.com", created.ID); !errors.Is(err, ErrTokenNotFound) {
+			t.Fatalf("RevokeToken by another user = %v, want ErrTokenNotFound", err)
+		}
+		if _, err := m.AuthenticateToken(raw); err != nil {
+			t.Fatalf("token revoked by another user: %v", err)
+		}
+
+		if err := m.RevokeToken("USER@example.com", created.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.AuthenticateToken(raw); !errors.Is(err, ErrTokenNotFound) {
+			t.Fatalf("revoked token: %v, want ErrTokenNotFound", err)
+		}
+		if list, _ := m.ListTokens("user@example.com"); len(list) != 0 {
+			t.Fatalf("tokens after revoke: %+v", list)
+		}
+		if list, _ := m.ListTokens("other@example.com"); len(list) != 1 {
+			t.Fatalf("other user's tokens: %+v", list)
+		}
+		if err := m.RevokeToken("user@example.com", created.ID); !errors.Is(err, ErrTokenNotFound) {
+			t.Fatalf("second revoke = %v, want ErrTokenNotFound", err)
+		}
+	})
+}
+
+func TestCreateTokenRequiresScope(t *testing.T) {
+	m := newTokenManager(t, NewMemoryTokenStore())
+	if _, _, err := m.CreateToken("user@example.com", "ci", nil, 0); err == nil {
+		t.Fatal("token without scopes created")
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{"Bearer dhrm_abc", "dhrm_abc", true},
+		{"bearer dhrm_abc", "dhrm_abc", true},
+		{"BEARER   dhrm_abc  ", "dhrm_abc", true},
+		{"", "", false},
+		{"Bearer", "", false},
+		{"Bearer ", "", false},
+		{"Basic dXNlcjpwYXNz", "", false},
+		{"Token dhrm_abc", "", false},
+		{"dhrm_abc", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		got, ok := BearerToken(r)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("BearerToken(%q) = %q, %v; want %q, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestScopes(t *testing.T) {
+	minRoles := map[Scope]Role{
+		ScopeReadOwn:    RoleReporter,
+		ScopeSubmit:     RoleReporter,
+		ScopeAdminRead:  RoleReviewer,
+		ScopeAdminWrite: RoleModerator,
+	}
+	for _, s := range Scopes {
+		if got := s.MinRole(); got != minRoles[s] {
+			t.Errorf("%s.MinRole() = %s, want %s", s, got, minRoles[s])
+		}
+		if parsed, ok := ParseScope(string(s)); !ok || parsed != s {
+			t.Errorf("ParseScope(%q) = %q, %v", s, parsed, ok)
+		}
+	}
+	for _, bad := range []string{"", "admin", "ADMIN:READ", "read:all"} {
+		if _, ok := ParseScope(bad); ok {
+			t.Errorf("ParseScope(%q) accepted", bad)
+		}
+	}
+
+	tok := APIToken{Scopes: []Scope{ScopeReadOwn, ScopeAdminRead}}
+	if !tok.HasScope(ScopeAdminRead) || tok.HasScope(ScopeAdminWrite) || tok.HasScope(ScopeSubmit) {
+		t.Errorf("HasScope on %v is wrong", tok.Scopes)
+	}
+	now := time.Now()
+	if (APIToken{}).Expired(now) || !(APIToken{ExpiresAt: now.Add(-time.Second)}).Expired(now) || (APIToken{ExpiresAt: now.Add(time.Second)}).Expired(now) {
+		t.Error("Expired is wrong")
+	}
+}
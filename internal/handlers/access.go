@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"donos-hrm/internal/ratelimit"
+	"donos-hrm/internal/storage"
+)
+
+// Вес неудачных входов для блокировок
+const (
+	failWeightFlow   = 1 // устаревшая ссылка или отказ Google бывают и у честного пользователя
+	failWeightDenied = 3 // вход аккаунтом без доступа - явная попытка
+)
+
+// Forbidden отвечает на запрос с заблокированного администратором адреса.
+func (h *Handler) Forbidden(w http.ResponseWriter, r *http.Request) {
+	if isAPIRequest(r) {
+		writeProblem(w, http.StatusForbidden, "access denied")
+		return
+	}
+	h.renderError(w, r, http.StatusForbidden, "Access denied", "Access from your network or account has been blocked. Contact HR if you think this is a mistake.")
+}
+
+// loginKeys - ключи блокировки входа: IP и, если он уже известен, email. Ключи,
+// разрешенные администратором, не блокируются.
+func (h *Handler) loginKeys(r *http.Request, email string) []string {
+	var keys []string
+	if ip := h.rateLimiter.GetIP(r); h.rateLimiter.IPAccess(ip) != ratelimit.AccessAllow {
+		keys = append(keys, "ip:"+ip)
+	}
+	if email != "" && h.rateLimiter.EmailAccess(email) != ratelimit.AccessAllow {
+		keys = append(keys, "email:"+strings.ToLower(email))
+	}
+	return keys
+}
+
+// lockedOut отвечает 429, если вход по одному из ключей заблокирован.
+func (h *Handler) lockedOut(w http.ResponseWriter, r *http.Request, keys []string) bool {
+	for _, key := range keys {
+		lk, locked := h.rateLimiter.Locked(r.Context(), key)
+		if !locked {
+			continue
+		}
+		keyType, _, _ := strings.Cut(key, ":")
+		slog.WarnContext(r.Context(), "login locked out", "key_type", keyType, "until", lk.LockedUntil.Format(time.RFC3339))
+		loginFailures.Inc(loginLockedOut)
+		res := ratelimit.LockoutResult(lk)
+		ratelimit.SetHeaders(w.Header(), res)
+		h.TooManyRequests(w, r, res)
+		return true
+	}
+	return false
+}
+
+// loginFailed учитывает неудачный вход и записывает в журнал начало блокировки.
+func (h *Handler) loginFailed(r *http.Request, email string, weight int) {
+	for _, key := range h.loginKeys(r, email) {
+		if lk, started := h.rateLimiter.Fail(r.Context(), key, weight); started {
+			h.audit(r, storage.AuditEntry{
+				Actor:  email,
+				Action: storage.AuditLoginLockout,
+				Target: key,
+				After:  "locked until " + lk.LockedUntil.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+}
+
+// HandleAccess - страница правил allow/ban и заблокированных после неудачных входов ключей.
+func (h *Handler) HandleAccess() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.renderAccess(w, r, "")
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, "invalid form", http.StatusBadRequest)
+				return
+			}
+			switch r.FormValue("action") {
+			case "add":
+				h.addAccessRule(w, r)
+			case "remove":
+				h.removeAccessRule(w, r)
+			case "unlock":
+				h.clearLockout(w, r)
+			default:
+				http.Error(w, "unknown action", http.StatusBadRequest)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (h *Handler) addAccessRule(w http.ResponseWriter, r *http.Request) {
+	value, err := ratelimit.ParseAccessValue(r.FormValue("value"))
+	if err != nil {
+		h.renderAccess(w, r, "Enter an IP address, a network in CIDR notation or an email.")
+		return
+	}
+	rule := ratelimit.AccessRule{
+		Value:  value,
+		Action: r.FormValue("rule"),
+		Reason: strings.TrimSpace(r.FormValue("reason")),
+		SetBy:  principalFrom(r).Email,
+		SetAt:  time.Now(),
+	}
+	if rule.Action != ratelimit.AccessAllow && rule.Action != ratelimit.AccessBan {
+		http.Error(w, "unknown rule", http.StatusBadRequest)
+		return
+	}
+	// Защита от случайной блокировки самого себя
+	if rule.Action == ratelimit.AccessBan && rule.Matches(h.rateLimiter.GetIP(r), principalFrom(r).Email) {
+		h.renderAccess(w, r, "This rule would ban your own address or account.")
+		return
+	}
+
+	before := h.accessLabel(value)
+	if err := h.rateLimiter.SetAccessRule(rule); err != nil {
+		slog.ErrorContext(r.Context(), "failed to set access rule", "err", err)
+		http.Error(w, "failed to update access rules", http.StatusInternalServerError)
+		return
+	}
+	after := rule.Action
+	if rule.Reason != "" {
+		after += ": " + rule.Reason
+	}
+	h.audit(r, storage.AuditEntry{
+		Action: storage.AuditAccessChange,
+		Target: value,
+		Before: before,
+		After:  after,
+	})
+	http.Redirect(w, r, "/admin/access", http.StatusSeeOther)
+}
+
+func (h *Handler) removeAccessRule(w http.ResponseWriter, r *http.Request) {
+	value := r.FormValue("value")
+	before := h.accessLabel(value)
+	if err := h.rateLimiter.DeleteAccessRule(value); err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete access rule", "err", err)
+		http.Error(w, "failed to update access rules", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, storage.AuditEntry{
+		Action: storage.AuditAccessChange,
+		Target: value,
+		Before: before,
+		After:  "none",
+	})
+	http.Redirect(w, r, "/admin/access", http.StatusSeeOther)
+}
+
+func (h *Handler) clearLockout(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	if err := h.rateLimiter.ClearLockout(r.Context(), key); err != nil {
+		slog.ErrorContext(r.Context(), "failed to clear lockout", "err", err)
+		http.Error(w, "failed to clear lockout", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, storage.AuditEntry{
+		Action: storage.AuditLockoutClear,
+		Target: key,
+	})
+	http.Redirect(w, r, "/admin/access", http.StatusSeeOther)
+}
+
+// accessLabel - правило для значения в журнале аудита.
+func (h *Handler) accessLabel(value string) string {
+	rules, err := h.rateLimiter.AccessRules()
+	if err != nil {
+		return "unknown"
+	}
+	for _, rule := range rules {
+		if rule.Value == value {
+			return rule.Action
+		}
+	}
+	return "none"
+}
+
+func (h *Handler) renderAccess(w http.ResponseWriter, r *http.Request, errMsg string) {
+	rules, err := h.rateLimiter.AccessRules()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list access rules", "err", err)
+		http.Error(w, "failed to list access rules", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Rules": rules,
+	}
+	// Недоступный бэкенд лимитов не мешает управлять правилами.
+	lockouts, err := h.rateLimiter.Lockouts(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list lockouts", "err", err)
+		data["LockoutsError"] = true
+	}
+	data["Lockouts"] = lockouts
+	if errMsg != "" {
+		data["Error"] = errMsg
+	}
+	h.renderTemplate(w, "layout", h.viewData(r, "Access control", "access", data))
+}
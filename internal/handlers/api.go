@@ -0,0 +1,403 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/ratelimit"
+	"donos-hrm/internal/storage"
+	"donos-hrm/internal/webhook"
+)
+
+// Максимальный размер JSON-тела запроса к API
+const maxAPIBody = 1 << 20
+
+// problem - тело ошибки в формате RFC 7807 (application/problem+json).
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type apiComplaint struct {
+	ID          int                    `json:"id"`
+	Reporter    string                 `json:"reporter,omitempty"`
+	Anonymous   bool                   `json:"anonymous"`
+	Subject     string                 `json:"subject"`
+	Description string                 `json:"description"`
+	CreatedAt   time.Time              `json:"created_at"`
+	Status      storage.Status         `json:"status,omitempty"`
+	Hidden      bool                   `json:"hidden,omitempty"`
+	History     []storage.StatusChange `json:"history,omitempty"`
+	Attachments []apiAttachment        `json:"attachments,omitempty"`
+}
+
+type apiAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url"`
+}
+
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// APIRequire - аналог RequireRole для API: вместо редиректа на /login отвечает 401/403 в JSON.
+// Лимит политики api считается по IP до проверки токена, чтобы перебор токенов тоже упирался в него.
+func (h *Handler) APIRequire(required auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	return h.rateLimiter.Middleware(ratelimit.PolicyAPI, func(w http.ResponseWriter, r *http.Request) {
+		p, status, detail := h.authorize(r, required)
+		if status != http.StatusOK {
+			if status == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			}
+			writeProblem(w, status, detail)
+			return
+		}
+		next(w, withPrincipal(r, p))
+	})
+}
+
+// APICreateComplaint: POST /api/v1/complaints
+func (h *Handler) APICreateComplaint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principalFrom(r)
+		var req struct {
+			Subject     string `json:"subject"`
+			Description string `json:"description"`
+			Anonymous   bool   `json:"anonymous"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		subject, description := strings.TrimSpace(req.Subject), strings.TrimSpace(req.Description)
+		if subject == "" || description == "" {
+			writeStoreError(w, storage.ErrMissingFields)
+			return
+		}
+		if res := h.reserveQuota(w, r, p.Email); !res.Allowed {
+			h.TooManyRequests(w, r, res.Result)
+			return
+		}
+
+		c, err := h.store.Add(storage.Complaint{
+			Reporter:    h.reporterID(p.Email, req.Anonymous),
+			Anonymous:   req.Anonymous,
+			Subject:     subject,
+			Description: description,
+		})
+		if err != nil {
+			h.refundQuota(w, r, p.Email)
+			writeStoreError(w, err)
+			return
+		}
+
+		complaintsCreated.Inc()
+		h.notifier.ComplaintCreated(c)
+		h.webhooks.Emit(webhook.EventComplaintCreated, webhook.Complaint(c))
+		w.Header().Set("Location", "/api/v1/complaints/"+strconv.Itoa(c.ID))
+		writeJSON(w, http.StatusCreated, h.toAPI(c, p))
+	}
+}
+
+// APIListComplaints: GET /api/v1/complaints - открытые жалобы, ?mine=true - свои (включая скрытые).
+// Поиск и страницы - как в apiQuery.
+func (h *Handler) APIListComplaints() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principalFrom(r)
+		q, ok := apiQuery(w, r)
+		if !ok {
+			return
+		}
+		if mine, _ := strconv.ParseBool(r.URL.Query().Get("mine")); mine {
+			q.Reporters = []string{p.Email, h.authManager.Pseudonym(p.Email)}
+		} else {
+			q.Hidden = storage.Bool(false)
+		}
+		h.writeComplaintPage(w, q, p)
+	}
+}
+
+// APIGetComplaint: GET /api/v1/complaints/{id}
+func (h *Handler) APIGetComplaint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principalFrom(r)
+		c, ok := h.loadComplaint(w, r)
+		if !ok {
+			return
+		}
+		// Скрытые жалобы видят только автор и сотрудники; для остальных их как будто нет
+		if c.Hidden && !p.Role.AtLeast(auth.RoleReviewer) && !h.owns(c, p.Email) {
+			writeProblem(w, http.StatusNotFound, storage.ErrNotFound.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, h.toAPI(c, p))
+	}
+}
+
+// APIListAllComplaints: GET /api/v1/admin/complaints[?status=...]
+func (h *Handler) APIListAllComplaints() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, ok := apiQuery(w, r)
+		if !ok {
+			return
+		}
+		v := r.URL.Query()
+		if s := v.Get("status"); s != "" {
+			var ok bool
+			if q.Status, ok = storage.ParseStatus(s); !ok {
+				writeProblem(w, http.StatusBadRequest, "unknown status")
+				return
+			}
+		}
+		if s := v.Get("hidden"); s != "" {
+			hidden, err := strconv.ParseBool(s)
+			if err != nil {
+				writeProblem(w, http.StatusBadRequest, "hidden must be true or false")
+				return
+			}
+			q.Hidden = &hidden
+		}
+		if reporter := strings.TrimSpace(v.Get("reporter")); reporter != "" {
+			q.Reporters = []string{reporter}
+		}
+		for key, dst := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+			if s := v.Get(key); s != "" {
+				t, err := time.Parse(time.RFC3339, s)
+				if err != nil {
+					writeProblem(w, http.StatusBadRequest, key+" must be an RFC 3339 timestamp")
+					return
+				}
+				*dst = t
+			}
+		}
+		h.writeComplaintPage(w, q, principalFrom(r))
+	}
+}
+
+// Предел размера страницы в API
+const maxAPILimit = 100
+
+// apiQuery разбирает общие параметры списков: q, sort, limit и cursor. Без limit
+// возвращаются все жалобы, как до появления страниц.
+func apiQuery(w http.ResponseWriter, r *http.Request) (storage.Query, bool) {
+	v := r.URL.Query()
+	q := storage.Query{
+		Text:   strings.TrimSpace(v.Get("q")),
+		Sort:   storage.SortNewest,
+		Cursor: v.Get("cursor"),
+	}
+	if s := v.Get("sort"); s != "" {
+		var ok bool
+		if q.Sort, ok = storage.ParseSort(s); !ok {
+			writeProblem(w, http.StatusBadRequest, "unknown sort order")
+			return q, false
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxAPILimit {
+			writeProblem(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAPILimit))
+			return q, false
+		}
+		q.Limit = limit
+	}
+	return q, true
+}
+
+// writeComplaintPage выполняет запрос и отдает страницу вместе с курсором следующей.
+func (h *Handler) writeComplaintPage(w http.ResponseWriter, q storage.Query, p principal) {
+	page, err := h.store.Query(q)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		slog.Error("api: query complaints", "err", err)
+		writeProblem(w, http.StatusInternalServerError, "failed to list complaints")
+		return
+	}
+
+	result := make([]apiComplaint, 0, len(page.Complaints))
+	for _, c := range page.Complaints {
+		result = append(result, h.toAPI(c, p))
+	}
+	body := map[string]any{"complaints": result, "total": page.Total}
+	if page.NextCursor != "" {
+		body["next_cursor"] = page.NextCursor
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// APIUpdateComplaint: PATCH /api/v1/admin/complaints/{id} с полями hidden и/или status, note.
+func (h *Handler) APIUpdateComplaint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principalFrom(r)
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+
+		var req struct {
+			Hidden *bool   `json:"hidden"`
+			Status *string `json:"status"`
+			Note   string  `json:"note"`
+		}
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Hidden == nil && req.Status == nil {
+			writeProblem(w, http.StatusBadRequest, "nothing to update: set hidden and/or status")
+			return
+		}
+
+		// Оба поля проверяются до изменений и применяются одной операцией: ошибка
+		// не оставляет жалобу измененной наполовину.
+		u := storage.ComplaintUpdate{Hidden: req.Hidden, Note: strings.TrimSpace(req.Note)}
+		if req.Status != nil {
+			status, ok := storage.ParseStatus(*req.Status)
+			if !ok {
+				writeProblem(w, http.StatusBadRequest, "unknown status")
+				return
+			}
+			u.Status = &status
+		}
+		if err := h.updateComplaint(r, id, u); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		c, err := h.store.Get(id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, h.toAPI(c, p))
+	}
+}
+
+// NotFound и MethodNotAllowed отвечают в формате problem details для /api/ и HTML-страницей для остального.
+func (h *Handler) NotFound() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAPIRequest(r) {
+			writeProblem(w, http.StatusNotFound, "no such endpoint")
+			return
+		}
+		h.renderError(w, r, http.StatusNotFound, "Page not found", "The page you are looking for does not exist.")
+	})
+}
+
+func (h *Handler) MethodNotAllowed() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAPIRequest(r) {
+			writeProblem(w, http.StatusMethodNotAllowed, "")
+			return
+		}
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	})
+}
+
+func (h *Handler) loadComplaint(w http.ResponseWriter, r *http.Request) (storage.Complaint, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "invalid id")
+		return storage.Complaint{}, false
+	}
+	c, err := h.store.Get(id)
+	if err != nil {
+		writeStoreError(w, err)
+		return storage.Complaint{}, false
+	}
+	return c, true
+}
+
+// toAPI показывает статус и историю так же, как HTML: статус - сотрудникам и автору,
+// историю - только сотрудникам. Псевдоним анонимного автора видят сотрудники и сам автор.
+func (h *Handler) toAPI(c storage.Complaint, p principal) apiComplaint {
+	staff := p.Role.AtLeast(auth.RoleReviewer)
+	own := h.owns(c, p.Email)
+	out := apiComplaint{
+		ID:          c.ID,
+		Anonymous:   c.Anonymous,
+		Subject:     c.Subject,
+		Description: c.Description,
+		CreatedAt:   c.CreatedAt,
+	}
+	if !c.Anonymous || staff || own {
+		out.Reporter = c.Reporter
+	}
+	if staff || own {
+		out.Status = c.Status
+		out.Hidden = c.Hidden
+	}
+	if staff {
+		out.History = c.History
+	}
+	// Ссылки на вложения нужны только тем, кто может их скачать
+	if staff || own {
+		for _, a := range c.Attachments {
+			out.Attachments = append(out.Attachments, apiAttachment{
+				Name:        a.Name,
+				ContentType: a.ContentType,
+				Size:        a.Size,
+				URL:         "/complaints/" + strconv.Itoa(c.ID) + "/attachments/" + a.Key,
+			})
+		}
+	}
+	return out
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIBody)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		writeProblem(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		writeProblem(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, storage.ErrInvalidTransition):
+		writeProblem(w, http.StatusConflict, err.Error())
+	case errors.Is(err, storage.ErrMissingFields):
+		writeProblem(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		slog.Error("api: store error", "err", err)
+		writeProblem(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func writeProblem(w http.ResponseWriter, status int, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("api: encode response", "err", err)
+	}
+}
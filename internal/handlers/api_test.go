@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/storage"
+)
+
+// failingUpdates - хранилище, которое не может применить изменения.
+type failingUpdates struct {
+	storage.Store
+}
+
+func (failingUpdates) Update(int, storage.ComplaintUpdate) (storage.Complaint, error) {
+	return storage.Complaint{}, errors.New("disk full")
+}
+
+func TestAPIUpdateComplaintIsAtomic(t *testing.T) {
+	e := newTestEnv(t)
+	e.roles.Grant("mod@example.com", auth.RoleModerator, "test")
+	cookie, _ := e.signIn(t, "mod@example.com")
+	c, err := e.store.Add(storage.Complaint{Reporter: "user@example.com", Subject: "s", Description: "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPatch, "/api/v1/admin/complaints/"+strconv.Itoa(c.ID), strings.NewReader(body))
+		r.AddCookie(cookie)
+		r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(c.ID)})
+		rec := httptest.NewRecorder()
+		e.APIRequire(auth.RoleModerator, e.APIUpdateComplaint())(rec, r)
+		return rec
+	}
+	unchanged := func(t *testing.T) {
+		t.Helper()
+		got, err := e.store.Get(c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Hidden || got.Status != storage.StatusNew {
+			t.Errorf("complaint changed: hidden=%v status=%s", got.Hidden, got.Status)
+		}
+		if actions := e.auditActions(t); len(actions) != 0 {
+			t.Errorf("audit entries %v for a failed update", actions)
+		}
+	}
+
+	t.Run("unknown status", func(t *testing.T) {
+		if rec := patch(`{"hidden": true, "status": "closed"}`); rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", rec.Code)
+		}
+		unchanged(t)
+	})
+	t.Run("invalid transition", func(t *testing.T) {
+		if rec := patch(`{"hidden": true, "status": "resolved"}`); rec.Code != http.StatusConflict {
+			t.Fatalf("status = %d, want 409", rec.Code)
+		}
+		unchanged(t)
+	})
+	t.Run("store failure", func(t *testing.T) {
+		store := e.Handler.store
+		e.Handler.store = failingUpdates{store}
+		defer func() { e.Handler.store = store }()
+		if rec := patch(`{"hidden": true, "status": "triaged"}`); rec.Code != http.StatusInternalServerError {
+			t.Fatalf("status = %d, want 500", rec.Code)
+		}
+		unchanged(t)
+	})
+	t.Run("both fields", func(t *testing.T) {
+		if rec := patch(`{"hidden": true, "status": "triaged", "note": "dup"}`); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+		}
+		got, err := e.store.Get(c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Hidden || got.Status != storage.StatusTriaged {
+			t.Fatalf("got hidden=%v status=%s", got.Hidden, got.Status)
+		}
+		want := []string{storage.AuditComplaintHide, storage.AuditComplaintStatus}
+		if actions := e.auditActions(t); strings.Join(actions, ",") != strings.Join(want, ",") {
+			t.Fatalf("audit actions = %v, want %v", actions, want)
+		}
+	})
+}
+
+// staleGets - хранилище, чей Get отстает от параллельной правки другого модератора.
+type staleGets struct {
+	storage.Store
+	stale storage.Complaint
+}
+
+func (s staleGets) Get(int) (storage.Complaint, error) {
+	return s.stale, nil
+}
+
+// Before в журнале берется из самой операции изменения, а не из отдельного чтения.
+func TestUpdateAuditBefore(t *testing.T) {
+	e := newTestEnv(t)
+	e.roles.Grant("mod@example.com", auth.RoleModerator, "test")
+	cookie, _ := e.signIn(t, "mod@example.com")
+	c, err := e.store.Add(storage.Complaint{Reporter: "user@example.com", Subject: "s", Description: "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Другой модератор успел скрыть жалобу и перевести ее в работу
+	hidden, triaged := true, storage.StatusTriaged
+	if _, err := e.store.Update(c.ID, storage.ComplaintUpdate{Status: &triaged, Hidden: &hidden}); err != nil {
+		t.Fatal(err)
+	}
+	e.Handler.store = staleGets{Store: e.store, stale: c}
+
+	r := httptest.NewRequest(http.MethodPatch, "/api/v1/admin/complaints/"+strconv.Itoa(c.ID), strings.NewReader(`{"hidden": false, "status": "investigating"}`))
+	r.AddCookie(cookie)
+	r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(c.ID)})
+	rec := httptest.NewRecorder()
+	e.APIRequire(auth.RoleModerator, e.APIUpdateComplaint())(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	entries, err := e.audit.Entries(storage.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := make(map[string]string)
+	for _, entry := range entries {
+		before[entry.Action] = entry.Before
+	}
+	if got := before[storage.AuditComplaintStatus]; got != string(storage.StatusTriaged) {
+		t.Errorf("status change Before = %q, want %q", got, storage.StatusTriaged)
+	}
+	if got := before[storage.AuditComplaintUnhide]; got != "true" {
+		t.Errorf("unhide Before = %q, want \"true\"", got)
+	}
+}
+
+// Статус чужой жалобы в API не виден, как и в HTML-списке.
+func TestAPIStatusVisibility(t *testing.T) {
+	e := newTestEnv(t)
+	e.roles.Grant("reviewer@example.com", auth.RoleReviewer, "test")
+	own, err := e.store.Add(storage.Complaint{Reporter: "User@Example.com", Subject: "mine", Description: "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := e.store.Add(storage.Complaint{Reporter: "other@example.com", Subject: "theirs", Description: "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	triaged := storage.StatusTriaged
+	for _, id := range []int{own.ID, other.ID} {
+		if _, err := e.store.Update(id, storage.ComplaintUpdate{Status: &triaged}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// fetch возвращает жалобы из списка и из GET по id с ключами "list <subject>" и "get <subject>"
+	fetch := func(t *testing.T, email string) map[string]map[string]any {
+		t.Helper()
+		cookie, _ := e.signIn(t, email)
+		got := make(map[string]map[string]any)
+		decode := func(rec *httptest.ResponseRecorder, dst any) {
+			t.Helper()
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), dst); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/complaints", nil)
+		r.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		e.APIRequire(auth.RoleReporter, e.APIListComplaints())(rec, r)
+		var list struct {
+			Complaints []map[string]any `json:"complaints"`
+		}
+		decode(rec, &list)
+		for _, c := range list.Complaints {
+			got["list "+c["subject"].(string)] = c
+		}
+		for _, id := range []int{own.ID, other.ID} {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/complaints/"+strconv.Itoa(id), nil)
+			r.AddCookie(cookie)
+			r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(id)})
+			rec := httptest.NewRecorder()
+			e.APIRequire(auth.RoleReporter, e.APIGetComplaint())(rec, r)
+			var c map[string]any
+			decode(rec, &c)
+			got["get "+c["subject"].(string)] = c
+		}
+		return got
+	}
+
+	tests := []struct {
+		email     string
+		seesMine  bool
+		seesTheir bool
+	}{
+		{"user@example.com", true, false},
+		{"stranger@example.com", false, false},
+		{"reviewer@example.com", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			got := fetch(t, tt.email)
+			for _, via := range []string{"list", "get"} {
+				for subject, want := range map[string]bool{"mine": tt.seesMine, "theirs": tt.seesTheir} {
+					c, ok := got[via+" "+subject]
+					if !ok {
+						t.Fatalf("%s %q missing", via, subject)
+					}
+					if _, has := c["status"]; has != want {
+						t.Errorf("%s %q: status shown = %v, want %v", via, subject, has, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+
+	"donos-hrm/internal/storage"
+)
+
+const (
+	defaultMaxFileSize = 10 << 20
+	defaultMaxFiles    = 5
+	// Максимальный размер обычной формы без файлов
+	maxFormBody = 1 << 20
+	// Объекты моложе этого возраста сборщик мусора не трогает: их жалоба может еще сохраняться
+	blobGracePeriod = time.Hour
+)
+
+// Типы файлов, которые можно приложить. Тип определяется по содержимому, а не по имени
+// или заголовку от браузера.
+var allowedAttachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// UploadConfig - настройки вложений. Без Blobs загрузка файлов выключена.
+type UploadConfig struct {
+	Blobs       storage.BlobStore
+	MaxFileSize int64 // байт на файл; по умолчанию 10 МБ
+	MaxFiles    int   // файлов на жалобу; по умолчанию 5
+}
+
+func (c UploadConfig) withDefaults() UploadConfig {
+	if c.MaxFileSize <= 0 {
+		c.MaxFileSize = defaultMaxFileSize
+	}
+	if c.MaxFiles <= 0 {
+		c.MaxFiles = defaultMaxFiles
+	}
+	return c
+}
+
+// maxRequest - предел для multipart-тела: все файлы плюс служебные поля.
+func (c UploadConfig) maxRequest() int64 {
+	return c.MaxFileSize*int64(c.MaxFiles) + maxFormBody
+}
+
+// uploadError - ошибка во вложениях, которую можно показать пользователю.
+type uploadError string
+
+func (e uploadError) Error() string { return string(e) }
+
+// LimitBody ограничивает размер тела запроса до того, как его прочитает CSRF или обработчик.
+func (h *Handler) LimitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := int64(maxFormBody)
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+			limit = h.uploads.maxRequest()
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// saveUploads проверяет и сохраняет файлы из поля attachments. При ошибке уже сохраненные
+// объекты удаляются.
+func (h *Handler) saveUploads(files []*multipart.FileHeader) ([]storage.Attachment, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	if h.uploads.Blobs == nil {
+		return nil, uploadError("File attachments are disabled.")
+	}
+	if len(files) > h.uploads.MaxFiles {
+		return nil, uploadError(fmt.Sprintf("You can attach at most %d files.", h.uploads.MaxFiles))
+	}
+
+	var saved []storage.Attachment
+	for _, fh := range files {
+		a, err := h.saveUpload(fh)
+		if err != nil {
+			h.deleteBlobs(saved)
+			return nil, err
+		}
+		saved = append(saved, a)
+	}
+	return saved, nil
+}
+
+func (h *Handler) saveUpload(fh *multipart.FileHeader) (storage.Attachment, error) {
+	name := sanitizeFilename(fh.Filename)
+	if fh.Size > h.uploads.MaxFileSize {
+		return storage.Attachment{}, uploadError(fmt.Sprintf("%s is larger than %d MB.", name, h.uploads.MaxFileSize>>20))
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return storage.Attachment{}, err
+	}
+	defer f.Close()
+
+	// DetectContentType смотрит не больше 512 первых байт
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return storage.Attachment{}, err
+	}
+	head = head[:n]
+	if n == 0 {
+		return storage.Attachment{}, uploadError(name + " is empty.")
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !allowedAttachmentTypes[contentType] {
+		return storage.Attachment{}, uploadError(name + " is not an allowed file type. Attach images, PDF or plain text.")
+	}
+
+	key, err := randomBlobKey()
+	if err != nil {
+		return storage.Attachment{}, err
+	}
+	// LimitReader защищает от заголовка Size, не совпадающего с реальным телом
+	body := io.LimitReader(io.MultiReader(bytes.NewReader(head), f), h.uploads.MaxFileSize+1)
+	size, err := h.uploads.Blobs.Put(key, body)
+	if err != nil {
+		return storage.Attachment{}, err
+	}
+	if size > h.uploads.MaxFileSize {
+		h.deleteBlobs([]storage.Attachment{{Key: key}})
+		return storage.Attachment{}, uploadError(fmt.Sprintf("%s is larger than %d MB.", name, h.uploads.MaxFileSize>>20))
+	}
+	return storage.Attachment{Key: key, Name: name, ContentType: contentType, Size: size}, nil
+}
+
+func (h *Handler) deleteBlobs(attachments []storage.Attachment) {
+	for _, a := range attachments {
+		if err := h.uploads.Blobs.Delete(a.Key); err != nil {
+			slog.Error("failed to delete blob", "key", a.Key, "err", err)
+		}
+	}
+}
+
+// HandleAttachment отдает вложение. Доступ - как к переписке: автор жалобы и сотрудники.
+func (h *Handler) HandleAttachment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := h.complaintForThread(w, r)
+		if !ok {
+			return
+		}
+		key := mux.Vars(r)["key"]
+		var att *storage.Attachment
+		for i := range c.Attachments {
+			if c.Attachments[i].Key == key {
+				att = &c.Attachments[i]
+				break
+			}
+		}
+		if att == nil || h.uploads.Blobs == nil {
+			h.renderError(w, r, http.StatusNotFound, "File not found", "This attachment does not exist.")
+			return
+		}
+
+		rc, err := h.uploads.Blobs.Open(att.Key)
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			h.renderError(w, r, http.StatusNotFound, "File not found", "This attachment does not exist.")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to open blob", "key", att.Key, "err", err)
+			http.Error(w, "failed to load attachment", http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+
+		w.Header().Set("Content-Type", att.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(att.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": att.Name}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, no-store")
+		if _, err := io.Copy(w, rc); err != nil {
+			slog.WarnContext(r.Context(), "failed to send attachment", "key", att.Key, "err", err)
+		}
+	}
+}
+
+// HandlePurge безвозвратно удаляет жалобу и ее вложения.
+func (h *Handler) HandlePurge() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		id, err := formID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c, err := h.store.Get(id)
+		if err == nil {
+			err = h.store.Delete(id)
+		}
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "complaint not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to purge complaint", "err", err)
+			http.Error(w, "failed to purge", http.StatusInternalServerError)
+			return
+		}
+
+		h.audit(r, storage.AuditEntry{
+			Action:      storage.AuditComplaintPurge,
+			ComplaintID: id,
+			Before:      c.Subject,
+		})
+
+		if h.uploads.Blobs != nil {
+			h.deleteBlobs(c.Attachments)
+			h.CollectGarbage()
+		}
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}
+}
+
+// CollectGarbage удаляет объекты, оставшиеся без жалобы (например, после сбоя при сохранении).
+func (h *Handler) CollectGarbage() {
+	if h.uploads.Blobs == nil {
+		return
+	}
+	n, err := storage.CollectGarbage(h.store, h.uploads.Blobs, time.Now().Add(-blobGracePeriod))
+	if err != nil {
+		slog.Error("blob gc failed", "err", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("blob gc: removed orphaned blobs", "count", n)
+	}
+}
+
+// sanitizeFilename оставляет от имени файла только базовое имя без управляющих символов.
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	if r := []rune(name); len(r) > 100 {
+		name = string(r[:100])
+	}
+	return name
+}
+
+func randomBlobKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"donos-hrm/internal/logging"
+	"donos-hrm/internal/storage"
+)
+
+// Сколько записей журнала показывать на странице
+const auditPageSize = 200
+
+// audit дописывает запись в журнал, подставляя автора и IP из запроса. Сбой записи
+// не отменяет уже выполненное действие, но попадает в лог.
+func (h *Handler) audit(r *http.Request, e storage.AuditEntry) {
+	if e.Actor == "" {
+		e.Actor = principalFrom(r).Email
+	}
+	e.IP = h.rateLimiter.GetIP(r)
+	if _, err := h.auditLog.Append(e); err != nil {
+		slog.ErrorContext(r.Context(), "audit: failed to record entry", "action", e.Action, logging.Email("actor", e.Actor), "err", err)
+	}
+}
+
+// HandleAudit - страница журнала аудита с фильтрами и проверкой цепочки хешей.
+func (h *Handler) HandleAudit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := storage.AuditFilter{
+			Actor:  strings.TrimSpace(q.Get("actor")),
+			Action: q.Get("action"),
+			Limit:  auditPageSize,
+		}
+		var errMsg string
+		if v := q.Get("complaint"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				errMsg = "Complaint ID must be a number."
+			}
+			filter.ComplaintID = id
+		}
+		if v := q.Get("from"); v != "" {
+			t, err := parseDay(v)
+			if err != nil {
+				errMsg = "Dates must be in YYYY-MM-DD format."
+			}
+			filter.Since = t
+		}
+		if v := q.Get("to"); v != "" {
+			t, err := parseDay(v)
+			if err != nil {
+				errMsg = "Dates must be in YYYY-MM-DD format."
+			}
+			// Включительно: до конца указанного дня
+			filter.Until = t.AddDate(0, 0, 1)
+		}
+
+		entries, err := h.auditLog.Entries(filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to list audit log", "err", err)
+			http.Error(w, "failed to load audit log", http.StatusInternalServerError)
+			return
+		}
+
+		brokenAt, err := h.auditVerifier.Verify()
+		if err != nil && !errors.Is(err, storage.ErrAuditChainBroken) {
+			slog.ErrorContext(r.Context(), "failed to verify audit log", "err", err)
+			http.Error(w, "failed to verify audit log", http.StatusInternalServerError)
+			return
+		}
+		if brokenAt != 0 {
+			slog.WarnContext(r.Context(), "audit: hash chain broken", "seq", brokenAt)
+		}
+
+		data := map[string]any{
+			"Entries":  entries,
+			"Actions":  storage.AuditActions,
+			"Filter":   q,
+			"BrokenAt": brokenAt,
+			"PageSize": auditPageSize,
+		}
+		if errMsg != "" {
+			data["Error"] = errMsg
+		}
+		h.renderTemplate(w, "layout", h.viewData(r, "Audit Log", "audit", data))
+	}
+}
+
+// HandleExport выгружает все жалобы в CSV. Анонимные авторы остаются под псевдонимом.
+func (h *Handler) HandleExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		complaints, err := h.store.ListAll()
+		if err != nil {
+			http.Error(w, "failed to list complaints", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="complaints-`+time.Now().Format("20060102")+`.csv"`)
+		w.Header().Set("Cache-Control", "no-store")
+
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "created_at", "reporter", "anonymous", "subject", "description", "status", "hidden", "attachments"})
+		for _, c := range complaints {
+			cw.Write([]string{
+				strconv.Itoa(c.ID),
+				c.CreatedAt.Format(time.RFC3339),
+				csvSafe(c.Reporter),
+				strconv.FormatBool(c.Anonymous),
+				csvSafe(c.Subject),
+				csvSafe(c.Description),
+				string(c.Status),
+				strconv.FormatBool(c.Hidden),
+				strconv.Itoa(len(c.Attachments)),
+			})
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			slog.ErrorContext(r.Context(), "failed to write export", "err", err)
+		}
+
+		h.audit(r, storage.AuditEntry{
+			Action: storage.AuditComplaintExport,
+			After:  strconv.Itoa(len(complaints)) + " complaints",
+		})
+	}
+}
+
+// csvSafe не дает таблицам выполнить текст жалобы как формулу.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
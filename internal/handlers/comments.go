@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/storage"
+	"donos-hrm/internal/webhook"
+)
+
+// Максимальная длина комментария в символах
+const maxCommentLength = 5000
+
+// HandleComplaint - страница жалобы с перепиской. Доступна автору и сотрудникам.
+func (h *Handler) HandleComplaint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := h.complaintForThread(w, r)
+		if !ok {
+			return
+		}
+		h.renderComplaint(w, r, c, "")
+	}
+}
+
+// HandleAddComment добавляет ответ в переписку. Автор жалобы пишет от своего имени
+// (или псевдонима), сотрудник с правом PermComment может оставить внутреннюю заметку.
+// Ревьюеры переписку только читают.
+func (h *Handler) HandleAddComment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := h.complaintForThread(w, r)
+		if !ok {
+			return
+		}
+		if !h.canReply(c, principalFrom(r)) {
+			h.renderError(w, r, http.StatusForbidden, "Access denied", "You can read this conversation but not post to it.")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		p := principalFrom(r)
+		body := strings.TrimSpace(r.FormValue("body"))
+		if len([]rune(body)) > maxCommentLength {
+			h.renderComplaint(w, r, c, "Message is too long.")
+			return
+		}
+
+		comment := storage.Comment{ComplaintID: c.ID, Body: body}
+		// Автор жалобы всегда пишет как автор, даже если он сотрудник: иначе в анонимной
+		// жалобе его email оказался бы в переписке.
+		if h.owns(c, p.Email) {
+			comment.Author = c.Reporter
+			comment.FromReporter = true
+		} else {
+			comment.Author = p.Email
+			comment.Internal = r.FormValue("internal") == "on"
+		}
+
+		comment, err := h.store.AddComment(comment)
+		if err != nil {
+			if errors.Is(err, storage.ErrEmptyComment) {
+				h.renderComplaint(w, r, c, "Message cannot be empty.")
+				return
+			}
+			slog.ErrorContext(r.Context(), "failed to add comment", "err", err)
+			http.Error(w, "failed to add comment", http.StatusInternalServerError)
+			return
+		}
+		h.notifier.ReplyAdded(c, comment)
+		h.webhooks.Emit(webhook.EventCommentAdded, webhook.Comment(comment))
+		http.Redirect(w, r, "/complaints/"+strconv.Itoa(c.ID)+"#comments", http.StatusSeeOther)
+	}
+}
+
+// complaintForThread загружает жалобу из пути и проверяет, что пользователь - ее автор
+// или сотрудник. Остальным отвечает 404, чтобы не раскрывать существование жалобы.
+func (h *Handler) complaintForThread(w http.ResponseWriter, r *http.Request) (storage.Complaint, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return storage.Complaint{}, false
+	}
+	c, err := h.store.Get(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		h.renderError(w, r, http.StatusNotFound, "Complaint not found", "This complaint does not exist or you do not have access to it.")
+		return storage.Complaint{}, false
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load complaint", "err", err)
+		http.Error(w, "failed to load complaint", http.StatusInternalServerError)
+		return storage.Complaint{}, false
+	}
+
+	p := principalFrom(r)
+	if !h.owns(c, p.Email) && !p.Role.AtLeast(auth.RoleReviewer) {
+		h.renderError(w, r, http.StatusNotFound, "Complaint not found", "This complaint does not exist or you do not have access to it.")
+		return storage.Complaint{}, false
+	}
+	return c, true
+}
+
+// canReply сообщает, может ли пользователь писать в переписку по жалобе c.
+func (h *Handler) canReply(c storage.Complaint, p principal) bool {
+	return h.owns(c, p.Email) || p.Role.Permissions().Has(auth.PermComment)
+}
+
+func (h *Handler) renderComplaint(w http.ResponseWriter, r *http.Request, c storage.Complaint, errMsg string) {
+	p := principalFrom(r)
+	staff := p.Role.AtLeast(auth.RoleReviewer)
+
+	comments, err := h.store.Comments(c.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load comments", "err", err)
+		http.Error(w, "failed to load comments", http.StatusInternalServerError)
+		return
+	}
+	// Внутренние заметки автору жалобы не показываем
+	visible := comments[:0]
+	for _, cm := range comments {
+		if cm.Internal && !staff {
+			continue
+		}
+		visible = append(visible, cm)
+	}
+
+	data := map[string]any{
+		"Complaint": c,
+		"Comments":  visible,
+		"IsOwner":   h.owns(c, p.Email),
+		"IsStaff":   staff,
+		"CanReply":  h.canReply(c, p),
+	}
+	if errMsg != "" {
+		data["Error"] = errMsg
+	}
+	h.renderTemplate(w, "layout", h.viewData(r, c.Subject, "complaint", data))
+}
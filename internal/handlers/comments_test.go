@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/storage"
+)
+
+func TestCommentPermissions(t *testing.T) {
+	e := newTestEnv(t)
+	e.roles.Grant("reviewer@example.com", auth.RoleReviewer, "test")
+	e.roles.Grant("mod@example.com", auth.RoleModerator, "test")
+	e.roles.Grant("admin@example.com", auth.RoleAdmin, "test")
+	c, err := e.store.Add(storage.Complaint{Reporter: "user@example.com", Subject: "s", Description: "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(c.ID)
+
+	request := func(method, email string, form url.Values) *httptest.ResponseRecorder {
+		cookie, _ := e.signIn(t, email)
+		r := httptest.NewRequest(method, "/complaints/"+id+"/comments", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookie)
+		r = mux.SetURLVars(r, map[string]string{"id": id})
+		rec := httptest.NewRecorder()
+		if method == http.MethodGet {
+			e.RequireAuth(e.HandleComplaint())(rec, r)
+		} else {
+			e.RequireAuth(e.HandleAddComment())(rec, r)
+		}
+		return rec
+	}
+
+	tests := []struct {
+		email    string
+		canReply bool
+	}{
+		{"user@example.com", true},
+		{"reviewer@example.com", false},
+		{"mod@example.com", true},
+		{"admin@example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			before, err := e.store.Comments(c.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := request(http.MethodPost, tt.email, url.Values{"body": {"hello"}, "internal": {"on"}})
+			after, err := e.store.Comments(c.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.canReply {
+				if rec.Code != http.StatusSeeOther || len(after) != len(before)+1 {
+					t.Fatalf("status = %d, comments %d -> %d; want the reply posted", rec.Code, len(before), len(after))
+				}
+			} else if rec.Code != http.StatusForbidden || len(after) != len(before) {
+				t.Fatalf("status = %d, comments %d -> %d; want 403 and no reply", rec.Code, len(before), len(after))
+			}
+
+			page := request(http.MethodGet, tt.email, nil)
+			if page.Code != http.StatusOK {
+				t.Fatalf("complaint page status = %d", page.Code)
+			}
+			hasForm := strings.Contains(page.Body.String(), `action="/complaints/`+id+`/comments"`)
+			if hasForm != tt.canReply {
+				t.Errorf("reply form shown = %v, want %v", hasForm, tt.canReply)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"donos-hrm/internal/logging"
+	"donos-hrm/internal/storage"
+)
+
+const (
+	csrfFormField = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+	// Файлы больше этого размера multipart-парсер складывает во временные файлы
+	multipartMemory = 8 << 20
+)
+
+// Путь выхода. Без сессии выходить не из чего, и проверять токен не с чем.
+const LogoutPath = "/logout"
+
+// CSRF проверяет synchronizer token на всех изменяющих запросах. Токен хранится в сессии
+// auth.Manager и передается полем формы csrf_token или заголовком X-CSRF-Token.
+func (h *Handler) CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Bearer-токен браузер сам не подставляет, поэтому такие запросы не подвержены CSRF.
+		// authenticate при наличии заголовка cookie не смотрит. Отчеты CSP браузер шлет сам,
+		// без токена, а обработчик ничего не меняет.
+		if hasBearer(r) || r.URL.Path == CSPReportPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sess, ok := h.authManager.CurrentSession(r)
+		if !ok && r.URL.Path == LogoutPath {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !ok {
+			h.rejectCSRF(w, r, "", "no session")
+			return
+		}
+
+		token := r.Header.Get(csrfHeader)
+		if token == "" {
+			if err := parseForm(r); err != nil {
+				var tooBig *http.MaxBytesError
+				if errors.As(err, &tooBig) {
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+			}
+			token = r.FormValue(csrfFormField)
+		}
+		if token == "" {
+			h.rejectCSRF(w, r, sess.Email, "missing token")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(sess.CSRFToken)) != 1 {
+			h.rejectCSRF(w, r, sess.Email, "invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseForm разбирает и urlencoded, и multipart тело. Повторный вызов ничего не читает.
+func parseForm(r *http.Request) error {
+	err := r.ParseMultipartForm(multipartMemory)
+	if errors.Is(err, http.ErrNotMultipart) {
+		return r.ParseForm()
+	}
+	return err
+}
+
+func (h *Handler) rejectCSRF(w http.ResponseWriter, r *http.Request, email, reason string) {
+	slog.WarnContext(r.Context(), "csrf: request rejected", "method", r.Method, "path", r.URL.Path,
+		"ip", h.rateLimiter.GetIP(r), logging.Email("user", email), "reason", reason)
+	h.audit(r, storage.AuditEntry{
+		Actor:  email,
+		Action: storage.AuditCSRFRejected,
+		Target: r.Method + " " + r.URL.Path,
+		After:  reason,
+	})
+	if isAPIRequest(r) {
+		writeProblem(w, http.StatusForbidden, "missing or invalid CSRF token")
+		return
+	}
+	h.renderError(w, r, http.StatusForbidden, "Request rejected", "Your form has expired. Go back, reload the page and try again.")
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"donos-hrm/internal/storage"
+)
+
+// passed - обработчик за CSRF, который только отмечает, что до него дошли.
+func passed(called *bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*called = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func formRequest(path, token string, cookie *http.Cookie) *http.Request {
+	form := url.Values{"subject": {"s"}}
+	if token != "" {
+		form.Set(csrfFormField, token)
+	}
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestCSRF(t *testing.T) {
+	e := newTestEnv(t)
+	cookie, token := e.signIn(t, "user@example.com")
+
+	multipartRequest := func(token string) *http.Request {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField(csrfFormField, token)
+		fw, _ := mw.CreateFormFile("attachments", "note.txt")
+		fw.Write([]byte("hello"))
+		mw.Close()
+		r := httptest.NewRequest(http.MethodPost, "/form", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		r.AddCookie(cookie)
+		return r
+	}
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		passed bool
+		reason string // причина отказа в журнале аудита
+	}{
+		{"safe method", func() *http.Request { return httptest.NewRequest(http.MethodGet, "/form", nil) }, true, ""},
+		{"form token", func() *http.Request { return formRequest("/form", token, cookie) }, true, ""},
+		{"header token", func() *http.Request {
+			r := formRequest("/form", "", cookie)
+			r.Header.Set(csrfHeader, token)
+			return r
+		}, true, ""},
+		{"multipart token", func() *http.Request { return multipartRequest(token) }, true, ""},
+		{"multipart mismatch", func() *http.Request { return multipartRequest("wrong") }, false, "invalid token"},
+		{"token mismatch", func() *http.Request { return formRequest("/form", "wrong", cookie) }, false, "invalid token"},
+		{"missing token", func() *http.Request { return formRequest("/form", "", cookie) }, false, "missing token"},
+		{"no session", func() *http.Request { return formRequest("/form", token, nil) }, false, "no session"},
+		{"bearer token", func() *http.Request {
+			r := formRequest("/api/v1/complaints", "", nil)
+			r.Header.Set("Authorization", "Bearer some-token")
+			return r
+		}, true, ""},
+		{"csp report", func() *http.Request { return formRequest(CSPReportPath, "", nil) }, true, ""},
+		{"logout without session", func() *http.Request { return formRequest(LogoutPath, "", nil) }, true, ""},
+		{"logout with session", func() *http.Request { return formRequest(LogoutPath, "", cookie) }, false, "missing token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, err := e.audit.Entries(storage.AuditFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			called := false
+			rec := httptest.NewRecorder()
+			e.CSRF(passed(&called)).ServeHTTP(rec, tt.req())
+
+			if called != tt.passed {
+				t.Fatalf("handler called = %v, want %v (status %d)", called, tt.passed, rec.Code)
+			}
+			after, err := e.audit.Entries(storage.AuditFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.passed {
+				if len(after) != len(before) {
+					t.Errorf("accepted request was audited as %s", after[0].After)
+				}
+				return
+			}
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+			}
+			if len(after) != len(before)+1 || after[0].Action != storage.AuditCSRFRejected || after[0].After != tt.reason {
+				t.Errorf("want %s audit entry with reason %q", storage.AuditCSRFRejected, tt.reason)
+			}
+		})
+	}
+}
+
+func TestLogoutWithoutSession(t *testing.T) {
+	e := newTestEnv(t)
+	rec := httptest.NewRecorder()
+	// Сессия уже истекла или удалена в другой вкладке
+	r := formRequest(LogoutPath, "", &http.Cookie{Name: "session_token", Value: "expired"})
+	e.CSRF(e.HandleLogout()).ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/" {
+		t.Fatalf("got %d to %q, want redirect to /", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"donos-hrm/internal/notify"
+)
+
+// Почтовый сервер, который принимает соединение и молчит, не должен задерживать
+// отправку жалобы: письмо только ставится в очередь.
+func TestHandleFormDoesNotWaitForEmail(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu    sync.Mutex
+		conns []net.Conn
+	)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	sender, err := notify.NewSMTPSender(notify.SMTPConfig{Addr: ln.Addr().String(), From: "hr-bot@example.com", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := template.ParseGlob(filepath.Join("..", "..", "templates", "email", "*.gotxt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := notify.NewMemoryQueue()
+	notifier := notify.New(notify.Config{Queue: queue, Sender: sender, Templates: tmpl, HRMailbox: []string{"hr@example.com"}, PollInt: time.Hour})
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		notifier.Stop()
+	})
+
+	e := newTestEnv(t)
+	e.notifier = notifier
+	cookie, _ := e.signIn(t, "user@example.com")
+
+	for i := range 3 {
+		form := url.Values{"subject": {"s"}, "description": {"d"}}
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		e.RequireAuth(e.HandleForm())(rec, r)
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("submission %d: status = %d", i, rec.Code)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("submission %d took %s", i, d)
+		}
+	}
+
+	jobs, err := queue.Due(time.Now().Add(time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 3 {
+		t.Fatalf("queue has %d emails, want 3", len(jobs))
+	}
+}
@@ -3,16 +3,21 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/base64"
-	"fmt"
+	"errors"
 	"html/template"
-	"log"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 
 	"donos-hrm/internal/auth"
+	"donos-hrm/internal/logging"
+	"donos-hrm/internal/notify"
 	"donos-hrm/internal/ratelimit"
 	"donos-hrm/internal/storage"
+	"donos-hrm/internal/webhook"
 )
 
 type Handler struct {
@@ -20,65 +25,141 @@ type Handler struct {
 	store       storage.Store
 	authManager *auth.Manager
 	rateLimiter *ratelimit.Limiter
-	adminEmail  string
-	stateMu     sync.Mutex
-	states      map[string]struct{}
+	roles       auth.RoleStore
+	uploads     UploadConfig
+	auditLog    storage.AuditStore
+	// auditVerifier помнит проверенное начало журнала, чтобы страница аудита не
+	// пересчитывала хеши всех записей при каждом открытии.
+	auditVerifier *storage.AuditVerifier
+	notifier      *notify.Notifier
+	webhooks      *webhook.Dispatcher
+	quotas        QuotaConfig
+	stateMu       sync.Mutex
+	states        map[string]struct{}
 }
 
-func New(tmpl *template.Template, store storage.Store, authManager *auth.Manager, rateLimiter *ratelimit.Limiter, adminEmail string) *Handler {
+func New(tmpl *template.Template, store storage.Store, authManager *auth.Manager, rateLimiter *ratelimit.Limiter, roles auth.RoleStore, uploads UploadConfig, auditLog storage.AuditStore, notifier *notify.Notifier, webhooks *webhook.Dispatcher, quotas QuotaConfig) *Handler {
 	return &Handler{
-		tmpl:        tmpl,
-		store:       store,
-		authManager: authManager,
-		rateLimiter: rateLimiter,
-		adminEmail:  adminEmail,
-		states:      make(map[string]struct{}),
+		tmpl:          tmpl,
+		store:         store,
+		authManager:   authManager,
+		rateLimiter:   rateLimiter,
+		roles:         roles,
+		uploads:       uploads.withDefaults(),
+		auditLog:      auditLog,
+		auditVerifier: storage.NewAuditVerifier(auditLog),
+		notifier:      notifier,
+		webhooks:      webhooks,
+		quotas:        quotas.withDefaults(),
+		states:        make(map[string]struct{}),
 	}
 }
 
-func (h *Handler) IsAdmin(email string) bool {
-	return h.adminEmail != "" && strings.EqualFold(email, h.adminEmail)
+// Role возвращает роль пользователя; при ошибке хранилища - RoleReporter.
+func (h *Handler) Role(email string) auth.Role {
+	if email == "" {
+		return auth.RoleReporter
+	}
+	role, err := h.roles.Role(email)
+	if err != nil {
+		slog.Error("failed to load role", "err", err)
+		return auth.RoleReporter
+	}
+	return role
 }
 
-func (h *Handler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+func (h *Handler) Permissions(email string) auth.PermissionSet {
+	return h.Role(email).Permissions()
+}
+
+// RequireRole пропускает только пользователей с ролью не ниже required.
+// Вместо сессии принимается Authorization: Bearer с подходящим scope.
+func (h *Handler) RequireRole(required auth.Role, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		email, ok := h.authManager.GetSession(r)
-		if !ok {
+		p, status, detail := h.authorize(r, required)
+		switch {
+		case status == http.StatusOK:
+			next(w, withPrincipal(r, p))
+		case status == http.StatusUnauthorized && hasBearer(r):
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid or expired token", http.StatusUnauthorized)
+		case status == http.StatusUnauthorized:
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
-			return
-		}
-		if !h.IsAdmin(email) {
-			http.Error(w, "Access denied. Admin privileges required.", http.StatusForbidden)
-			return
+		case p.Token != nil:
+			http.Error(w, detail, http.StatusForbidden)
+		default:
+			h.renderError(w, r, http.StatusForbidden, "Access denied", "You do not have permission to view this page.")
 		}
-		next(w, r)
 	}
 }
 
+func (h *Handler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return h.RequireRole(auth.RoleAdmin, next)
+}
+
 func (h *Handler) HandleForm() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		email, _ := h.authManager.GetSession(r)
+		email := principalFrom(r).Email
 
 		switch r.Method {
 		case http.MethodGet:
-			h.renderTemplate(w, "layout", h.viewData(email, "Submit Complaint", "form"))
+			h.renderForm(w, r, http.StatusOK, "")
 		case http.MethodPost:
-			if err := r.ParseForm(); err != nil {
+			if err := parseForm(r); err != nil {
+				var tooBig *http.MaxBytesError
+				if errors.As(err, &tooBig) {
+					h.renderForm(w, r, http.StatusRequestEntityTooLarge, "The attached files are too large.")
+					return
+				}
 				http.Error(w, "invalid form", http.StatusBadRequest)
 				return
 			}
-			subject := r.FormValue("subject")
-			description := r.FormValue("description")
+			subject := strings.TrimSpace(r.FormValue("subject"))
+			description := strings.TrimSpace(r.FormValue("description"))
+			anonymous := r.FormValue("anonymous") == "on"
+			if subject == "" || description == "" {
+				h.renderForm(w, r, http.StatusBadRequest, storage.ErrMissingFields.Error())
+				return
+			}
+			// Квота занимается до сохранения вложений, чтобы отклоненная отправка не писала
+			// файлы в хранилище, и возвращается, если жалоба не сохранилась.
+			if res := h.reserveQuota(w, r, email); !res.Allowed {
+				h.renderForm(w, r, http.StatusTooManyRequests, quotaMessage(res))
+				return
+			}
+
+			var files []*multipart.FileHeader
+			if r.MultipartForm != nil {
+				files = r.MultipartForm.File["attachments"]
+			}
+			attachments, err := h.saveUploads(files)
+			if err != nil {
+				var uerr uploadError
+				if !errors.As(err, &uerr) {
+					slog.ErrorContext(r.Context(), "failed to save attachments", "err", err)
+					err = uploadError("Could not save the attached files. Please try again.")
+				}
+				h.refundQuota(w, r, email)
+				h.renderForm(w, r, http.StatusBadRequest, err.Error())
+				return
+			}
 
-			_, err := h.store.Add(storage.Complaint{
-				Reporter:    email,
+			c, err := h.store.Add(storage.Complaint{
+				Reporter:    h.reporterID(email, anonymous),
+				Anonymous:   anonymous,
 				Subject:     subject,
 				Description: description,
+				Attachments: attachments,
 			})
 			if err != nil {
-				h.renderTemplate(w, "layout", h.viewData(email, "Submit Complaint", "form", map[string]any{"Error": err.Error()}))
+				h.deleteBlobs(attachments)
+				h.refundQuota(w, r, email)
+				h.renderForm(w, r, http.StatusOK, err.Error())
 				return
 			}
+			complaintsCreated.Inc()
+			h.notifier.ComplaintCreated(c)
+			h.webhooks.Emit(webhook.EventComplaintCreated, webhook.Complaint(c))
 
 			http.Redirect(w, r, "/complaints", http.StatusSeeOther)
 		default:
@@ -87,20 +168,67 @@ func (h *Handler) HandleForm() http.HandlerFunc {
 	}
 }
 
+func (h *Handler) renderForm(w http.ResponseWriter, r *http.Request, status int, errMsg string) {
+	email := principalFrom(r).Email
+	data := map[string]any{
+		"UploadsEnabled": h.uploads.Blobs != nil,
+		"MaxFiles":       h.uploads.MaxFiles,
+		"MaxFileSizeMB":  h.uploads.MaxFileSize >> 20,
+		"Quota":          h.rateLimiter.PeekQuota(r.Context(), h.quotaKey(email), h.quotaFor(email)),
+	}
+	if errMsg != "" {
+		data["Error"] = errMsg
+	}
+	if status != http.StatusOK {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+	}
+	h.renderTemplate(w, "layout", h.viewData(r, "Submit Complaint", "form", data))
+}
+
 func (h *Handler) HandleList() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		email, _ := h.authManager.GetSession(r)
-		complaints, err := h.store.List()
+		email := principalFrom(r).Email
+		pseudonym := h.authManager.Pseudonym(email)
+
+		// Общий список показывает только видимые жалобы и не дает фильтровать по статусу:
+		// статус чужих жалоб виден только сотрудникам
+		q, _ := listQuery(r, false)
+		q.Hidden = storage.Bool(false)
+		if r.URL.Query().Get("mine") == "1" {
+			q.Reporters = []string{email, pseudonym}
+		}
+		page, err := h.store.Query(q)
 		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to query complaints", "err", err)
 			http.Error(w, "failed to list complaints", http.StatusInternalServerError)
 			return
 		}
-		h.renderTemplate(w, "layout", h.viewData(email, "Complaints", "list", map[string]any{"Complaints": complaints}))
+
+		// Свои жалобы определяет owns, чтобы шаблон не сравнивал адреса с учетом регистра
+		mine := make(map[int]bool)
+		for _, c := range page.Complaints {
+			if h.owns(c, email) {
+				mine[c.ID] = true
+			}
+		}
+
+		h.renderTemplate(w, "layout", h.viewData(r, "Complaints", "list", map[string]any{
+			"Complaints": page.Complaints,
+			"Mine":       mine,
+			"Query":      r.URL.Query(),
+			"Sorts":      storage.Sorts,
+			"MineChips":  chips(r, "mine", []string{"1"}, []string{"Mine"}),
+			"Pager":      newPager(r, q, page.Total),
+		}))
 	}
 }
 
 func (h *Handler) HandleLogin() http.HandlerFunc {
-	return h.rateLimiter.Middleware(func(w http.ResponseWriter, r *http.Request) {
+	return h.rateLimiter.Middleware(ratelimit.PolicyLogin, func(w http.ResponseWriter, r *http.Request) {
+		if h.lockedOut(w, r, h.loginKeys(r, "")) {
+			return
+		}
 		state := randomState()
 		h.stateMu.Lock()
 		h.states[state] = struct{}{}
@@ -111,54 +239,95 @@ func (h *Handler) HandleLogin() http.HandlerFunc {
 }
 
 func (h *Handler) HandleCallback() http.HandlerFunc {
-	return h.rateLimiter.Middleware(func(w http.ResponseWriter, r *http.Request) {
+	return h.rateLimiter.Middleware(ratelimit.PolicyCallback, func(w http.ResponseWriter, r *http.Request) {
+		if h.lockedOut(w, r, h.loginKeys(r, "")) {
+			return
+		}
+
 		state := r.URL.Query().Get("state")
 		if !h.consumeState(state) {
-			http.Error(w, "invalid state", http.StatusBadRequest)
+			loginFailures.Inc(loginInvalidState)
+			h.loginFailed(r, "", failWeightFlow)
+			h.renderError(w, r, http.StatusBadRequest, "Login failed", "The sign-in link has expired or was already used. Please try again.")
 			return
 		}
 
 		code := r.URL.Query().Get("code")
 		if code == "" {
-			http.Error(w, "missing code", http.StatusBadRequest)
+			loginFailures.Inc(loginMissingCode)
+			h.loginFailed(r, "", failWeightFlow)
+			h.renderError(w, r, http.StatusBadRequest, "Login failed", "Google did not return an authorization code. Please try again.")
 			return
 		}
 
 		ctx := r.Context()
 		token, err := h.authManager.Exchange(ctx, code)
 		if err != nil {
-			log.Printf("token exchange failed: %v", err)
-			http.Error(w, "login failed", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "token exchange failed", "err", err)
+			loginFailures.Inc(loginExchange)
+			h.loginFailed(r, "", failWeightFlow)
+			h.renderError(w, r, http.StatusInternalServerError, "Login failed", "We could not complete the sign-in with Google. Please try again.")
 			return
 		}
 
 		client := h.authManager.Client(ctx, token)
-		email, err := h.authManager.GetEmail(ctx, client)
+		info, err := h.authManager.GetUserInfo(ctx, client)
 		if err != nil {
-			log.Printf("email fetch failed: %v", err)
-			http.Error(w, "login failed", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "user info fetch failed", "err", err)
+			loginFailures.Inc(loginUserInfo)
+			h.loginFailed(r, "", failWeightFlow)
+			h.renderError(w, r, http.StatusInternalServerError, "Login failed", "We could not complete the sign-in with Google. Please try again.")
 			return
 		}
-
-		// Проверка на наличие "pynest" в email
-		if !strings.Contains(strings.ToLower(email), "pynest") {
-			log.Printf("access denied: email %s does not contain 'pynest'", email)
-			http.Error(w, "Access denied. Only emails containing 'pynest' are allowed.", http.StatusForbidden)
+		// Email приводится к одному виду один раз: ключи лимитов, блокировок и сессия
+		// должны совпадать, как бы Google ни записал адрес.
+		email := strings.ToLower(strings.TrimSpace(info.Email))
+
+		if h.rateLimiter.EmailAccess(email) == ratelimit.AccessBan {
+			slog.WarnContext(ctx, "login denied by ban list", logging.Email("email", email))
+			loginFailures.Inc(loginBanned)
+			h.audit(r, storage.AuditEntry{Actor: email, Action: storage.AuditLoginDenied, After: "banned"})
+			h.rateLimiter.Forbid(w, r, "email")
+			return
+		}
+		if h.lockedOut(w, r, h.loginKeys(r, email)) {
 			return
 		}
 
-		// Rate limiting по email
-		if !h.rateLimiter.CheckEmail(email) {
-			log.Printf("rate limit exceeded for email: %s", email)
-			http.Error(w, "Too many requests from this email. Please try again later.", http.StatusTooManyRequests)
+		if err := h.authManager.Authorize(info); err != nil {
+			slog.WarnContext(ctx, "login denied", logging.Email("email", email), "reason", err)
+			loginFailures.Inc(loginDenied)
+			h.loginFailed(r, email, failWeightDenied)
+			h.audit(r, storage.AuditEntry{Actor: email, Action: storage.AuditLoginDenied, After: err.Error()})
+			h.renderError(w, r, http.StatusForbidden, "Access denied", "Your account is not allowed to use this service. Sign in with your work account or contact HR.")
 			return
 		}
 
-		if _, err := h.authManager.CreateSession(w, email); err != nil {
-			log.Printf("session creation failed: %v", err)
-			http.Error(w, "login failed", http.StatusInternalServerError)
+		// Rate limiting по email; разрешенный администратором адрес не ограничивается
+		if h.rateLimiter.EmailAccess(email) != ratelimit.AccessAllow {
+			if res := h.rateLimiter.Allow(ctx, ratelimit.PolicyCallback, "email:"+email); !res.Allowed {
+				slog.WarnContext(ctx, "login rate limit exceeded", logging.Email("email", email))
+				loginFailures.Inc(loginRateLimited)
+				h.audit(r, storage.AuditEntry{Actor: email, Action: storage.AuditLoginDenied, After: "rate limited"})
+				ratelimit.SetHeaders(w.Header(), res)
+				h.TooManyRequests(w, r, res)
+				return
+			}
+		}
+
+		if _, err := h.authManager.CreateSession(w, r, email); err != nil {
+			slog.ErrorContext(r.Context(), "session creation failed", "err", err)
+			loginFailures.Inc(loginSessionFailed)
+			h.renderError(w, r, http.StatusInternalServerError, "Login failed", "We could not start your session. Please try again.")
 			return
 		}
+		// Успешный вход прощает прошлые неудачи аккаунта, но не адреса: с одного IP
+		// могут входить и пользователь, и тот, кто подбирает чужие аккаунты.
+		if err := h.rateLimiter.ClearLockout(ctx, "email:"+email); err != nil {
+			slog.WarnContext(ctx, "failed to clear login failures", "err", err)
+		}
+		h.audit(r, storage.AuditEntry{Actor: email, Action: storage.AuditLoginSuccess})
+		loginSuccesses.Inc()
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	})
 }
@@ -171,30 +340,58 @@ func (h *Handler) HandleLogout() http.HandlerFunc {
 }
 
 func (h *Handler) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return h.RequireRole(auth.RoleReporter, next)
+}
+
+// RequireSession пускает только по cookie-сессии: управлять токенами и сессиями токеном нельзя.
+func (h *Handler) RequireSession(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if _, ok := h.authManager.GetSession(r); !ok {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		if hasBearer(r) {
+			http.Error(w, "this page requires an interactive sign-in", http.StatusForbidden)
 			return
 		}
-		next(w, r)
+		h.RequireAuth(next)(w, r)
 	}
 }
 
+func hasBearer(r *http.Request) bool {
+	_, ok := auth.BearerToken(r)
+	return ok
+}
+
 func (h *Handler) renderTemplate(w http.ResponseWriter, name string, data map[string]any) {
 	if err := h.tmpl.ExecuteTemplate(w, name, data); err != nil {
-		log.Printf("render %s: %v", name, err)
+		slog.Error("render template", "template", name, "err", err)
 		http.Error(w, "template error", http.StatusInternalServerError)
-	} else {
-		log.Printf("rendered %s", name)
 	}
 }
 
-func (h *Handler) viewData(email, title, bodyTemplate string, extras ...map[string]any) map[string]any {
+// renderError показывает страницу ошибки вместо plain-text http.Error.
+func (h *Handler) renderError(w http.ResponseWriter, r *http.Request, status int, title, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	h.renderTemplate(w, "layout", h.viewData(r, title, "error", map[string]any{
+		"ErrorTitle":   title,
+		"ErrorMessage": message,
+		"StatusCode":   status,
+	}))
+}
+
+// viewData собирает общие данные шаблона: пользователя, права, CSRF-токен текущей сессии
+// и CSP nonce запроса.
+func (h *Handler) viewData(r *http.Request, title, bodyTemplate string, extras ...map[string]any) map[string]any {
+	sess, _ := h.authManager.CurrentSession(r)
+	email := sess.Email
+	if email == "" {
+		email = principalFrom(r).Email
+	}
 	data := map[string]any{
 		"Title":           title,
 		"Email":           email,
 		"ContentTemplate": bodyTemplate,
-		"IsAdmin":         h.IsAdmin(email),
+		"Perms":           h.Permissions(email),
+		"CSRFToken":       sess.CSRFToken,
+		"CSPNonce":        cspNonce(r),
 	}
 	for _, extra := range extras {
 		for k, v := range extra {
@@ -219,16 +416,31 @@ func (h *Handler) consumeState(state string) bool {
 
 func (h *Handler) HandleAdmin() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		email, _ := h.authManager.GetSession(r)
-		complaints, err := h.store.ListAll()
+		q, errMsg := listQuery(r, true)
+		page, err := h.store.Query(q)
 		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to query complaints", "err", err)
 			http.Error(w, "failed to list complaints", http.StatusInternalServerError)
 			return
 		}
-		h.renderTemplate(w, "layout", h.viewData(email, "Admin Panel", "admin", map[string]any{
-			"Complaints": complaints,
-			"IsAdmin":    true,
-		}))
+
+		statuses := make([]string, len(storage.Statuses))
+		labels := make([]string, len(storage.Statuses))
+		for i, st := range storage.Statuses {
+			statuses[i], labels[i] = string(st), st.Label()
+		}
+		data := map[string]any{
+			"Complaints":      page.Complaints,
+			"Query":           r.URL.Query(),
+			"Sorts":           storage.Sorts,
+			"StatusChips":     chips(r, "status", statuses, labels),
+			"VisibilityChips": chips(r, "visibility", []string{"visible", "hidden"}, []string{"Visible", "Hidden"}),
+			"Pager":           newPager(r, q, page.Total),
+		}
+		if errMsg != "" {
+			data["Error"] = errMsg
+		}
+		h.renderTemplate(w, "layout", h.viewData(r, "Admin Panel", "admin", data))
 	}
 }
 
@@ -244,23 +456,58 @@ func (h *Handler) HandleToggleHidden() http.HandlerFunc {
 			return
 		}
 
-		idStr := r.FormValue("id")
-		if idStr == "" {
-			http.Error(w, "id required", http.StatusBadRequest)
+		id, err := formID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		var id int
-		if _, err := fmt.Sscanf(idStr, "%d", &id); err != nil {
-			http.Error(w, "invalid id", http.StatusBadRequest)
+		hidden := r.FormValue("hidden") == "true"
+
+		if err := h.setHidden(r, id, hidden); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				http.Error(w, "complaint not found", http.StatusNotFound)
+				return
+			}
+			slog.ErrorContext(r.Context(), "failed to toggle hidden", "err", err)
+			http.Error(w, "failed to update", http.StatusInternalServerError)
 			return
 		}
 
-		hidden := r.FormValue("hidden") == "true"
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}
+}
 
-		if err := h.store.SetHidden(id, hidden); err != nil {
-			log.Printf("failed to toggle hidden: %v", err)
-			http.Error(w, "failed to update", http.StatusInternalServerError)
+func (h *Handler) HandleSetStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		id, err := formID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		status, ok := storage.ParseStatus(r.FormValue("status"))
+		if !ok {
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+		note := strings.TrimSpace(r.FormValue("note"))
+
+		if err := h.setStatus(r, id, status, note); err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				http.Error(w, "complaint not found", http.StatusNotFound)
+			case errors.Is(err, storage.ErrInvalidTransition):
+				http.Error(w, "status transition not allowed", http.StatusBadRequest)
+			default:
+				slog.ErrorContext(r.Context(), "failed to set status", "err", err)
+				http.Error(w, "failed to update", http.StatusInternalServerError)
+			}
 			return
 		}
 
@@ -268,6 +515,106 @@ func (h *Handler) HandleToggleHidden() http.HandlerFunc {
 	}
 }
 
+// setHidden меняет видимость жалобы и пишет изменение в журнал аудита.
+func (h *Handler) setHidden(r *http.Request, id int, hidden bool) error {
+	return h.updateComplaint(r, id, storage.ComplaintUpdate{Hidden: &hidden})
+}
+
+// setStatus переводит жалобу в новый статус от имени пользователя запроса и пишет это в журнал.
+func (h *Handler) setStatus(r *http.Request, id int, status storage.Status, note string) error {
+	return h.updateComplaint(r, id, storage.ComplaintUpdate{Status: &status, Note: note})
+}
+
+// updateComplaint применяет изменения одной операцией хранилища и только после нее
+// пишет журнал, письма и вебхуки по каждому измененному полю. Прежнее состояние
+// для журнала возвращает сама операция, так что параллельная правка не исказит Before.
+func (h *Handler) updateComplaint(r *http.Request, id int, u storage.ComplaintUpdate) error {
+	actor := principalFrom(r).Email
+	u.Actor = actor
+	c, err := h.store.Update(id, u)
+	if err != nil {
+		return err
+	}
+
+	if u.Status != nil {
+		status, note := *u.Status, u.Note
+		after := string(status)
+		if note != "" {
+			after += ": " + note
+		}
+		h.audit(r, storage.AuditEntry{
+			Action:      storage.AuditComplaintStatus,
+			ComplaintID: id,
+			Before:      string(c.Status),
+			After:       after,
+		})
+		from := c.Status
+		c.Status = status
+		h.notifier.StatusChanged(c, from, note)
+		h.webhooks.Emit(webhook.EventStatusChanged, webhook.StatusChangeData{
+			Complaint: webhook.Complaint(c),
+			From:      string(from),
+			To:        string(status),
+			Actor:     actor,
+			Note:      note,
+		})
+	}
+	if u.Hidden != nil {
+		hidden := *u.Hidden
+		action := storage.AuditComplaintUnhide
+		if hidden {
+			action = storage.AuditComplaintHide
+		}
+		h.audit(r, storage.AuditEntry{
+			Action:      action,
+			ComplaintID: id,
+			Before:      strconv.FormatBool(c.Hidden),
+			After:       strconv.FormatBool(hidden),
+		})
+		if hidden && !c.Hidden {
+			complaintsHidden.Inc()
+		}
+		c.Hidden = hidden
+		h.webhooks.Emit(webhook.EventComplaintHidden, webhook.HiddenData{
+			Complaint: webhook.Complaint(c),
+			Hidden:    hidden,
+			Actor:     actor,
+		})
+	}
+	return nil
+}
+
+// reporterID - значение Complaint.Reporter: email или, для анонимной жалобы, псевдоним.
+func (h *Handler) reporterID(email string, anonymous bool) string {
+	if anonymous {
+		return h.authManager.Pseudonym(email)
+	}
+	return email
+}
+
+// owns сообщает, подана ли жалоба пользователем email, в том числе анонимно.
+func (h *Handler) owns(c storage.Complaint, email string) bool {
+	if email == "" {
+		return false
+	}
+	if c.Anonymous {
+		return c.Reporter == h.authManager.Pseudonym(email)
+	}
+	return strings.EqualFold(c.Reporter, email)
+}
+
+func formID(r *http.Request) (int, error) {
+	idStr := r.FormValue("id")
+	if idStr == "" {
+		return 0, errors.New("id required")
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return 0, errors.New("invalid id")
+	}
+	return id, nil
+}
+
 func randomState() string {
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
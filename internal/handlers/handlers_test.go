@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/ratelimit"
+	"donos-hrm/internal/storage"
+)
+
+// testEnv - Handler на хранилищах в памяти и с шаблонами из корня репозитория.
+type testEnv struct {
+	*Handler
+	store *storage.MemoryStore
+	roles *auth.MemoryRoleStore
+	audit *storage.MemoryAuditStore
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	tmpl, err := template.ParseGlob(filepath.Join("..", "..", "templates", "*.gohtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := ratelimit.NewLimiter(ratelimit.Config{})
+	t.Cleanup(limiter.Stop)
+	authManager := auth.NewManager(auth.Config{BaseURL: "http://localhost", PseudonymKey: []byte("test")})
+	t.Cleanup(authManager.Stop)
+
+	e := &testEnv{
+		store: storage.NewMemoryStore(),
+		roles: auth.NewMemoryRoleStore(),
+		audit: storage.NewMemoryAuditStore(),
+	}
+	e.Handler = New(tmpl, e.store, authManager, limiter, e.roles,
+		UploadConfig{Blobs: storage.NewMemoryBlobStore()}, e.audit, nil, nil, QuotaConfig{})
+	return e
+}
+
+// signIn открывает сессию email и возвращает ее cookie и CSRF-токен.
+func (e *testEnv) signIn(t *testing.T, email string) (*http.Cookie, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	sess, err := e.authManager.CreateSession(rec, httptest.NewRequest(http.MethodGet, "/", nil), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("CreateSession set %d cookies, want 1", len(cookies))
+	}
+	return cookies[0], sess.CSRFToken
+}
+
+// auditActions возвращает действия из журнала аудита, новые первыми.
+func (e *testEnv) auditActions(t *testing.T) []string {
+	t.Helper()
+	entries, err := e.audit.Entries(storage.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+	}
+	return actions
+}
+
+// Свои жалобы в списке узнаются без учета регистра адреса, как и в owns.
+func TestListOwnComplaints(t *testing.T) {
+	e := newTestEnv(t)
+	add := func(c storage.Complaint) string {
+		t.Helper()
+		c.Description = "d"
+		c, err := e.store.Add(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return `href="/complaints/` + strconv.Itoa(c.ID) + `"`
+	}
+	own := add(storage.Complaint{Reporter: "User@Example.com", Subject: "mine"})
+	anon := add(storage.Complaint{Reporter: e.authManager.Pseudonym("user@example.com"), Anonymous: true, Subject: "anonymous"})
+	other := add(storage.Complaint{Reporter: "other@example.com", Subject: "theirs"})
+
+	cookie, _ := e.signIn(t, "user@example.com")
+	r := httptest.NewRequest(http.MethodGet, "/complaints", nil)
+	r.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	e.RequireAuth(e.HandleList())(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, own) || !strings.Contains(body, anon) {
+		t.Errorf("own complaints are not linked:\n%s", body)
+	}
+	if strings.Contains(body, other) {
+		t.Error("another user's complaint is linked")
+	}
+	if n := strings.Count(body, `class="status-badge`); n != 2 {
+		t.Errorf("%d status badges, want 2", n)
+	}
+	if !strings.Contains(body, "Anonymous (you)") {
+		t.Error("own anonymous complaint is not marked")
+	}
+}
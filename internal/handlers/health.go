@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Пробы для оркестратора. Отвечают JSON, не требуют входа и не проходят через
+// rate limiter, чтобы частые проверки не мешали пользователям и не блокировались сами.
+
+var errTemplatesNotLoaded = errors.New("layout template is not loaded")
+
+// HandleHealthz отвечает 200, пока процесс обслуживает запросы.
+func (h *Handler) HandleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// HandleReadyz проверяет шаблоны, запись в хранилище (writable) и хранилище сессий.
+// Подробности ошибок пишутся в лог, наружу уходит только имя проверки.
+func (h *Handler) HandleReadyz(writable func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checks := map[string]error{
+			"templates": nil,
+			"storage":   writable(),
+			"sessions":  h.authManager.CheckSessions(),
+		}
+		if h.tmpl == nil || h.tmpl.Lookup("layout") == nil {
+			checks["templates"] = errTemplatesNotLoaded
+		}
+
+		status := http.StatusOK
+		result := make(map[string]string, len(checks))
+		for name, err := range checks {
+			if err != nil {
+				slog.WarnContext(r.Context(), "readyz: check failed", "check", name, "err", err)
+				result[name] = "fail"
+				status = http.StatusServiceUnavailable
+				continue
+			}
+			result[name] = "ok"
+		}
+		overall := "ok"
+		if status != http.StatusOK {
+			overall = "unavailable"
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, status, map[string]any{"status": overall, "checks": result})
+	}
+}
+
+type versionInfo struct {
+	Module        string    `json:"module"`
+	Version       string    `json:"version"`
+	GoVersion     string    `json:"go_version"`
+	Revision      string    `json:"vcs_revision,omitempty"`
+	RevisionTime  string    `json:"vcs_time,omitempty"`
+	Modified      bool      `json:"vcs_modified,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	StorageDriver string    `json:"storage_driver"`
+}
+
+// HandleVersion отдает сведения о сборке из debug.ReadBuildInfo, время запуска и драйвер хранилища.
+func (h *Handler) HandleVersion(driver string, startedAt time.Time) http.HandlerFunc {
+	info := versionInfo{StartedAt: startedAt, StorageDriver: driver}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Module = bi.Main.Path
+		info.Version = bi.Main.Version
+		info.GoVersion = bi.GoVersion
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Revision = s.Value
+			case "vcs.time":
+				info.RevisionTime = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := info
+		resp.UptimeSeconds = int64(time.Since(startedAt).Seconds())
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"donos-hrm/internal/logging"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Маршруты проб пишутся в access log с уровнем debug, чтобы не забивать лог.
+var quietRoutes = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// RequestID присваивает запросу ID, кладет его в контекст для логов и возвращает
+// в заголовке X-Request-ID. ID от прокси принимается, если он похож на ID.
+func (h *Handler) RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestInfo заполняется по ходу обработки и читается access log после ответа:
+// пользователь известен только внутри RequireRole, а лог пишется снаружи роутера.
+type requestInfo struct {
+	email string
+}
+
+type requestInfoKey struct{}
+
+func withRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	info := &requestInfo{}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)), info
+}
+
+func requestInfoFrom(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// logAccess пишет строку access log. Вместо email - LogID пользователя: по нему можно
+// связать запросы одного человека, не зная, кто это. Псевдоним анонимных жалоб сюда не
+// пишется, иначе лог связал бы анонимную жалобу с IP автора.
+func (h *Handler) logAccess(r *http.Request, route string, status int, elapsed time.Duration, info *requestInfo) {
+	level := slog.LevelInfo
+	switch {
+	case status >= 500:
+		level = slog.LevelError
+	case quietRoutes[route]:
+		level = slog.LevelDebug
+	}
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("route", route),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+		slog.String("ip", h.rateLimiter.GetIP(r)),
+	}
+	if info.email != "" {
+		attrs = append(attrs, slog.String("user", h.authManager.LogID(info.email)))
+	}
+	slog.LogAttrs(r.Context(), level, "request", attrs...)
+}
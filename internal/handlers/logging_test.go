@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// В access log пользователь виден под LogID, а не под псевдонимом анонимных жалоб:
+// иначе строка лога с IP выдала бы автора анонимной жалобы.
+func TestAccessLogUserID(t *testing.T) {
+	e := newTestEnv(t)
+	var out bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&out, nil)))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/complaints", e.RequireAuth(func(w http.ResponseWriter, r *http.Request) {}))
+	cookie, _ := e.signIn(t, "user@example.com")
+	r := httptest.NewRequest(http.MethodGet, "/complaints", nil)
+	r.AddCookie(cookie)
+	e.Instrument(router).ServeHTTP(httptest.NewRecorder(), r)
+
+	logID := e.authManager.LogID("User@Example.com")
+	pseudonym := e.authManager.Pseudonym("user@example.com")
+	if !strings.HasPrefix(logID, "user-") || logID == e.authManager.LogID("other@example.com") {
+		t.Fatalf("LogID = %q", logID)
+	}
+	if strings.TrimPrefix(logID, "user-") == strings.TrimPrefix(pseudonym, "anon-") {
+		t.Fatal("LogID reuses the pseudonym key")
+	}
+	line := out.String()
+	if !strings.Contains(line, `"user":"`+logID+`"`) {
+		t.Errorf("access log has no LogID %s:\n%s", logID, line)
+	}
+	for _, leak := range []string{pseudonym, strings.TrimPrefix(pseudonym, "anon-"), "user@example.com"} {
+		if strings.Contains(line, leak) {
+			t.Errorf("access log contains %q:\n%s", leak, line)
+		}
+	}
+}
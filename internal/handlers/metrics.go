@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounterVec("donos_http_requests_total",
+		"HTTP requests by route template, method and status code.", "route", "method", "status")
+	httpDuration = metrics.NewHistogramVec("donos_http_request_duration_seconds",
+		"HTTP request latency by route template, method and status code.", nil, "route", "method", "status")
+	complaintsCreated = metrics.NewCounterVec("donos_complaints_created_total",
+		"Complaints submitted via the form or the API.")
+	complaintsHidden = metrics.NewCounterVec("donos_complaints_hidden_total",
+		"Complaints hidden by moderators.")
+	loginSuccesses = metrics.NewCounterVec("donos_login_successes_total",
+		"Successful sign-ins.")
+	loginFailures = metrics.NewCounterVec("donos_login_failures_total",
+		"Failed sign-ins by reason.", "reason")
+)
+
+// Причины неудачного входа для donos_login_failures_total
+const (
+	loginInvalidState  = "invalid_state"
+	loginMissingCode   = "missing_code"
+	loginExchange      = "exchange_failed"
+	loginUserInfo      = "userinfo_failed"
+	loginDenied        = "denied"
+	loginRateLimited   = "rate_limited"
+	loginLockedOut     = "locked_out"
+	loginBanned        = "banned"
+	loginSessionFailed = "session_failed"
+)
+
+// Маршрут для запросов, не совпавших ни с одним путем. Сам путь в метку не попадает,
+// иначе сканеры раздуют число серий.
+const unmatchedRoute = "unmatched"
+
+// Метод запроса вне стандартного набора попадает в метку как otherMethod: метод
+// приходит от клиента и не должен множить серии.
+const otherMethod = "OTHER"
+
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return otherMethod
+}
+
+// statusRecorder запоминает код ответа для метрик.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Instrument считает запросы и их длительность по шаблону маршрута и пишет access log.
+// Оборачивает весь роутер, а не подключается через Use, чтобы учитывать и 404/405.
+func (h *Handler) Instrument(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, info := withRequestInfo(r)
+		route := unmatchedRoute
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if tmpl, err := match.Route.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		router.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+		status := strconv.Itoa(rec.status)
+		method := methodLabel(r.Method)
+		httpRequests.Inc(route, method, status)
+		httpDuration.Observe(elapsed.Seconds(), route, method, status)
+		h.logAccess(r, route, rec.status, elapsed, info)
+	})
+}
+
+// HandleMetrics отдает метрики в формате Prometheus. Если token задан, требуется
+// заголовок Authorization: Bearer с этим значением.
+func (h *Handler) HandleMetrics(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			got, ok := auth.BearerToken(r)
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", metrics.ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		metrics.Default.WriteText(w)
+	}
+}
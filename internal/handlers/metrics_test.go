@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"donos-hrm/internal/metrics"
+)
+
+func TestInstrumentMethodLabel(t *testing.T) {
+	e := newTestEnv(t)
+	router := mux.NewRouter()
+	router.HandleFunc("/metrics-method-test", func(w http.ResponseWriter, r *http.Request) {})
+	handler := e.Instrument(router)
+
+	for _, method := range []string{http.MethodGet, http.MethodPost, "PROPFIND", "X-" + strings.Repeat("A", 64)} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/metrics-method-test", nil))
+	}
+
+	var out bytes.Buffer
+	metrics.Default.WriteText(&out)
+	methods := map[string]bool{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if !strings.HasPrefix(line, "donos_http_requests_total{") || !strings.Contains(line, `route="/metrics-method-test"`) {
+			continue
+		}
+		_, rest, _ := strings.Cut(line, `method="`)
+		method, _, _ := strings.Cut(rest, `"`)
+		methods[method] = true
+	}
+	if len(methods) != 3 || !methods["GET"] || !methods["POST"] || !methods["OTHER"] {
+		t.Fatalf("method labels = %v, want GET, POST and OTHER", methods)
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/ratelimit"
+)
+
+// principal - аутентифицированный пользователь запроса: по cookie-сессии или по API-токену.
+type principal struct {
+	Email string
+	Role  auth.Role
+	Token *auth.APIToken // nil для входа через сессию
+}
+
+type principalKey struct{}
+
+func principalFrom(r *http.Request) principal {
+	p, _ := r.Context().Value(principalKey{}).(principal)
+	return p
+}
+
+func withPrincipal(r *http.Request, p principal) *http.Request {
+	if info := requestInfoFrom(r); info != nil {
+		info.email = p.Email
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+// allows проверяет scope токена; сессия ограничена только ролью.
+// Токен не расширяет права: scope работает, лишь пока роль владельца его допускает.
+func (p principal) allows(scope auth.Scope) bool {
+	if p.Token == nil {
+		return true
+	}
+	return p.Token.HasScope(scope) && p.Role.AtLeast(scope.MinRole())
+}
+
+// authenticate определяет пользователя по Authorization: Bearer или cookie-сессии.
+// Если заголовок передан, cookie не используется: неверный токен - это отказ, а не откат на сессию.
+func (h *Handler) authenticate(r *http.Request) (principal, bool) {
+	if raw, ok := auth.BearerToken(r); ok {
+		t, err := h.authManager.AuthenticateToken(raw)
+		if err != nil {
+			if !errors.Is(err, auth.ErrTokenNotFound) && !errors.Is(err, auth.ErrTokenExpired) {
+				slog.ErrorContext(r.Context(), "authenticate token", "err", err)
+			}
+			return principal{}, false
+		}
+		return principal{Email: t.Email, Role: h.Role(t.Email), Token: &t}, true
+	}
+	email, ok := h.authManager.GetSession(r)
+	if !ok {
+		return principal{}, false
+	}
+	return principal{Email: email, Role: h.Role(email)}, true
+}
+
+// authorize проверяет роль и, для токенов, scope маршрута. Возвращает http-статус отказа
+// и пояснение; http.StatusOK означает, что доступ разрешен.
+func (h *Handler) authorize(r *http.Request, required auth.Role) (principal, int, string) {
+	p, ok := h.authenticate(r)
+	if !ok {
+		return principal{}, http.StatusUnauthorized, "authentication required"
+	}
+	// Бан действует и на уже открытые сессии и выданные токены
+	if h.rateLimiter.IPAccess(h.rateLimiter.GetIP(r)) == ratelimit.AccessBan || h.rateLimiter.EmailAccess(p.Email) == ratelimit.AccessBan {
+		return p, http.StatusForbidden, "access denied"
+	}
+	if !p.Role.AtLeast(required) {
+		return p, http.StatusForbidden, "insufficient role"
+	}
+	if scope := routeScope(required, r.Method); !p.allows(scope) {
+		return p, http.StatusForbidden, "token lacks scope " + string(scope)
+	}
+	return p, http.StatusOK, ""
+}
+
+// routeScope - scope, нужный токену для маршрута с ролью required:
+// чтение или изменение своих данных для репортеров, admin:read/admin:write для сотрудников.
+func routeScope(required auth.Role, method string) auth.Scope {
+	safe := method == http.MethodGet || method == http.MethodHead
+	switch {
+	case required.AtLeast(auth.RoleReviewer) && safe:
+		return auth.ScopeAdminRead
+	case required.AtLeast(auth.RoleReviewer):
+		return auth.ScopeAdminWrite
+	case safe:
+		return auth.ScopeReadOwn
+	default:
+		return auth.ScopeSubmit
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"donos-hrm/internal/storage"
+)
+
+// Жалоб на одной странице списка
+const listPageSize = 20
+
+// Сколько номеров страниц показывать вокруг текущей
+const pagerWindow = 3
+
+// parseDay разбирает дату из фильтров в формате поля <input type="date">.
+func parseDay(v string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", v, time.Local)
+}
+
+// listQuery строит storage.Query из параметров адресной строки. Параметры, которые
+// пользователю не положены (staff == false), игнорируются. Сообщение об ошибке в
+// фильтрах возвращается для показа на странице; сами неверные значения не применяются.
+func listQuery(r *http.Request, staff bool) (storage.Query, string) {
+	v := r.URL.Query()
+	q := storage.Query{
+		Text:  strings.TrimSpace(v.Get("q")),
+		Sort:  storage.SortNewest,
+		Limit: listPageSize,
+	}
+	var errMsg string
+
+	if s, ok := storage.ParseSort(v.Get("sort")); ok {
+		q.Sort = s
+	}
+	if page, err := strconv.Atoi(v.Get("page")); err == nil && page > 1 {
+		q.Offset = (page - 1) * listPageSize
+	}
+	if !staff {
+		return q, errMsg
+	}
+
+	if reporter := strings.TrimSpace(v.Get("reporter")); reporter != "" {
+		q.Reporters = []string{reporter}
+	}
+	q.Status, _ = storage.ParseStatus(v.Get("status"))
+	switch v.Get("visibility") {
+	case "visible":
+		q.Hidden = storage.Bool(false)
+	case "hidden":
+		q.Hidden = storage.Bool(true)
+	}
+	if from := v.Get("from"); from != "" {
+		t, err := parseDay(from)
+		if err != nil {
+			errMsg = "Dates must be in YYYY-MM-DD format."
+		}
+		q.Since = t
+	}
+	if to := v.Get("to"); to != "" {
+		t, err := parseDay(to)
+		if err != nil {
+			errMsg = "Dates must be in YYYY-MM-DD format."
+		} else {
+			// Включительно: до конца указанного дня
+			q.Until = t.AddDate(0, 0, 1)
+		}
+	}
+	return q, errMsg
+}
+
+// pageLink - ссылка в навигации по страницам.
+type pageLink struct {
+	Number  int
+	URL     string
+	Current bool
+}
+
+// pager - навигация по страницам для шаблона "pager".
+type pager struct {
+	Total int
+	Prev  string
+	Next  string
+	Links []pageLink
+}
+
+// newPager строит ссылки на страницы, сохраняя остальные параметры запроса.
+func newPager(r *http.Request, q storage.Query, total int) pager {
+	p := pager{Total: total}
+	pages := (total + listPageSize - 1) / listPageSize
+	if pages <= 1 {
+		return p
+	}
+	current := q.Offset/listPageSize + 1
+
+	link := func(n int) string {
+		v := r.URL.Query()
+		if n == 1 {
+			v.Del("page")
+		} else {
+			v.Set("page", strconv.Itoa(n))
+		}
+		u := url.URL{Path: r.URL.Path, RawQuery: v.Encode()}
+		return u.String()
+	}
+	if current > 1 {
+		p.Prev = link(current - 1)
+	}
+	if current < pages {
+		p.Next = link(current + 1)
+	}
+	for n := max(1, current-pagerWindow); n <= min(pages, current+pagerWindow); n++ {
+		p.Links = append(p.Links, pageLink{Number: n, URL: link(n), Current: n == current})
+	}
+	return p
+}
+
+// filterChip - переключатель фильтра над списком.
+type filterChip struct {
+	Label  string
+	URL    string
+	Active bool
+}
+
+// chipURL - текущий адрес с параметром key = value (пустое значение убирает параметр).
+// Номер страницы сбрасывается: после смены фильтра она может не существовать.
+func chipURL(r *http.Request, key, value string) string {
+	v := r.URL.Query()
+	v.Del("page")
+	if value == "" {
+		v.Del(key)
+	} else {
+		v.Set(key, value)
+	}
+	u := url.URL{Path: r.URL.Path, RawQuery: v.Encode()}
+	return u.String()
+}
+
+// chips строит переключатели для параметра key; первым идет "All" без значения.
+func chips(r *http.Request, key string, values, labels []string) []filterChip {
+	current := r.URL.Query().Get(key)
+	result := []filterChip{{Label: "All", URL: chipURL(r, key, ""), Active: current == ""}}
+	for i, v := range values {
+		result = append(result, filterChip{Label: labels[i], URL: chipURL(r, key, v), Active: current == v})
+	}
+	return result
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/ratelimit"
+	"donos-hrm/internal/storage"
+)
+
+// failingAdds - хранилище, которое не может сохранить жалобу.
+type failingAdds struct {
+	storage.Store
+}
+
+func (failingAdds) Add(storage.Complaint) (storage.Complaint, error) {
+	return storage.Complaint{}, errors.New("disk full")
+}
+
+// slowAdds - хранилище, которое сохраняет жалобу с задержкой, чтобы запросы пересекались.
+type slowAdds struct {
+	storage.Store
+}
+
+func (s slowAdds) Add(c storage.Complaint) (storage.Complaint, error) {
+	time.Sleep(20 * time.Millisecond)
+	return s.Store.Add(c)
+}
+
+// quotaLeft возвращает остаток часовой квоты пользователя.
+func (e *testEnv) quotaLeft(t *testing.T, email string) int {
+	t.Helper()
+	return e.rateLimiter.PeekQuota(t.Context(), e.quotaKey(email), e.quotaFor(email)).Hourly.Remaining
+}
+
+// Квота расходуется только на сохраненные жалобы: ошибки в полях, вложениях и
+// хранилище ее не трогают.
+func TestFormQuota(t *testing.T) {
+	e := newTestEnv(t)
+	e.quotas.Default = ratelimit.Quota{Hourly: 1, Daily: 5}
+	cookie, _ := e.signIn(t, "user@example.com")
+
+	submit := func(subject, description, file string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("subject", subject)
+		mw.WriteField("description", description)
+		if file != "" {
+			fw, _ := mw.CreateFormFile("attachments", "evidence.bin")
+			fw.Write([]byte(file))
+		}
+		mw.Close()
+		r := httptest.NewRequest(http.MethodPost, "/", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		r.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		e.RequireAuth(e.HandleForm())(rec, r)
+		return rec
+	}
+	stored := func(t *testing.T, complaints, blobs int) {
+		t.Helper()
+		all, err := e.store.ListAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, err := e.uploads.Blobs.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != complaints || len(list) != blobs {
+			t.Fatalf("stored %d complaints and %d blobs, want %d and %d", len(all), len(list), complaints, blobs)
+		}
+	}
+
+	rejected := []struct {
+		name, subject, description, file string
+		status                           int
+	}{
+		{"missing subject", " ", "d", "", http.StatusBadRequest},
+		{"missing description", "s", "", "notes", http.StatusBadRequest},
+		{"bad attachment", "s", "d", "MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff", http.StatusBadRequest},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := submit(tt.subject, tt.description, tt.file); rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if left := e.quotaLeft(t, "user@example.com"); left != 1 {
+				t.Fatalf("quota left = %d, want 1", left)
+			}
+			stored(t, 0, 0)
+		})
+	}
+
+	t.Run("store failure", func(t *testing.T) {
+		store := e.Handler.store
+		e.Handler.store = failingAdds{store}
+		defer func() { e.Handler.store = store }()
+		submit("s", "d", "notes")
+		if left := e.quotaLeft(t, "user@example.com"); left != 1 {
+			t.Fatalf("quota left = %d, want 1", left)
+		}
+		stored(t, 0, 0)
+	})
+
+	t.Run("accepted", func(t *testing.T) {
+		rec := submit("s", "d", "notes")
+		if rec.Code != http.StatusSeeOther {
+			t.Fatalf("status = %d, want 303: %s", rec.Code, rec.Body)
+		}
+		if rec.Header().Get("RateLimit-Remaining") != "0" {
+			t.Errorf("RateLimit-Remaining = %q, want 0", rec.Header().Get("RateLimit-Remaining"))
+		}
+		stored(t, 1, 1)
+	})
+
+	t.Run("over quota", func(t *testing.T) {
+		rec := submit("s", "d", "notes")
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+			t.Fatalf("status = %d, Retry-After %q; want 429 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+		}
+		stored(t, 1, 1)
+	})
+}
+
+func TestAPICreateComplaintQuota(t *testing.T) {
+	e := newTestEnv(t)
+	e.quotas.Default = ratelimit.Quota{Hourly: 1, Daily: 5}
+	cookie, _ := e.signIn(t, "user@example.com")
+
+	create := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/complaints", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		e.APIRequire(auth.RoleReporter, e.APICreateComplaint())(rec, r)
+		return rec
+	}
+
+	if rec := create(`{"subject": "  ", "description": "d"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("missing subject: status = %d, want 422", rec.Code)
+	}
+	store := e.Handler.store
+	e.Handler.store = failingAdds{store}
+	if rec := create(`{"subject": "s", "description": "d"}`); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("store failure: status = %d, want 500", rec.Code)
+	}
+	e.Handler.store = store
+	if left := e.quotaLeft(t, "user@example.com"); left != 1 {
+		t.Fatalf("quota left = %d after failed requests, want 1", left)
+	}
+
+	if rec := create(`{"subject": " s ", "description": "d"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", rec.Code, rec.Body)
+	}
+	if rec := create(`{"subject": "s", "description": "d"}`); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over quota: status = %d, want 429", rec.Code)
+	}
+	all, err := e.store.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Subject != "s" {
+		t.Fatalf("stored %+v, want one complaint with a trimmed subject", all)
+	}
+}
+
+// Параллельные отправки не проходят проверку квоты все разом: каждая занимает отправку
+// до сохранения.
+func TestConcurrentSubmissionsQuota(t *testing.T) {
+	e := newTestEnv(t)
+	e.quotas.Default = ratelimit.Quota{Hourly: 3, Daily: 5}
+	e.Handler.store = slowAdds{e.store}
+	cookie, _ := e.signIn(t, "user@example.com")
+	handler := e.APIRequire(auth.RoleReporter, e.APICreateComplaint())
+
+	const requests = 10
+	codes := make([]int, requests)
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/complaints", strings.NewReader(`{"subject": "s", "description": "d"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.AddCookie(cookie)
+			rec := httptest.NewRecorder()
+			handler(rec, r)
+			codes[i] = rec.Code
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusTooManyRequests:
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	all, err := e.store.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 3 || len(all) != 3 {
+		t.Fatalf("created %d, stored %d complaints; want 3", created, len(all))
+	}
+}
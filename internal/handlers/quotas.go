@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"donos-hrm/internal/ratelimit"
+	"donos-hrm/internal/storage"
+)
+
+// HandleQuotas - страница индивидуальных квот на отправку жалоб.
+func (h *Handler) HandleQuotas() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.renderQuotas(w, r, "")
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, "invalid form", http.StatusBadRequest)
+				return
+			}
+			target := strings.ToLower(strings.TrimSpace(r.FormValue("email")))
+			if target == "" || !strings.Contains(target, "@") {
+				h.renderQuotas(w, r, "A valid email is required.")
+				return
+			}
+			switch r.FormValue("action") {
+			case "set":
+				h.setQuota(w, r, target)
+			case "reset":
+				h.resetQuota(w, r, target)
+			default:
+				http.Error(w, "unknown action", http.StatusBadRequest)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (h *Handler) setQuota(w http.ResponseWriter, r *http.Request, target string) {
+	hourly, err1 := strconv.Atoi(r.FormValue("hourly"))
+	daily, err2 := strconv.Atoi(r.FormValue("daily"))
+	q := ratelimit.Quota{Hourly: hourly, Daily: daily}
+	if err1 != nil || err2 != nil || q.Validate() != nil {
+		h.renderQuotas(w, r, "Limits must be positive whole numbers, and the hourly limit cannot exceed the daily one.")
+		return
+	}
+
+	before := h.quotaLabel(target)
+	err := h.quotas.Store.SetOverride(ratelimit.QuotaOverride{
+		Email: target,
+		Quota: q,
+		SetBy: principalFrom(r).Email,
+		SetAt: time.Now(),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to set quota", "err", err)
+		http.Error(w, "failed to update quota", http.StatusInternalServerError)
+		return
+	}
+	h.forgetSubmissions(r, target)
+	h.audit(r, storage.AuditEntry{
+		Action: storage.AuditQuotaChange,
+		Target: target,
+		Before: before,
+		After:  q.String(),
+	})
+	http.Redirect(w, r, "/admin/quotas", http.StatusSeeOther)
+}
+
+func (h *Handler) resetQuota(w http.ResponseWriter, r *http.Request, target string) {
+	before := h.quotaLabel(target)
+	if err := h.quotas.Store.DeleteOverride(target); err != nil {
+		slog.ErrorContext(r.Context(), "failed to reset quota", "err", err)
+		http.Error(w, "failed to update quota", http.StatusInternalServerError)
+		return
+	}
+	h.forgetSubmissions(r, target)
+	h.audit(r, storage.AuditEntry{
+		Action: storage.AuditQuotaChange,
+		Target: target,
+		Before: before,
+		After:  "default",
+	})
+	http.Redirect(w, r, "/admin/quotas", http.StatusSeeOther)
+}
+
+// forgetSubmissions сбрасывает накопленные отправки, чтобы новая квота действовала
+// сразу. Ошибка бэкенда не отменяет изменение: квота применится, когда окна освободятся.
+func (h *Handler) forgetSubmissions(r *http.Request, target string) {
+	if err := h.rateLimiter.ResetQuota(r.Context(), h.quotaKey(target)); err != nil {
+		slog.WarnContext(r.Context(), "failed to reset submission counters", "err", err)
+	}
+}
+
+// quotaLabel - квота пользователя для журнала аудита.
+func (h *Handler) quotaLabel(email string) string {
+	q, ok, err := h.quotas.Store.Override(email)
+	if err != nil || !ok {
+		return "default"
+	}
+	return q.String()
+}
+
+func (h *Handler) renderQuotas(w http.ResponseWriter, r *http.Request, errMsg string) {
+	overrides, err := h.quotas.Store.Overrides()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list quotas", "err", err)
+		http.Error(w, "failed to list quotas", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Overrides": overrides,
+		"Default":   h.quotas.Default,
+	}
+	if errMsg != "" {
+		data["Error"] = errMsg
+	}
+	h.renderTemplate(w, "layout", h.viewData(r, "Submission quotas", "quotas", data))
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"donos-hrm/internal/ratelimit"
+)
+
+// Пояснения на странице 429 по политикам
+var limitMessages = map[string]string{
+	ratelimit.PolicyLogin:        "There have been too many sign-in attempts from your network.",
+	ratelimit.PolicyCallback:     "There have been too many sign-in attempts.",
+	ratelimit.PolicyLockout:      "Sign-in is temporarily locked after repeated failed attempts.",
+	ratelimit.PolicySubmitHourly: "You have reached your hourly limit of new complaints.",
+	ratelimit.PolicySubmitDaily:  "You have reached your daily limit of new complaints.",
+}
+
+// TooManyRequests отвечает на запрос сверх лимита: API получает problem+json,
+// браузер - страницу с временем до следующей попытки. Заголовки RateLimit-* и
+// Retry-After выставляет вызывающий.
+func (h *Handler) TooManyRequests(w http.ResponseWriter, r *http.Request, res ratelimit.Result) {
+	retryIn := retryText(res.RetryAfter)
+	if isAPIRequest(r) {
+		writeProblem(w, http.StatusTooManyRequests, "rate limit exceeded, retry in "+retryIn)
+		return
+	}
+	msg, ok := limitMessages[res.Policy.Name]
+	if !ok {
+		msg = "You are sending requests too quickly."
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+	h.renderTemplate(w, "layout", h.viewData(r, "Too many requests", "ratelimited", map[string]any{
+		"LimitMessage": msg,
+		"RetryIn":      retryIn,
+	}))
+}
+
+// QuotaConfig - квоты на отправку жалоб. Без Store индивидуальных квот нет.
+type QuotaConfig struct {
+	Store   ratelimit.QuotaStore
+	Default ratelimit.Quota // по умолчанию ratelimit.DefaultQuota
+}
+
+func (c QuotaConfig) withDefaults() QuotaConfig {
+	if c.Store == nil {
+		c.Store = ratelimit.NewMemoryQuotaStore()
+	}
+	if c.Default == (ratelimit.Quota{}) {
+		c.Default = ratelimit.DefaultQuota
+	}
+	return c
+}
+
+// quotaFor возвращает квоту пользователя: назначенную администратором или общую.
+func (h *Handler) quotaFor(email string) ratelimit.Quota {
+	q, ok, err := h.quotas.Store.Override(email)
+	if err != nil {
+		slog.Error("failed to load quota override", "err", err)
+		return h.quotas.Default
+	}
+	if !ok {
+		return h.quotas.Default
+	}
+	return q
+}
+
+// quotaKey - ключ квоты в лимитере. Берется псевдоним, а не email: анонимные и
+// обычные жалобы одного человека расходуют одну квоту, а в памяти лимитера нет адресов.
+func (h *Handler) quotaKey(email string) string {
+	return "user:" + h.authManager.Pseudonym(email)
+}
+
+// reserveQuota расходует одну отправку пользователя и выставляет заголовки RateLimit-*.
+// Отправка занимается до сохранения жалобы: так параллельные запросы не пройдут
+// проверку все вместе. Если жалоба не сохранилась, отправку возвращает refundQuota.
+func (h *Handler) reserveQuota(w http.ResponseWriter, r *http.Request, email string) ratelimit.QuotaResult {
+	res := h.rateLimiter.AllowQuota(r.Context(), h.quotaKey(email), h.quotaFor(email))
+	ratelimit.SetHeaders(w.Header(), res.Result)
+	if !res.Allowed {
+		slog.WarnContext(r.Context(), "submission quota exceeded", "policy", res.Policy.Name)
+	}
+	return res
+}
+
+// refundQuota возвращает отправку, занятую reserveQuota, когда жалобу сохранить не удалось,
+// и обновляет заголовки RateLimit-*.
+func (h *Handler) refundQuota(w http.ResponseWriter, r *http.Request, email string) {
+	key, q := h.quotaKey(email), h.quotaFor(email)
+	if err := h.rateLimiter.RefundQuota(r.Context(), key, q); err != nil {
+		slog.ErrorContext(r.Context(), "failed to refund submission quota", "err", err)
+		return
+	}
+	ratelimit.SetHeaders(w.Header(), h.rateLimiter.PeekQuota(r.Context(), key, q).Result)
+}
+
+// quotaMessage - текст ошибки формы при исчерпанной квоте.
+func quotaMessage(res ratelimit.QuotaResult) string {
+	period := "hour"
+	if res.Policy.Name == ratelimit.PolicySubmitDaily {
+		period = "day"
+	}
+	return fmt.Sprintf("You can submit at most %s per %s. Your complaint was not saved; you can submit again in %s.",
+		plural(res.Policy.Limit, "complaint"), period, retryText(res.RetryAfter))
+}
+
+// retryText - "45 seconds", "3 minutes", "2 hours": с округлением вверх, чтобы
+// повтор в указанное время точно прошел.
+func retryText(d time.Duration) string {
+	switch {
+	case d <= time.Minute:
+		return plural(int((d+time.Second-1)/time.Second), "second")
+	case d <= time.Hour:
+		return plural(int((d+time.Minute-1)/time.Minute), "minute")
+	default:
+		return plural(int((d+time.Hour-1)/time.Hour), "hour")
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/storage"
+)
+
+func (h *Handler) HandleRoles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := principalFrom(r).Email
+
+		switch r.Method {
+		case http.MethodGet:
+			h.renderRoles(w, r, "")
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, "invalid form", http.StatusBadRequest)
+				return
+			}
+
+			target := strings.TrimSpace(r.FormValue("email"))
+			if target == "" || !strings.Contains(target, "@") {
+				h.renderRoles(w, r, "A valid email is required.")
+				return
+			}
+			role, ok := auth.ParseRole(r.FormValue("role"))
+			if !ok {
+				h.renderRoles(w, r, "Unknown role.")
+				return
+			}
+			// Не даем админу случайно лишить себя доступа к этой странице
+			if strings.EqualFold(target, email) && role != auth.RoleAdmin {
+				h.renderRoles(w, r, "You cannot remove your own admin role.")
+				return
+			}
+
+			before := h.Role(target)
+			if err := h.roles.Grant(target, role, email); err != nil {
+				slog.ErrorContext(r.Context(), "failed to set role", "err", err)
+				http.Error(w, "failed to update", http.StatusInternalServerError)
+				return
+			}
+			h.audit(r, storage.AuditEntry{
+				Action: storage.AuditRoleChange,
+				Target: target,
+				Before: string(before),
+				After:  string(role),
+			})
+			http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (h *Handler) renderRoles(w http.ResponseWriter, r *http.Request, errMsg string) {
+	assignments, err := h.roles.List()
+	if err != nil {
+		http.Error(w, "failed to list roles", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Assignments": assignments,
+		"Roles":       auth.Roles,
+	}
+	if errMsg != "" {
+		data["Error"] = errMsg
+	}
+	h.renderTemplate(w, "layout", h.viewData(r, "Roles", "roles", data))
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/ratelimit"
+)
+
+// DefaultCSP - политика по умолчанию. Шаблоны не используют inline-скрипты и стили;
+// если они понадобятся, тег помечается атрибутом nonce="{{.CSPNonce}}".
+const DefaultCSP = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; " +
+	"img-src 'self' data:; object-src 'none'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'"
+
+// Путь, на который браузер отправляет отчеты о нарушениях CSP
+const CSPReportPath = "/csp-report"
+
+const (
+	hstsValue              = "max-age=31536000"
+	permissionsPolicyValue = "camera=(), microphone=(), geolocation=(), payment=(), usb=()"
+	// Отчеты небольшие; все, что длиннее, обрезается
+	maxCSPReportBody  = 64 << 10
+	maxCSPReportField = 256
+)
+
+type SecurityConfig struct {
+	// CSP - текст политики; "{nonce}" заменяется nonce запроса. Пустая строка - DefaultCSP.
+	CSP string
+	// ReportOnly отправляет политику в Content-Security-Policy-Report-Only: браузер
+	// сообщает о нарушениях, но ничего не блокирует.
+	ReportOnly bool
+	// HSTS включает Strict-Transport-Security. Имеет смысл только при BASE_URL на https.
+	HSTS bool
+}
+
+type cspNonceKey struct{}
+
+// cspNonce возвращает nonce текущего запроса для inline-скриптов и стилей.
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// SecurityHeaders выставляет заголовки безопасности на все ответы, включая 404.
+// Ответы на запросы с cookie сессии или токеном не кешируются: страница может
+// содержать чужие жалобы, а общий компьютер или прокси сохранит ее.
+func (h *Handler) SecurityHeaders(cfg SecurityConfig) func(http.Handler) http.Handler {
+	policy := cfg.CSP
+	if policy == "" {
+		policy = DefaultCSP
+	}
+	policy = strings.TrimRight(strings.TrimSpace(policy), ";") +
+		"; report-uri " + CSPReportPath + "; report-to csp"
+	cspHeader := "Content-Security-Policy"
+	if cfg.ReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce := newCSPNonce()
+			hdr := w.Header()
+			hdr.Set(cspHeader, strings.ReplaceAll(policy, "{nonce}", nonce))
+			hdr.Set("Reporting-Endpoints", `csp="`+CSPReportPath+`"`)
+			hdr.Set("X-Frame-Options", "DENY")
+			hdr.Set("X-Content-Type-Options", "nosniff")
+			hdr.Set("Referrer-Policy", "same-origin")
+			hdr.Set("Permissions-Policy", permissionsPolicyValue)
+			if cfg.HSTS {
+				hdr.Set("Strict-Transport-Security", hstsValue)
+			}
+			if (auth.HasSessionCookie(r) || hasBearer(r)) && !strings.HasPrefix(r.URL.Path, "/static/") {
+				hdr.Set("Cache-Control", "no-store")
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce)))
+		})
+	}
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// cspViolation - общие поля отчета в старом формате report-uri и в Reporting API.
+type cspViolation struct {
+	Document    string
+	Blocked     string
+	Directive   string
+	Disposition string
+	Source      string
+	Line        int
+	Sample      string
+}
+
+// application/csp-report: {"csp-report": {...}}
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// application/reports+json: массив отчетов, нужны только csp-violation.
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+// HandleCSPReport принимает отчеты о нарушениях CSP и пишет их в лог. Отчеты шлет
+// браузер без CSRF-токена и часто без сессии, поэтому маршрут открыт и ограничен
+// только rate limiter.
+func (h *Handler) HandleCSPReport() http.HandlerFunc {
+	return h.rateLimiter.Middleware(ratelimit.PolicyCSPReport, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxCSPReportBody))
+		if err != nil {
+			http.Error(w, "invalid report", http.StatusBadRequest)
+			return
+		}
+		violations, err := parseCSPReport(r.Header.Get("Content-Type"), body)
+		if err != nil {
+			http.Error(w, "invalid report", http.StatusBadRequest)
+			return
+		}
+		for _, v := range violations {
+			slog.WarnContext(r.Context(), "csp violation",
+				"document", clip(v.Document),
+				"blocked", clip(v.Blocked),
+				"directive", clip(v.Directive),
+				"disposition", clip(v.Disposition),
+				"source", clip(v.Source),
+				"line", v.Line,
+				"sample", clip(v.Sample),
+				"ip", h.rateLimiter.GetIP(r))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func parseCSPReport(contentType string, body []byte) ([]cspViolation, error) {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	if mt == "application/reports+json" {
+		var reports []reportingAPIReport
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, err
+		}
+		var out []cspViolation
+		for _, rep := range reports {
+			if rep.Type != "csp-violation" {
+				continue
+			}
+			b := rep.Body
+			out = append(out, cspViolation{
+				Document: b.DocumentURL, Blocked: b.BlockedURL, Directive: b.EffectiveDirective,
+				Disposition: b.Disposition, Source: b.SourceFile, Line: b.LineNumber, Sample: b.Sample,
+			})
+		}
+		return out, nil
+	}
+
+	var rep legacyCSPReport
+	if err := json.Unmarshal(body, &rep); err != nil {
+		return nil, err
+	}
+	b := rep.Report
+	directive := b.EffectiveDirective
+	if directive == "" {
+		directive = b.ViolatedDirective
+	}
+	return []cspViolation{{
+		Document: b.DocumentURI, Blocked: b.BlockedURI, Directive: directive,
+		Disposition: b.Disposition, Source: b.SourceFile, Line: b.LineNumber, Sample: b.ScriptSample,
+	}}, nil
+}
+
+// clip обрезает поле отчета: его присылает браузер, и длина ничем не ограничена.
+func clip(s string) string {
+	if len(s) <= maxCSPReportField {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxCSPReportField], "") + "..."
+}
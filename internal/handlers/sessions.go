@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/storage"
+)
+
+// HandleSessions показывает активные сессии пользователя и позволяет завершить чужие устройства.
+func (h *Handler) HandleSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, _ := h.authManager.CurrentSession(r)
+
+		switch r.Method {
+		case http.MethodGet:
+			sessions, err := h.authManager.ListSessions(current.Email)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to list sessions", "err", err)
+				http.Error(w, "failed to list sessions", http.StatusInternalServerError)
+				return
+			}
+			h.renderTemplate(w, "layout", h.viewData(r, "Active Sessions", "sessions", map[string]any{
+				"Sessions":  sessions,
+				"CurrentID": current.ID,
+			}))
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, "invalid form", http.StatusBadRequest)
+				return
+			}
+
+			var err error
+			if id := r.FormValue("id"); id != "" {
+				if id == current.ID {
+					http.Error(w, "use logout to end the current session", http.StatusBadRequest)
+					return
+				}
+				err = h.authManager.RevokeSession(current.Email, id)
+			} else {
+				err = h.authManager.RevokeOtherSessions(current.Email, current.ID)
+			}
+			if errors.Is(err, auth.ErrSessionNotFound) {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to revoke session", "err", err)
+				http.Error(w, "failed to revoke session", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// HandleForceLogout завершает все сессии указанного пользователя.
+func (h *Handler) HandleForceLogout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+		target := strings.TrimSpace(r.FormValue("email"))
+		if target == "" {
+			http.Error(w, "email required", http.StatusBadRequest)
+			return
+		}
+		if err := h.authManager.RevokeAllSessions(target); err != nil {
+			slog.ErrorContext(r.Context(), "failed to force logout", "err", err)
+			http.Error(w, "failed to revoke sessions", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, storage.AuditEntry{Action: storage.AuditSessionsRevoke, Target: target})
+		http.Redirect(w, r, "/admin/roles", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"donos-hrm/internal/auth"
+	"donos-hrm/internal/storage"
+)
+
+// Сроки действия токена, доступные в форме; 0 - бессрочный.
+var tokenExpiryDays = []int{30, 90, 365, 0}
+
+// HandleTokens - страница персональных API-токенов: выпуск, список и отзыв.
+func (h *Handler) HandleTokens() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email := principalFrom(r).Email
+
+		switch r.Method {
+		case http.MethodGet:
+			h.renderTokens(w, r, nil)
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, "invalid form", http.StatusBadRequest)
+				return
+			}
+
+			// Отзыв: форма с id токена
+			if id := r.FormValue("revoke"); id != "" {
+				err := h.authManager.RevokeToken(email, id)
+				if errors.Is(err, auth.ErrTokenNotFound) {
+					http.Error(w, "token not found", http.StatusNotFound)
+					return
+				}
+				if err != nil {
+					slog.ErrorContext(r.Context(), "failed to revoke token", "err", err)
+					http.Error(w, "failed to revoke token", http.StatusInternalServerError)
+					return
+				}
+				h.audit(r, storage.AuditEntry{Action: storage.AuditTokenRevoke, Target: id})
+				http.Redirect(w, r, "/settings/tokens", http.StatusSeeOther)
+				return
+			}
+
+			name := strings.TrimSpace(r.FormValue("name"))
+			if name == "" {
+				h.renderTokens(w, r, map[string]any{"Error": "Token name is required."})
+				return
+			}
+			role := h.Role(email)
+			var scopes []auth.Scope
+			for _, v := range r.Form["scope"] {
+				scope, ok := auth.ParseScope(v)
+				if !ok || !role.AtLeast(scope.MinRole()) {
+					h.renderTokens(w, r, map[string]any{"Error": "Scope " + v + " is not available to you."})
+					return
+				}
+				scopes = append(scopes, scope)
+			}
+			if len(scopes) == 0 {
+				h.renderTokens(w, r, map[string]any{"Error": "Select at least one scope."})
+				return
+			}
+			days, err := strconv.Atoi(r.FormValue("expires"))
+			if err != nil || days < 0 {
+				h.renderTokens(w, r, map[string]any{"Error": "Invalid expiry."})
+				return
+			}
+
+			raw, token, err := h.authManager.CreateToken(email, name, scopes, time.Duration(days)*24*time.Hour)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to create token", "err", err)
+				http.Error(w, "failed to create token", http.StatusInternalServerError)
+				return
+			}
+			h.audit(r, storage.AuditEntry{
+				Action: storage.AuditTokenCreate,
+				Target: token.ID,
+				After:  name + " (" + strings.Join(scopeNames(scopes), ", ") + ")",
+			})
+			// Открытое значение показывается один раз, прямо в ответе на POST
+			w.Header().Set("Cache-Control", "no-store")
+			h.renderTokens(w, r, map[string]any{"NewToken": raw, "NewTokenName": name})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (h *Handler) renderTokens(w http.ResponseWriter, r *http.Request, extra map[string]any) {
+	email := principalFrom(r).Email
+	tokens, err := h.authManager.ListTokens(email)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list tokens", "err", err)
+		http.Error(w, "failed to list tokens", http.StatusInternalServerError)
+		return
+	}
+
+	// В форме только те scope, которые роль пользователя позволяет использовать
+	role := h.Role(email)
+	var scopes []auth.Scope
+	for _, s := range auth.Scopes {
+		if role.AtLeast(s.MinRole()) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	data := map[string]any{
+		"Tokens":     tokens,
+		"Scopes":     scopes,
+		"ExpiryDays": tokenExpiryDays,
+		"Now":        time.Now(),
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	h.renderTemplate(w, "layout", h.viewData(r, "API Tokens", "tokens", data))
+}
+
+func scopeNames(scopes []auth.Scope) []string {
+	names := make([]string, len(scopes))
+	for i, s := range scopes {
+		names[i] = string(s)
+	}
+	return names
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"donos-hrm/internal/auth"
+)
+
+// Токен дает доступ, только если у него есть scope маршрута и роль владельца этот
+// scope допускает.
+func TestTokenScopes(t *testing.T) {
+	e := newTestEnv(t)
+	e.roles.Grant("mod@example.com", auth.RoleModerator, "test")
+	e.roles.Grant("demoted@example.com", auth.RoleModerator, "test")
+
+	token := func(email string, scopes ...auth.Scope) string {
+		t.Helper()
+		raw, _, err := e.authManager.CreateToken(email, "test", scopes, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	reporterAll := token("user@example.com", auth.Scopes...)
+	reporterRead := token("user@example.com", auth.ScopeReadOwn)
+	modRead := token("mod@example.com", auth.ScopeReadOwn, auth.ScopeAdminRead)
+	modWrite := token("mod@example.com", auth.ScopeAdminWrite)
+	demoted := token("demoted@example.com", auth.ScopeAdminRead, auth.ScopeAdminWrite)
+	e.roles.Grant("demoted@example.com", auth.RoleReporter, "test")
+
+	tests := []struct {
+		name   string
+		token  string
+		role   auth.Role
+		method string
+		want   int
+	}{
+		{"read own", reporterRead, auth.RoleReporter, http.MethodGet, http.StatusOK},
+		{"submit without scope", reporterRead, auth.RoleReporter, http.MethodPost, http.StatusForbidden},
+		{"submit", reporterAll, auth.RoleReporter, http.MethodPost, http.StatusOK},
+		{"admin scopes do not raise a reporter", reporterAll, auth.RoleReviewer, http.MethodGet, http.StatusForbidden},
+		{"admin read", modRead, auth.RoleReviewer, http.MethodGet, http.StatusOK},
+		{"admin write without scope", modRead, auth.RoleModerator, http.MethodPatch, http.StatusForbidden},
+		{"admin write", modWrite, auth.RoleModerator, http.MethodPatch, http.StatusOK},
+		{"admin read without scope", modWrite, auth.RoleReviewer, http.MethodGet, http.StatusForbidden},
+		{"role above the owner's", modWrite, auth.RoleAdmin, http.MethodPost, http.StatusForbidden},
+		{"owner demoted after issue", demoted, auth.RoleReviewer, http.MethodGet, http.StatusForbidden},
+		{"unknown token", "dhrm_unknown", auth.RoleReporter, http.MethodGet, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/test", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			e.APIRequire(tt.role, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})(rec, r)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+// Отозванный токен - отказ, даже если в запросе есть рабочая cookie.
+func TestRevokedTokenDoesNotFallBackToSession(t *testing.T) {
+	e := newTestEnv(t)
+	cookie, _ := e.signIn(t, "user@example.com")
+	raw, tok, err := e.authManager.CreateToken("user@example.com", "test", []auth.Scope{auth.ScopeReadOwn}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/test", nil)
+		r.Header.Set("Authorization", "Bearer "+raw)
+		r.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		e.APIRequire(auth.RoleReporter, func(w http.ResponseWriter, r *http.Request) {})(rec, r)
+		return rec.Code
+	}
+	if got := get(); got != http.StatusOK {
+		t.Fatalf("valid token: status %d", got)
+	}
+	if err := e.authManager.RevokeToken("user@example.com", tok.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); got != http.StatusUnauthorized {
+		t.Fatalf("revoked token: status %d, want 401", got)
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"donos-hrm/internal/storage"
+	"donos-hrm/internal/webhook"
+)
+
+// Сколько последних доставок показывать на странице
+const webhookLogSize = 50
+
+// HandleWebhooks - страница webhooks: подписки, их включение и удаление, журнал доставок
+// и повтор неудачных.
+func (h *Handler) HandleWebhooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.webhooks == nil {
+			http.Error(w, "webhooks are disabled", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			h.renderWebhooks(w, r, nil)
+		case http.MethodPost:
+			if err := r.ParseForm(); err != nil {
+				http.Error(w, "invalid form", http.StatusBadRequest)
+				return
+			}
+			switch r.FormValue("action") {
+			case "create":
+				h.createWebhook(w, r)
+			case "toggle", "delete":
+				h.updateWebhook(w, r)
+			case "replay":
+				h.replayDelivery(w, r)
+			default:
+				http.Error(w, "unknown action", http.StatusBadRequest)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (h *Handler) createWebhook(w http.ResponseWriter, r *http.Request) {
+	rawURL := strings.TrimSpace(r.FormValue("url"))
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		h.renderWebhooks(w, r, map[string]any{"Error": "Enter an absolute http or https URL."})
+		return
+	}
+	var events []string
+	for _, ev := range r.Form["event"] {
+		if !webhook.ValidEvent(ev) {
+			http.Error(w, "unknown event", http.StatusBadRequest)
+			return
+		}
+		events = append(events, ev)
+	}
+	if len(events) == 0 {
+		h.renderWebhooks(w, r, map[string]any{"Error": "Select at least one event."})
+		return
+	}
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to generate webhook secret", "err", err)
+		http.Error(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+
+	e, err := h.webhooks.Store().SaveEndpoint(webhook.Endpoint{
+		URL:       u.String(),
+		Secret:    secret,
+		Events:    events,
+		Active:    true,
+		CreatedBy: principalFrom(r).Email,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to save webhook", "err", err)
+		http.Error(w, "failed to create webhook", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, storage.AuditEntry{
+		Action: storage.AuditWebhookCreate,
+		Target: e.URL,
+		After:  strings.Join(events, ", "),
+	})
+	// Секрет показывается один раз, как и API-токен
+	w.Header().Set("Cache-Control", "no-store")
+	h.renderWebhooks(w, r, map[string]any{"NewSecret": secret, "NewSecretURL": e.URL})
+}
+
+func (h *Handler) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	store := h.webhooks.Store()
+	e, err := store.Endpoint(id)
+	if errors.Is(err, webhook.ErrEndpointNotFound) {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load webhook", "err", err)
+		http.Error(w, "failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+
+	entry := storage.AuditEntry{Target: e.URL}
+	if r.FormValue("action") == "delete" {
+		err = store.DeleteEndpoint(id)
+		entry.Action = storage.AuditWebhookDelete
+	} else {
+		entry.Action = storage.AuditWebhookUpdate
+		entry.Before = webhookState(e.Active)
+		e.Active = !e.Active
+		entry.After = webhookState(e.Active)
+		_, err = store.SaveEndpoint(e)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to update webhook", "err", err)
+		http.Error(w, "failed to update webhook", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, entry)
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+func (h *Handler) replayDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.FormValue("delivery"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid delivery", http.StatusBadRequest)
+		return
+	}
+	d, err := h.webhooks.Replay(id)
+	if errors.Is(err, webhook.ErrDeliveryNotFound) {
+		http.Error(w, "delivery not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to replay delivery", "err", err)
+		http.Error(w, "failed to replay delivery", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, storage.AuditEntry{
+		Action: storage.AuditWebhookReplay,
+		Target: "delivery " + strconv.FormatInt(id, 10),
+		After:  "delivery " + strconv.FormatInt(d.ID, 10),
+	})
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+func webhookState(active bool) string {
+	if active {
+		return "active"
+	}
+	return "disabled"
+}
+
+func (h *Handler) renderWebhooks(w http.ResponseWriter, r *http.Request, extra map[string]any) {
+	store := h.webhooks.Store()
+	endpoints, err := store.Endpoints()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list webhooks", "err", err)
+		http.Error(w, "failed to list webhooks", http.StatusInternalServerError)
+		return
+	}
+	deliveries, err := store.Deliveries(webhookLogSize)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list deliveries", "err", err)
+		http.Error(w, "failed to list deliveries", http.StatusInternalServerError)
+		return
+	}
+	// URL для журнала: у удаленных подписок остается только номер
+	urls := make(map[int64]string, len(endpoints))
+	for _, e := range endpoints {
+		urls[e.ID] = e.URL
+	}
+
+	data := map[string]any{
+		"Endpoints":  endpoints,
+		"Deliveries": deliveries,
+		"URLs":       urls,
+		"Events":     webhook.Events,
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+	h.renderTemplate(w, "layout", h.viewData(r, "Webhooks", "webhooks", data))
+}
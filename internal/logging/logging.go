@@ -0,0 +1,110 @@
+// Package logging настраивает log/slog для приложения: уровень и формат из конфигурации,
+// ID запроса из контекста в каждой записи и маскирование персональных данных.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+type Config struct {
+	Level  string // debug, info (по умолчанию), warn, error
+	Format string // text (по умолчанию) или json
+	// ShowPII отключает маскирование email в логах. Только для отладки.
+	ShowPII bool
+}
+
+var showPII atomic.Bool
+
+// Setup создает логгер по конфигурации и делает его логгером по умолчанию,
+// в том числе для пакета log.
+func Setup(w io.Writer, cfg Config) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("log level %q: %w", cfg.Level, err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("log format %q: want text or json", cfg.Format)
+	}
+
+	showPII.Store(cfg.ShowPII)
+	logger := slog.New(contextHandler{h})
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID возвращает ID запроса из контекста или пустую строку.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler добавляет request_id к записям, сделанным с контекстом запроса
+// (slog.InfoContext и т.п.).
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Email - атрибут с адресом, замаскированным до вида "j***@example.com".
+// Псевдонимы анонимных авторов и прочие строки без "@" выводятся как есть.
+func Email(key, email string) slog.Attr {
+	return slog.Any(key, redactedEmail(email))
+}
+
+type redactedEmail string
+
+func (e redactedEmail) LogValue() slog.Value {
+	return slog.StringValue(MaskEmail(string(e)))
+}
+
+// MaskEmail маскирует локальную часть адреса, если не включен показ персональных данных.
+func MaskEmail(email string) string {
+	if showPII.Load() {
+		return email
+	}
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return email
+	}
+	if local == "" {
+		return "***@" + domain
+	}
+	_, size := utf8.DecodeRuneInString(local)
+	return local[:size] + "***@" + domain
+}
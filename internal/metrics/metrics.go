@@ -0,0 +1,294 @@
+// Package metrics - минимальные счетчики, гистограммы и gauge в текстовом формате
+// Prometheus. Метрики регистрируются в Default при инициализации пакетов, которые
+// их используют, и отдаются обработчиком /metrics.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefBuckets - границы гистограмм по умолчанию, в секундах.
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry хранит метрики в порядке регистрации.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register паникует на повторном имени: это ошибка программы, а не данных.
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name()] {
+		panic("metrics: duplicate metric " + m.name())
+	}
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText пишет все метрики в формате Prometheus text exposition 0.0.4.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// ContentType - заголовок ответа для WriteText.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// family - общая часть метрик с метками: имя, описание и значения по набору меток.
+type family[T any] struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+	mu         sync.Mutex
+	series     map[string]*T
+	newSeries  func() *T
+}
+
+func (f *family[T]) name() string { return f.metricName }
+
+func (f *family[T]) get(values []string) *T {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.metricName, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = f.newSeries()
+		f.series[key] = s
+	}
+	return s
+}
+
+// each обходит серии в отсортированном порядке, чтобы вывод был стабильным.
+func (f *family[T]) each(fn func(labels string, s *T)) {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	series := make(map[string]*T, len(f.series))
+	for k, v := range f.series {
+		series[k] = v
+	}
+	f.mu.Unlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		var values []string
+		if len(f.labels) > 0 {
+			values = strings.Split(k, "\xff")
+		}
+		fn(formatLabels(f.labels, values), series[k])
+	}
+}
+
+func (f *family[T]) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.metricName, escapeHelp(f.help), f.metricName, f.kind)
+}
+
+// CounterVec - монотонный счетчик с метками.
+type CounterVec struct {
+	family[counter]
+}
+
+type counter struct {
+	mu sync.Mutex
+	v  float64
+}
+
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{family[counter]{
+		metricName: name, help: help, kind: "counter", labels: labels,
+		series: make(map[string]*counter), newSeries: func() *counter { return &counter{} },
+	}}
+	if len(labels) == 0 {
+		c.get(nil) // без меток серия одна, и она видна сразу с нулем
+	}
+	Default.register(c)
+	return c
+}
+
+// Inc увеличивает счетчик для значений меток в порядке их объявления.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(delta float64, values ...string) {
+	s := c.get(values)
+	s.mu.Lock()
+	s.v += delta
+	s.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w)
+	c.each(func(labels string, s *counter) {
+		s.mu.Lock()
+		v := s.v
+		s.mu.Unlock()
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, labels, formatFloat(v))
+	})
+}
+
+// HistogramVec считает распределение длительностей по корзинам.
+type HistogramVec struct {
+	family[histogram]
+	buckets []float64
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64 // по корзинам, не накопительно
+	count  uint64
+	sum    float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	h := &HistogramVec{buckets: buckets}
+	h.family = family[histogram]{
+		metricName: name, help: help, kind: "histogram", labels: labels,
+		series:    make(map[string]*histogram),
+		newSeries: func() *histogram { return &histogram{counts: make([]uint64, len(buckets))} },
+	}
+	if len(labels) == 0 {
+		h.get(nil)
+	}
+	Default.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	s := h.get(values)
+	i := sort.SearchFloat64s(h.buckets, v)
+	s.mu.Lock()
+	if i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+	s.mu.Unlock()
+}
+
+// ObserveSince записывает время, прошедшее с start, в секундах.
+func (h *HistogramVec) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w)
+	h.each(func(labels string, s *histogram) {
+		s.mu.Lock()
+		counts := append([]uint64(nil), s.counts...)
+		count, sum := s.count, s.sum
+		s.mu.Unlock()
+
+		var cumulative uint64
+		for i, b := range h.buckets {
+			cumulative += counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, withLabel(labels, "le", formatFloat(b)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, withLabel(labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labels, formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labels, count)
+	})
+}
+
+// GaugeFunc читает значение при каждом запросе /metrics.
+type GaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() (float64, error)
+}
+
+// NewGaugeFunc регистрирует gauge; если fn вернула ошибку, значение не выводится.
+func NewGaugeFunc(name, help string, fn func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, fn: fn}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.metricName }
+
+func (g *GaugeFunc) write(w io.Writer) {
+	v, err := g.fn()
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.metricName, escapeHelp(g.help), g.metricName, g.metricName, formatFloat(v))
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// withLabel добавляет метку к уже отформатированному набору.
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,253 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"donos-hrm/internal/storage"
+)
+
+// Имена шаблонов писем (templates/email)
+const (
+	tmplComplaintCreated = "complaint_created"
+	tmplStatusChanged    = "status_changed"
+	tmplReplyAdded       = "reply_added"
+)
+
+const (
+	defaultPollInt     = 30 * time.Second
+	defaultMaxAttempts = 8
+	// Первая повторная попытка через минуту, дальше интервал удваивается до maxBackoff
+	baseBackoff = time.Minute
+	maxBackoff  = 6 * time.Hour
+	// Сколько писем отправлять за один проход
+	batchSize = 20
+)
+
+type Config struct {
+	// Context останавливает отправку при его отмене; по умолчанию - context.Background()
+	Context     context.Context
+	Queue       Queue
+	Sender      Sender
+	Templates   *template.Template
+	HRMailbox   []string // куда писать о новых жалобах
+	BaseURL     string   // для ссылок в письмах
+	PollInt     time.Duration
+	MaxAttempts int
+}
+
+// Notifier ставит письма в очередь и отправляет их в фоне, чтобы недоступный
+// почтовый сервер не задерживал запросы. Методы событий можно вызывать на nil:
+// так выглядит выключенная почта.
+type Notifier struct {
+	queue       Queue
+	sender      Sender
+	tmpl        *template.Template
+	hrMailbox   []string
+	baseURL     string
+	maxAttempts int
+	poll        *time.Ticker
+	wake        chan struct{}
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+func New(cfg Config) *Notifier {
+	if cfg.PollInt <= 0 {
+		cfg.PollInt = defaultPollInt
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.Context == nil {
+		cfg.Context = context.Background()
+	}
+	ctx, cancel := context.WithCancel(cfg.Context)
+	n := &Notifier{
+		queue:       cfg.Queue,
+		sender:      cfg.Sender,
+		tmpl:        cfg.Templates,
+		hrMailbox:   cfg.HRMailbox,
+		baseURL:     strings.TrimRight(cfg.BaseURL, "/"),
+		maxAttempts: cfg.MaxAttempts,
+		poll:        time.NewTicker(cfg.PollInt),
+		wake:        make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	go n.loop()
+	return n
+}
+
+// Stop останавливает отправку и ждет окончания текущего прохода. Неотправленные
+// письма остаются в очереди до следующего запуска.
+func (n *Notifier) Stop() {
+	if n == nil {
+		return
+	}
+	n.poll.Stop()
+	n.cancel()
+	<-n.done
+}
+
+func (n *Notifier) loop() {
+	defer close(n.done)
+	n.deliver()
+	for {
+		select {
+		case <-n.poll.C:
+		case <-n.wake:
+		case <-n.ctx.Done():
+			return
+		}
+		n.deliver()
+	}
+}
+
+// deliver отправляет все письма, время которых наступило. Если очередь не удалось
+// обновить, следующая пачка вернула бы те же письма, поэтому проход заканчивается до
+// следующего опроса.
+func (n *Notifier) deliver() {
+	for {
+		jobs, err := n.queue.Due(time.Now(), batchSize)
+		if err != nil {
+			slog.Error("mail: failed to read queue", "err", err)
+			return
+		}
+		for _, j := range jobs {
+			if n.ctx.Err() != nil || !n.send(j) {
+				return
+			}
+		}
+		if len(jobs) < batchSize {
+			return
+		}
+	}
+}
+
+// send отправляет письмо и убирает его из очереди или откладывает. Возвращает false,
+// если очередь обновить не удалось.
+func (n *Notifier) send(j Job) bool {
+	err := n.sender.Send(j.Message)
+	if err == nil {
+		if err := n.queue.Remove(j.ID); err != nil {
+			slog.Error("mail: sent job but failed to remove it", "job", j.ID, "err", err)
+			return false
+		}
+		return true
+	}
+
+	attempts := j.Attempts + 1
+	if attempts >= n.maxAttempts {
+		slog.Warn("mail: giving up on job", "job", j.ID, "recipients", len(j.Message.To), "attempts", attempts, "err", err)
+		if err := n.queue.Remove(j.ID); err != nil {
+			slog.Error("mail: failed to remove job", "job", j.ID, "err", err)
+			return false
+		}
+		return true
+	}
+	next := time.Now().Add(backoff(attempts))
+	slog.Warn("mail: job failed, will retry", "job", j.ID, "attempt", attempts, "next_attempt", next.Format(time.RFC3339), "err", err)
+	if err := n.queue.Retry(j.ID, next, err.Error()); err != nil {
+		slog.Error("mail: failed to reschedule job", "job", j.ID, "err", err)
+		return false
+	}
+	return true
+}
+
+// backoff - пауза перед попыткой номер attempts+1.
+func backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// ComplaintCreated сообщает HR о новой жалобе. Текст жалобы в письмо не попадает,
+// только тема и ссылка.
+func (n *Notifier) ComplaintCreated(c storage.Complaint) {
+	if n == nil || len(n.hrMailbox) == 0 {
+		return
+	}
+	n.enqueue(tmplComplaintCreated, n.hrMailbox, map[string]any{
+		"Complaint": mailComplaint(c),
+		"URL":       n.complaintURL(c),
+	})
+}
+
+// StatusChanged сообщает автору о смене статуса. Анонимным авторам писать некуда.
+func (n *Notifier) StatusChanged(c storage.Complaint, from storage.Status, note string) {
+	if n == nil || c.Anonymous {
+		return
+	}
+	n.enqueue(tmplStatusChanged, []string{c.Reporter}, map[string]any{
+		"Complaint": mailComplaint(c),
+		"From":      from,
+		"Note":      note,
+		"URL":       n.complaintURL(c),
+	})
+}
+
+// ReplyAdded сообщает автору об ответе HR. Внутренние заметки и сообщения самого
+// автора писем не вызывают.
+func (n *Notifier) ReplyAdded(c storage.Complaint, comment storage.Comment) {
+	if n == nil || c.Anonymous || comment.Internal || comment.FromReporter {
+		return
+	}
+	n.enqueue(tmplReplyAdded, []string{c.Reporter}, map[string]any{
+		"Complaint": mailComplaint(c),
+		"Comment":   comment,
+		"URL":       n.complaintURL(c) + "#comments",
+	})
+}
+
+// mailComplaint - жалоба для шаблонов с темой в одну строку: шаблоны вставляют тему в
+// строку Subject, и пустая строка внутри темы отрезала бы ее остаток в тело письма.
+func mailComplaint(c storage.Complaint) storage.Complaint {
+	c.Subject = headerSafe(c.Subject)
+	return c
+}
+
+func (n *Notifier) complaintURL(c storage.Complaint) string {
+	return n.baseURL + "/complaints/" + strconv.Itoa(c.ID)
+}
+
+// enqueue рендерит шаблон и ставит письмо в очередь. Первая строка шаблона -
+// "Subject: ...", после пустой строки идет тело.
+func (n *Notifier) enqueue(name string, to []string, data map[string]any) {
+	m, err := n.render(name, to, data)
+	if err != nil {
+		slog.Error("mail: failed to render", "template", name, "err", err)
+		return
+	}
+	if _, err := n.queue.Push(m); err != nil {
+		slog.Error("mail: failed to queue", "template", name, "recipients", len(to), "err", err)
+		return
+	}
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Notifier) render(name string, to []string, data map[string]any) (Message, error) {
+	var buf bytes.Buffer
+	if err := n.tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return Message{}, err
+	}
+	head, body, ok := strings.Cut(strings.TrimLeft(buf.String(), "\n"), "\n\n")
+	subject, found := strings.CutPrefix(head, "Subject: ")
+	if !ok || !found {
+		return Message{}, fmt.Errorf("template %s must start with a Subject line followed by a blank line", name)
+	}
+	return Message{To: to, Subject: subject, Body: strings.TrimSpace(body) + "\n"}, nil
+}
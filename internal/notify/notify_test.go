@@ -0,0 +1,321 @@
+package notify
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"donos-hrm/internal/storage"
+)
+
+// smtpServer - SMTP-сервер для тестов: принимает письма и складывает их в received.
+// С reject отвечает на MAIL FROM временной ошибкой, как перегруженный сервер.
+type smtpServer struct {
+	ln       net.Listener
+	reject   atomic.Bool
+	received chan received
+}
+
+type received struct {
+	from string
+	to   []string
+	data string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln, received: make(chan received, 16)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP test")
+	var msg received
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250 localhost")
+		case "MAIL":
+			if s.reject.Load() {
+				tp.PrintfLine("451 4.3.0 try again later")
+				continue
+			}
+			msg = received{from: smtpPath(arg)}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, smtpPath(arg))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 end with <CRLF>.<CRLF>")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.received <- msg
+			tp.PrintfLine("250 OK")
+		case "RSET", "NOOP":
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// smtpPath достает адрес из "FROM:<a@b>" или "TO:<a@b>".
+func smtpPath(arg string) string {
+	_, path, _ := strings.Cut(arg, ":")
+	return strings.Trim(strings.TrimSpace(path), "<>")
+}
+
+// wait ждет следующее письмо.
+func (s *smtpServer) wait(t *testing.T) received {
+	t.Helper()
+	select {
+	case m := <-s.received:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+		return received{}
+	}
+}
+
+func newTestNotifier(t *testing.T, addr string, queue Queue) *Notifier {
+	t.Helper()
+	sender, err := NewSMTPSender(SMTPConfig{Addr: addr, From: "HR Bot <hr-bot@example.com>", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := template.ParseGlob(filepath.Join("..", "..", "templates", "email", "*.gotxt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(Config{
+		Queue:     queue,
+		Sender:    sender,
+		Templates: tmpl,
+		HRMailbox: []string{"hr@example.com"},
+		BaseURL:   "https://hr.example.com/",
+		PollInt:   time.Hour,
+	})
+}
+
+func queued(t *testing.T, q Queue) []Job {
+	t.Helper()
+	jobs, err := q.Due(time.Now().Add(24*time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jobs
+}
+
+func TestComplaintCreatedReachesHR(t *testing.T) {
+	srv := newSMTPServer(t)
+	queue := NewMemoryQueue()
+	n := newTestNotifier(t, srv.addr(), queue)
+	defer n.Stop()
+
+	n.ComplaintCreated(storage.Complaint{ID: 7, Reporter: "user@example.com", Subject: "Broken chair", Description: "secret details", CreatedAt: time.Now()})
+
+	m := srv.wait(t)
+	if m.from != "hr-bot@example.com" || strings.Join(m.to, ",") != "hr@example.com" {
+		t.Fatalf("email from %q to %v, want from hr-bot@example.com to hr@example.com", m.from, m.to)
+	}
+	for _, want := range []string{"Subject: New complaint #7: Broken chair", "https://hr.example.com/complaints/7"} {
+		if !strings.Contains(m.data, want) {
+			t.Errorf("email does not contain %q:\n%s", want, m.data)
+		}
+	}
+	if strings.Contains(m.data, "secret details") {
+		t.Error("email contains the complaint description")
+	}
+}
+
+// Тема из API может содержать переводы строк; письмо все равно получает ее целиком
+// в заголовке Subject.
+func TestMultilineSubject(t *testing.T) {
+	srv := newSMTPServer(t)
+	n := newTestNotifier(t, srv.addr(), NewMemoryQueue())
+	defer n.Stop()
+
+	n.StatusChanged(storage.Complaint{ID: 7, Reporter: "user@example.com", Subject: "Broken\r\n\r\nchair\n\nBcc: x@example.com", Status: storage.StatusTriaged}, storage.StatusNew, "")
+
+	m := srv.wait(t)
+	want := `Subject: Your complaint "Broken chair Bcc: x@example.com" is now`
+	if !strings.Contains(m.data, want) {
+		t.Fatalf("email does not contain %q:\n%s", want, m.data)
+	}
+	if strings.Contains(m.data, "\r\nBcc:") || len(m.to) != 1 {
+		t.Fatalf("subject leaked into headers: to %v\n%s", m.to, m.data)
+	}
+}
+
+func TestAnonymousReporterIsNeverEmailed(t *testing.T) {
+	srv := newSMTPServer(t)
+	queue := NewMemoryQueue()
+	n := newTestNotifier(t, srv.addr(), queue)
+
+	c := storage.Complaint{ID: 3, Anonymous: true, Reporter: "anon-0123456789abcdef0123", Subject: "s", Status: storage.StatusTriaged, CreatedAt: time.Now()}
+	n.StatusChanged(c, storage.StatusNew, "looking into it")
+	n.ReplyAdded(c, storage.Comment{ComplaintID: c.ID, Author: "hr@example.com", Body: "any details?"})
+	n.ComplaintCreated(c)
+
+	// Письмо HR о новой жалобе уходит, автору - ничего
+	if m := srv.wait(t); strings.Join(m.to, ",") != "hr@example.com" {
+		t.Fatalf("email to %v, want hr@example.com", m.to)
+	}
+	n.Stop()
+	select {
+	case m := <-srv.received:
+		t.Fatalf("unexpected email to %v", m.to)
+	default:
+	}
+	if jobs := queued(t, queue); len(jobs) != 0 {
+		t.Fatalf("queue still has %d jobs, first to %v", len(jobs), jobs[0].Message.To)
+	}
+}
+
+func TestFailingServerKeepsJobQueued(t *testing.T) {
+	srv := newSMTPServer(t)
+	srv.reject.Store(true)
+	queue := NewMemoryQueue()
+	n := newTestNotifier(t, srv.addr(), queue)
+
+	start := time.Now()
+	n.ComplaintCreated(storage.Complaint{ID: 1, Reporter: "user@example.com", Subject: "s", CreatedAt: time.Now()})
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("ComplaintCreated took %s, want it to only queue the email", d)
+	}
+
+	var job Job
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if jobs := queued(t, queue); len(jobs) == 1 && jobs[0].Attempts == 1 {
+			job = jobs[0]
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first attempt was not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	n.Stop()
+	if !strings.Contains(job.LastError, "451") {
+		t.Errorf("LastError = %q, want the server reply", job.LastError)
+	}
+	if d := time.Until(job.NextAttempt); d < 50*time.Second || d > baseBackoff {
+		t.Errorf("next attempt in %s, want about %s", d, baseBackoff)
+	}
+
+	// Каждая следующая неудача откладывает письмо вдвое дальше
+	prevDelay := time.Until(job.NextAttempt)
+	for attempt := 2; attempt <= 4; attempt++ {
+		n.send(job)
+		jobs := queued(t, queue)
+		if len(jobs) != 1 || jobs[0].Attempts != attempt {
+			t.Fatalf("after attempt %d queue is %+v", attempt, jobs)
+		}
+		delay := time.Until(jobs[0].NextAttempt)
+		if delay < prevDelay*3/2 {
+			t.Fatalf("attempt %d: next in %s, previous was %s", attempt, delay, prevDelay)
+		}
+		job, prevDelay = jobs[0], delay
+	}
+
+	// Сервер ожил: письмо уходит и пропадает из очереди
+	srv.reject.Store(false)
+	n.send(job)
+	if m := srv.wait(t); strings.Join(m.to, ",") != "hr@example.com" {
+		t.Fatalf("email to %v", m.to)
+	}
+	if jobs := queued(t, queue); len(jobs) != 0 {
+		t.Fatalf("delivered job is still queued: %+v", jobs)
+	}
+}
+
+// stuckQueue - очередь, которая отдает задания, но не может их удалить или отложить,
+// как на переполненном диске.
+type stuckQueue struct {
+	Queue
+	dueCalls atomic.Int32
+}
+
+func (q *stuckQueue) Due(now time.Time, limit int) ([]Job, error) {
+	jobs, err := q.Queue.Due(now, limit)
+	q.dueCalls.Add(1)
+	return jobs, err
+}
+
+func (q *stuckQueue) Remove(int64) error                   { return errors.New("disk full") }
+func (q *stuckQueue) Retry(int64, time.Time, string) error { return errors.New("disk full") }
+
+// Письма, которые не удалось убрать из очереди, не отправляются по кругу.
+func TestStuckQueueStopsDelivery(t *testing.T) {
+	srv := newSMTPServer(t)
+	queue := &stuckQueue{Queue: NewMemoryQueue()}
+	n := newTestNotifier(t, srv.addr(), queue)
+	defer n.Stop()
+	// Ждем первый проход фонового цикла по пустой очереди, дальше он спит до опроса
+	for queue.dueCalls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for range batchSize + 5 {
+		if _, err := queue.Push(Message{To: []string{"hr@example.com"}, Subject: "s", Body: "b\n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queue.dueCalls.Store(0)
+
+	done := make(chan struct{})
+	go func() {
+		n.deliver()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliver keeps resending jobs it cannot remove")
+	}
+	if calls := queue.dueCalls.Load(); calls != 1 {
+		t.Errorf("queue read %d times, want 1", calls)
+	}
+	srv.wait(t)
+	select {
+	case <-srv.received:
+		t.Fatal("more than one email sent in a pass that could not update the queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
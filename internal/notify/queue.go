@@ -0,0 +1,291 @@
+package notify
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrJobNotFound = errors.New("mail job not found")
+
+// Message - одно письмо в виде простого текста.
+type Message struct {
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+}
+
+// Job - письмо в очереди вместе с состоянием повторных попыток.
+type Job struct {
+	ID          int64     `json:"id"`
+	Message     Message   `json:"message"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Queue - очередь исходящих писем, переживающая перезапуск.
+type Queue interface {
+	Push(m Message) (Job, error)
+	// Due возвращает до limit заданий, время попытки которых наступило, от старых к новым.
+	Due(now time.Time, limit int) ([]Job, error)
+	// Retry записывает неудачную попытку и время следующей.
+	Retry(id int64, next time.Time, lastErr string) error
+	// Remove удаляет отправленное или безнадежное задание.
+	Remove(id int64) error
+}
+
+func dueJobs(jobs []Job, now time.Time, limit int) []Job {
+	var result []Job
+	for _, j := range jobs {
+		if j.NextAttempt.After(now) {
+			continue
+		}
+		result = append(result, j)
+		if limit > 0 && len(result) == limit {
+			break
+		}
+	}
+	return result
+}
+
+func newJob(id int64, m Message) Job {
+	now := time.Now()
+	return Job{ID: id, Message: m, NextAttempt: now, CreatedAt: now}
+}
+
+type MemoryQueue struct {
+	mu     sync.Mutex
+	jobs   []Job
+	nextID int64
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{nextID: 1}
+}
+
+func (q *MemoryQueue) Push(m Message) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := newJob(q.nextID, m)
+	q.nextID++
+	q.jobs = append(q.jobs, j)
+	return j, nil
+}
+
+func (q *MemoryQueue) Due(now time.Time, limit int) ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return dueJobs(q.jobs, now, limit), nil
+}
+
+func (q *MemoryQueue) Retry(id int64, next time.Time, lastErr string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return retryJob(q.jobs, id, next, lastErr)
+}
+
+func (q *MemoryQueue) Remove(id int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs = removeJob(q.jobs, id)
+	return nil
+}
+
+func retryJob(jobs []Job, id int64, next time.Time, lastErr string) error {
+	for i := range jobs {
+		if jobs[i].ID == id {
+			jobs[i].Attempts++
+			jobs[i].NextAttempt = next
+			jobs[i].LastError = lastErr
+			return nil
+		}
+	}
+	return ErrJobNotFound
+}
+
+func removeJob(jobs []Job, id int64) []Job {
+	for i := range jobs {
+		if jobs[i].ID == id {
+			return append(jobs[:i:i], jobs[i+1:]...)
+		}
+	}
+	return jobs
+}
+
+// FileQueue держит очередь в памяти и переписывает JSON-файл при каждом изменении.
+type FileQueue struct {
+	mu       sync.Mutex
+	filePath string
+	jobs     []Job
+	nextID   int64
+}
+
+func NewFileQueue(filePath string) (*FileQueue, error) {
+	q := &FileQueue{filePath: filePath, nextID: 1}
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &q.jobs); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(q.jobs, func(i, j int) bool { return q.jobs[i].ID < q.jobs[j].ID })
+	for _, j := range q.jobs {
+		if j.ID >= q.nextID {
+			q.nextID = j.ID + 1
+		}
+	}
+	return q, nil
+}
+
+// save вызывается под q.mu.
+func (q *FileQueue) save() error {
+	data, err := json.MarshalIndent(q.jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+	// В письмах адреса и темы жалоб, поэтому файл только для владельца
+	tmpFile := q.filePath + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, q.filePath)
+}
+
+// update применяет изменение к копии очереди и принимает его, только если файл сохранился.
+func (q *FileQueue) update(change func(jobs []Job) ([]Job, error)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	prev := q.jobs
+	jobs, err := change(append([]Job(nil), q.jobs...))
+	if err != nil {
+		return err
+	}
+	q.jobs = jobs
+	if err := q.save(); err != nil {
+		q.jobs = prev
+		return err
+	}
+	return nil
+}
+
+func (q *FileQueue) Push(m Message) (Job, error) {
+	var j Job
+	err := q.update(func(jobs []Job) ([]Job, error) {
+		// При ошибке записи номер просто пропускается
+		j = newJob(q.nextID, m)
+		q.nextID++
+		return append(jobs, j), nil
+	})
+	if err != nil {
+		return Job{}, err
+	}
+	return j, nil
+}
+
+func (q *FileQueue) Due(now time.Time, limit int) ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return dueJobs(q.jobs, now, limit), nil
+}
+
+func (q *FileQueue) Retry(id int64, next time.Time, lastErr string) error {
+	return q.update(func(jobs []Job) ([]Job, error) {
+		return jobs, retryJob(jobs, id, next, lastErr)
+	})
+}
+
+func (q *FileQueue) Remove(id int64) error {
+	return q.update(func(jobs []Job) ([]Job, error) {
+		return removeJob(jobs, id), nil
+	})
+}
+
+// SQLQueue хранит очередь в таблице mail_queue общей базы.
+type SQLQueue struct {
+	db *sql.DB
+}
+
+func NewSQLQueue(db *sql.DB) (*SQLQueue, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS mail_queue (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		recipients   TEXT    NOT NULL,
+		subject      TEXT    NOT NULL,
+		body         TEXT    NOT NULL,
+		attempts     INTEGER NOT NULL DEFAULT 0,
+		next_attempt INTEGER NOT NULL,
+		last_error   TEXT    NOT NULL DEFAULT '',
+		created_at   INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_mail_queue_next ON mail_queue(next_attempt);`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLQueue{db: db}, nil
+}
+
+func (q *SQLQueue) Push(m Message) (Job, error) {
+	j := newJob(0, m)
+	res, err := q.db.Exec(`INSERT INTO mail_queue (recipients, subject, body, next_attempt, created_at) VALUES (?, ?, ?, ?, ?)`,
+		strings.Join(m.To, "\n"), m.Subject, m.Body, j.NextAttempt.UnixNano(), j.CreatedAt.UnixNano())
+	if err != nil {
+		return Job{}, err
+	}
+	j.ID, err = res.LastInsertId()
+	return j, err
+}
+
+func (q *SQLQueue) Due(now time.Time, limit int) ([]Job, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := q.db.Query(`SELECT id, recipients, subject, body, attempts, next_attempt, last_error, created_at
+		FROM mail_queue WHERE next_attempt <= ? ORDER BY id LIMIT ?`, now.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Job
+	for rows.Next() {
+		var (
+			j          Job
+			recipients string
+			next, at   int64
+		)
+		if err := rows.Scan(&j.ID, &recipients, &j.Message.Subject, &j.Message.Body, &j.Attempts, &next, &j.LastError, &at); err != nil {
+			return nil, err
+		}
+		j.Message.To = strings.Split(recipients, "\n")
+		j.NextAttempt = time.Unix(0, next)
+		j.CreatedAt = time.Unix(0, at)
+		result = append(result, j)
+	}
+	return result, rows.Err()
+}
+
+func (q *SQLQueue) Retry(id int64, next time.Time, lastErr string) error {
+	res, err := q.db.Exec(`UPDATE mail_queue SET attempts = attempts + 1, next_attempt = ?, last_error = ? WHERE id = ?`,
+		next.UnixNano(), lastErr, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+func (q *SQLQueue) Remove(id int64) error {
+	_, err := q.db.Exec(`DELETE FROM mail_queue WHERE id = ?`, id)
+	return err
+}
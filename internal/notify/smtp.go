@@ -0,0 +1,137 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Sender отправляет одно письмо. Ошибка означает, что письмо нужно повторить позже.
+type Sender interface {
+	Send(m Message) error
+}
+
+type SMTPConfig struct {
+	Addr     string // host:port
+	Username string // без имени пользователя AUTH не выполняется
+	Password string
+	From     string
+	Timeout  time.Duration // на все письмо целиком; по умолчанию 30 секунд
+}
+
+// SMTPSender отправляет письма через SMTP-сервер. STARTTLS используется, если сервер
+// его предлагает; пароль net/smtp передает только по TLS или на localhost.
+type SMTPSender struct {
+	cfg  SMTPConfig
+	host string
+}
+
+func NewSMTPSender(cfg SMTPConfig) (*SMTPSender, error) {
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("smtp address %q: %w", cfg.Addr, err)
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return nil, fmt.Errorf("smtp sender %q: %w", cfg.From, err)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &SMTPSender{cfg: cfg, host: host}, nil
+}
+
+func (s *SMTPSender) Send(m Message) error {
+	conn, err := net.DialTimeout("tcp", s.cfg.Addr, s.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(s.cfg.Timeout))
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.host)); err != nil {
+			return err
+		}
+	}
+
+	from, _ := mail.ParseAddress(s.cfg.From)
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.format(m)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// format собирает письмо с заголовками. Тело кодируется quoted-printable, чтобы
+// длинные строки и кириллица проходили через любой сервер.
+func (s *SMTPSender) format(m Message) []byte {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", s.cfg.From)
+	header("To", strings.Join(m.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", headerSafe(m.Subject)))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(s.cfg.From))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	header("Auto-Submitted", "auto-generated")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n")))
+	qp.Close()
+	return buf.Bytes()
+}
+
+// headerSafe убирает переводы строк, чтобы тема жалобы не могла добавить свои заголовки.
+func headerSafe(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// messageID строит уникальный Message-ID в домене отправителя.
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		domain = addr.Address[strings.LastIndexByte(addr.Address, '@')+1:]
+	}
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"donos-hrm/internal/metrics"
+)
+
+var bans = metrics.NewCounterVec("donos_ratelimit_banned_total",
+	"Requests rejected by the admin ban list, by key type (ip, email).", "key_type")
+
+// accessList - правила в виде для проверки на каждом запросе. Бан важнее разрешения:
+// заблокированный адрес внутри разрешенной сети остается заблокированным.
+type accessList struct {
+	prefixes []accessPrefix
+	emails   map[string]string
+}
+
+type accessPrefix struct {
+	prefix netip.Prefix
+	action string
+}
+
+func compileAccess(rules []AccessRule) (*accessList, error) {
+	a := &accessList{emails: make(map[string]string)}
+	for _, rule := range rules {
+		if rule.Action != AccessAllow && rule.Action != AccessBan {
+			return nil, fmt.Errorf("access rule %s: unknown action %q", rule.Value, rule.Action)
+		}
+		if strings.Contains(rule.Value, "@") {
+			a.emails[normalizeEmail(rule.Value)] = rule.Action
+			continue
+		}
+		prefix, err := netip.ParsePrefix(rule.Value)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(rule.Value)
+			if aerr != nil {
+				return nil, fmt.Errorf("access rule %s: %w", rule.Value, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		a.prefixes = append(a.prefixes, accessPrefix{prefix: prefix, action: rule.Action})
+	}
+	return a, nil
+}
+
+func (a *accessList) ip(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap().WithZone("")
+	action := ""
+	for _, p := range a.prefixes {
+		if p.prefix.Contains(addr) {
+			if p.action == AccessBan {
+				return AccessBan
+			}
+			action = p.action
+		}
+	}
+	return action
+}
+
+func (a *accessList) email(email string) string {
+	return a.emails[normalizeEmail(email)]
+}
+
+// Matches сообщает, относится ли правило к адресу ip или к email.
+func (rule AccessRule) Matches(ip, email string) bool {
+	list, err := compileAccess([]AccessRule{rule})
+	if err != nil {
+		return false
+	}
+	return list.ip(ip) != "" || list.email(email) != ""
+}
+
+// ReloadAccess перечитывает правила из хранилища. Limiter вызывает его сам раз в
+// AccessRefresh, чтобы видеть изменения, сделанные на других репликах.
+func (l *Limiter) ReloadAccess() error {
+	rules, err := l.accessStore.AccessRules()
+	if err != nil {
+		return err
+	}
+	list, err := compileAccess(rules)
+	if err != nil {
+		return err
+	}
+	l.access.Store(list)
+	return nil
+}
+
+func (l *Limiter) accessLoop(interval time.Duration) {
+	defer close(l.accessDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.ReloadAccess(); err != nil {
+				slog.Error("failed to reload access rules", "err", err)
+			}
+		case <-l.stopAccess:
+			return
+		}
+	}
+}
+
+// IPAccess возвращает действие правила для адреса: AccessAllow, AccessBan или "".
+func (l *Limiter) IPAccess(ip string) string {
+	return l.access.Load().ip(ip)
+}
+
+// EmailAccess возвращает действие правила для email: AccessAllow, AccessBan или "".
+func (l *Limiter) EmailAccess(email string) string {
+	return l.access.Load().email(email)
+}
+
+// AccessRules возвращает правила из хранилища.
+func (l *Limiter) AccessRules() ([]AccessRule, error) {
+	return l.accessStore.AccessRules()
+}
+
+// SetAccessRule сохраняет правило и сразу применяет его на этой реплике.
+func (l *Limiter) SetAccessRule(rule AccessRule) error {
+	if rule.Action != AccessAllow && rule.Action != AccessBan {
+		return fmt.Errorf("unknown access action %q", rule.Action)
+	}
+	value, err := ParseAccessValue(rule.Value)
+	if err != nil {
+		return err
+	}
+	rule.Value = value
+	if err := l.accessStore.SetAccessRule(rule); err != nil {
+		return err
+	}
+	return l.ReloadAccess()
+}
+
+// DeleteAccessRule удаляет правило и сразу применяет изменение на этой реплике.
+func (l *Limiter) DeleteAccessRule(value string) error {
+	if err := l.accessStore.DeleteAccessRule(value); err != nil {
+		return err
+	}
+	return l.ReloadAccess()
+}
+
+// Forbid отвечает 403 через ForbidHandler.
+func (l *Limiter) Forbid(w http.ResponseWriter, r *http.Request, keyType string) {
+	bans.Inc(keyType)
+	if l.ForbidHandler != nil {
+		l.ForbidHandler(w, r)
+		return
+	}
+	http.Error(w, "Access denied.", http.StatusForbidden)
+}
@@ -0,0 +1,231 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Действия правил доступа.
+const (
+	AccessAllow = "allow" // без лимитов и блокировок; квоты отправки действуют
+	AccessBan   = "ban"   // запросы отклоняются с 403
+)
+
+// AccessRule - правило администратора для IP, сети или email.
+type AccessRule struct {
+	Value  string    `json:"value"` // нормализованный IP, CIDR или email, см. ParseAccessValue
+	Action string    `json:"action"`
+	Reason string    `json:"reason"`
+	SetBy  string    `json:"set_by"`
+	SetAt  time.Time `json:"set_at"`
+}
+
+// ParseAccessValue приводит IP, CIDR или email к виду, в котором он хранится:
+// адрес без зоны и IPv4-mapped формы, сеть без битов хоста, email в нижнем регистре.
+func ParseAccessValue(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.Contains(s, "@"):
+		local, domain, _ := strings.Cut(s, "@")
+		if local == "" || domain == "" || strings.ContainsAny(s, " \t,") || strings.Count(s, "@") != 1 {
+			return "", fmt.Errorf("invalid email %q", s)
+		}
+		return normalizeEmail(s), nil
+	case strings.Contains(s, "/"):
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return "", err
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked().String(), nil
+	default:
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return "", err
+		}
+		return addr.Unmap().WithZone("").String(), nil
+	}
+}
+
+// AccessStore хранит правила доступа; правило одно на значение.
+type AccessStore interface {
+	AccessRules() ([]AccessRule, error)
+	SetAccessRule(rule AccessRule) error
+	DeleteAccessRule(value string) error
+}
+
+type MemoryAccessStore struct {
+	mu    sync.RWMutex
+	rules map[string]AccessRule
+}
+
+func NewMemoryAccessStore() *MemoryAccessStore {
+	return &MemoryAccessStore{rules: make(map[string]AccessRule)}
+}
+
+func (s *MemoryAccessStore) AccessRules() ([]AccessRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedRules(s.rules), nil
+}
+
+func (s *MemoryAccessStore) SetAccessRule(rule AccessRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules[rule.Value] = rule
+	return nil
+}
+
+func (s *MemoryAccessStore) DeleteAccessRule(value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.rules, value)
+	return nil
+}
+
+// FileAccessStore держит правила в памяти и переписывает JSON-файл при каждом изменении.
+type FileAccessStore struct {
+	mu       sync.RWMutex
+	filePath string
+	rules    map[string]AccessRule
+}
+
+func NewFileAccessStore(filePath string) (*FileAccessStore, error) {
+	s := &FileAccessStore{filePath: filePath, rules: make(map[string]AccessRule)}
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var list []AccessRule
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		for _, rule := range list {
+			s.rules[rule.Value] = rule
+		}
+	}
+	return s, nil
+}
+
+// save вызывается под s.mu.
+func (s *FileAccessStore) save() error {
+	data, err := json.MarshalIndent(sortedRules(s.rules), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, s.filePath)
+}
+
+func (s *FileAccessStore) AccessRules() ([]AccessRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedRules(s.rules), nil
+}
+
+func (s *FileAccessStore) SetAccessRule(rule AccessRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, had := s.rules[rule.Value]
+	s.rules[rule.Value] = rule
+	if err := s.save(); err != nil {
+		// Откатываем изменение
+		if had {
+			s.rules[rule.Value] = prev
+		} else {
+			delete(s.rules, rule.Value)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileAccessStore) DeleteAccessRule(value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, had := s.rules[value]
+	if !had {
+		return nil
+	}
+	delete(s.rules, value)
+	if err := s.save(); err != nil {
+		s.rules[value] = prev
+		return err
+	}
+	return nil
+}
+
+// SQLAccessStore хранит правила в таблице access_rules общей базы.
+type SQLAccessStore struct {
+	db *sql.DB
+}
+
+func NewSQLAccessStore(db *sql.DB) (*SQLAccessStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS access_rules (
+		value  TEXT    PRIMARY KEY,
+		action TEXT    NOT NULL,
+		reason TEXT    NOT NULL,
+		set_by TEXT    NOT NULL,
+		set_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLAccessStore{db: db}, nil
+}
+
+func (s *SQLAccessStore) AccessRules() ([]AccessRule, error) {
+	rows, err := s.db.Query(`SELECT value, action, reason, set_by, set_at FROM access_rules ORDER BY value`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []AccessRule
+	for rows.Next() {
+		var (
+			rule AccessRule
+			at   int64
+		)
+		if err := rows.Scan(&rule.Value, &rule.Action, &rule.Reason, &rule.SetBy, &at); err != nil {
+			return nil, err
+		}
+		rule.SetAt = time.Unix(0, at)
+		result = append(result, rule)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLAccessStore) SetAccessRule(rule AccessRule) error {
+	_, err := s.db.Exec(`INSERT INTO access_rules (value, action, reason, set_by, set_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(value) DO UPDATE SET action = excluded.action, reason = excluded.reason, set_by = excluded.set_by, set_at = excluded.set_at`,
+		rule.Value, rule.Action, rule.Reason, rule.SetBy, rule.SetAt.UnixNano())
+	return err
+}
+
+func (s *SQLAccessStore) DeleteAccessRule(value string) error {
+	_, err := s.db.Exec(`DELETE FROM access_rules WHERE value = ?`, value)
+	return err
+}
+
+func sortedRules(m map[string]AccessRule) []AccessRule {
+	result := make([]AccessRule, 0, len(m))
+	for _, rule := range m {
+		result = append(result, rule)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Value < result[j].Value })
+	return result
+}
@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowAccessStore читает правила медленно и отмечает чтения после закрытия.
+type slowAccessStore struct {
+	*MemoryAccessStore
+	closed      atomic.Bool
+	afterClose  atomic.Int32
+	readStarted chan struct{}
+}
+
+func (s *slowAccessStore) AccessRules() ([]AccessRule, error) {
+	select {
+	case s.readStarted <- struct{}{}:
+	default:
+	}
+	time.Sleep(20 * time.Millisecond)
+	if s.closed.Load() {
+		s.afterClose.Add(1)
+	}
+	return s.MemoryAccessStore.AccessRules()
+}
+
+// После Stop хранилище правил можно закрывать: перечитывание уже закончилось.
+func TestStopWaitsForAccessReload(t *testing.T) {
+	store := &slowAccessStore{MemoryAccessStore: NewMemoryAccessStore(), readStarted: make(chan struct{})}
+	l := NewLimiter(Config{Access: store, AccessRefresh: time.Millisecond})
+
+	<-store.readStarted
+	l.Stop()
+	store.closed.Store(true)
+	time.Sleep(50 * time.Millisecond)
+	if n := store.afterClose.Load(); n != 0 {
+		t.Fatalf("access rules were read %d times after Stop", n)
+	}
+}
+
+func TestAccessMatching(t *testing.T) {
+	l := NewLimiter(Config{})
+	defer l.Stop()
+	rules := []AccessRule{
+		{Value: "10.0.0.0/8", Action: AccessAllow},
+		{Value: "10.1.2.0/24", Action: AccessBan},
+		{Value: "203.0.113.7", Action: AccessBan},
+		{Value: "2001:db8::/32", Action: AccessAllow},
+		{Value: "2001:db8:bad::1", Action: AccessBan},
+		{Value: "Mallory@Example.com", Action: AccessBan},
+		{Value: "ops@example.com", Action: AccessAllow},
+	}
+	for _, rule := range rules {
+		if err := l.SetAccessRule(rule); err != nil {
+			t.Fatalf("SetAccessRule(%s): %v", rule.Value, err)
+		}
+	}
+
+	ips := []struct{ ip, want string }{
+		{"10.9.8.7", AccessAllow},
+		{"10.1.2.3", AccessBan}, // бан внутри разрешенной сети
+		{"10.1.3.1", AccessAllow},
+		{"203.0.113.7", AccessBan},
+		{"203.0.113.8", ""},
+		{"::ffff:203.0.113.7", AccessBan},
+		{"2001:db8:cafe::1", AccessAllow},
+		{"2001:db8:bad::1", AccessBan},
+		{"fe80::1%eth0", ""},
+		{"not an ip", ""},
+	}
+	for _, tt := range ips {
+		if got := l.IPAccess(tt.ip); got != tt.want {
+			t.Errorf("IPAccess(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	emails := []struct{ email, want string }{
+		{"mallory@example.com", AccessBan},
+		{" MALLORY@example.COM ", AccessBan},
+		{"Ops@Example.com", AccessAllow},
+		{"user@example.com", ""},
+		{"mallory@example.org", ""},
+	}
+	for _, tt := range emails {
+		if got := l.EmailAccess(tt.email); got != tt.want {
+			t.Errorf("EmailAccess(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+
+	if err := l.DeleteAccessRule("10.1.2.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.IPAccess("10.1.2.3"); got != AccessAllow {
+		t.Errorf("IPAccess after deleting the ban = %q, want allow", got)
+	}
+}
+
+func TestSetAccessRuleRejectsInvalid(t *testing.T) {
+	l := NewLimiter(Config{})
+	defer l.Stop()
+	bad := []AccessRule{
+		{Value: "10.0.0.1", Action: "deny"},
+		{Value: "10.0.0.0/33", Action: AccessBan},
+		{Value: "example.com", Action: AccessBan},
+		{Value: "a@b@c", Action: AccessBan},
+		{Value: "@example.com", Action: AccessBan},
+	}
+	for _, rule := range bad {
+		if err := l.SetAccessRule(rule); err == nil {
+			t.Errorf("SetAccessRule(%+v) accepted", rule)
+		}
+	}
+	if rules, _ := l.AccessRules(); len(rules) != 0 {
+		t.Fatalf("invalid rules stored: %+v", rules)
+	}
+}
+
+func TestParseAccessValue(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{" 192.0.2.1 ", "192.0.2.1"},
+		{"::ffff:192.0.2.1", "192.0.2.1"},
+		{"192.0.2.77/24", "192.0.2.0/24"},
+		{"::ffff:192.0.2.0/120", "192.0.2.0/24"},
+		{"2001:DB8::1/32", "2001:db8::/32"},
+		{"User@Example.COM", "user@example.com"},
+	}
+	for _, tt := range tests {
+		got, err := ParseAccessValue(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseAccessValue(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestAccessRuleMatches(t *testing.T) {
+	network := AccessRule{Value: "192.0.2.0/24", Action: AccessBan}
+	if !network.Matches("192.0.2.10", "") || network.Matches("198.51.100.1", "user@example.com") {
+		t.Error("network rule matched the wrong addresses")
+	}
+	email := AccessRule{Value: "user@example.com", Action: AccessAllow}
+	if !email.Matches("", "User@Example.com") || email.Matches("192.0.2.10", "other@example.com") {
+		t.Error("email rule matched the wrong addresses")
+	}
+}
+
+// Правила, записанные другой репликой в общее хранилище, видны после ReloadAccess.
+func TestReloadAccess(t *testing.T) {
+	store := NewMemoryAccessStore()
+	l := NewLimiter(Config{Access: store, AccessRefresh: time.Hour})
+	defer l.Stop()
+
+	if err := store.SetAccessRule(AccessRule{Value: "192.0.2.0/24", Action: AccessBan}); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.IPAccess("192.0.2.1"); got != "" {
+		t.Fatalf("rule applied before reload: %q", got)
+	}
+	if err := l.ReloadAccess(); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.IPAccess("192.0.2.1"); got != AccessBan {
+		t.Fatalf("IPAccess after reload = %q, want ban", got)
+	}
+
+	// Неразборчивое правило в хранилище не сбрасывает уже загруженные
+	if err := store.SetAccessRule(AccessRule{Value: "bogus", Action: AccessBan}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.ReloadAccess(); err == nil {
+		t.Fatal("ReloadAccess accepted an invalid rule")
+	}
+	if got := l.IPAccess("192.0.2.1"); got != AccessBan {
+		t.Fatalf("IPAccess after failed reload = %q, want ban", got)
+	}
+}
+
+func TestMiddlewareAccess(t *testing.T) {
+	p := Policy{Name: "test", Limit: 1, Period: time.Hour, Burst: 1}
+	l, _ := newTestLimiter(t, Config{Policies: []Policy{p}})
+	for _, rule := range []AccessRule{
+		{Value: "192.0.2.1", Action: AccessBan},
+		{Value: "192.0.2.2", Action: AccessAllow},
+	} {
+		if err := l.SetAccessRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := l.ResolveClientIP(l.Middleware(p.Name, func(w http.ResponseWriter, r *http.Request) {}))
+	status := func(ip string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	for i := range 3 {
+		if got := status("192.0.2.1"); got != http.StatusForbidden {
+			t.Fatalf("banned request %d: status %d, want 403", i+1, got)
+		}
+		if got := status("192.0.2.2"); got != http.StatusOK {
+			t.Fatalf("allowed request %d: status %d, want 200", i+1, got)
+		}
+	}
+	// Адрес без правил ограничивается как обычно
+	if got := status("192.0.2.3"); got != http.StatusOK {
+		t.Fatalf("first plain request: status %d", got)
+	}
+	if got := status("192.0.2.3"); got != http.StatusTooManyRequests {
+		t.Fatalf("second plain request: status %d, want 429", got)
+	}
+}
@@ -0,0 +1,213 @@
+package ratelimit
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Limit - параметры GCRA одной политики, которые нужны бэкенду.
+type Limit struct {
+	Name     string        // имя политики, часть ключа в хранилище
+	Interval time.Duration // время восстановления одного запроса
+	Capacity time.Duration // Interval * Burst
+}
+
+// Backend хранит TAT ключей. Проверка и расход должны быть атомарными: иначе
+// несколько реплик, читающих одно состояние, пропустят больше запросов, чем положено.
+type Backend interface {
+	// Take проверяет key по всем limits и, если consume и запрос допускают все,
+	// расходует его. Возвращает для каждой политики, насколько TAT опережал текущее
+	// время до запроса (0 - ведро полное), и допущен ли запрос.
+	Take(ctx context.Context, key string, limits []Limit, consume bool) (ahead []time.Duration, allowed bool, err error)
+	// Refund возвращает один запрос key по всем limits: TAT сдвигается на Interval
+	// назад, но не раньше текущего времени.
+	Refund(ctx context.Context, key string, limits []Limit) error
+	// Reset удаляет состояние key для политик names.
+	Reset(ctx context.Context, key string, names ...string) error
+
+	// Fail атомарно добавляет неудачу к счету key по правилам p, см. Lockout.fail.
+	Fail(ctx context.Context, key string, weight int, p LockoutPolicy) (lk Lockout, started bool, err error)
+	// Lockout возвращает счет неудач key; пустой Lockout, если неудач не было.
+	Lockout(ctx context.Context, key string) (Lockout, error)
+	// Lockouts возвращает заблокированные сейчас ключи.
+	Lockouts(ctx context.Context) ([]Lockout, error)
+	ClearLockout(ctx context.Context, key string) error
+
+	Close() error
+}
+
+// MemoryBackend - состояние в памяти процесса. Подходит для одной реплики: у каждой
+// реплики свои счетчики, и за балансировщиком лимит фактически умножается.
+type memoryLockout struct {
+	Lockout
+	expires time.Time
+}
+
+type MemoryBackend struct {
+	mu          sync.Mutex
+	tat         map[string]time.Time // имя политики + "\x00" + ключ
+	lockouts    map[string]memoryLockout
+	cleanup     *time.Ticker
+	stopCleanup chan struct{}
+	closeOnce   sync.Once
+	now         func() time.Time // часы; подменяются в тестах
+}
+
+// NewMemoryBackend запускает очистку устаревших ключей раз в cleanupInt (по умолчанию 5 минут).
+func NewMemoryBackend(cleanupInt time.Duration) *MemoryBackend {
+	if cleanupInt <= 0 {
+		cleanupInt = 5 * time.Minute
+	}
+	b := &MemoryBackend{
+		tat:         make(map[string]time.Time),
+		lockouts:    make(map[string]memoryLockout),
+		cleanup:     time.NewTicker(cleanupInt),
+		stopCleanup: make(chan struct{}),
+		now:         time.Now,
+	}
+	go b.cleanupLoop()
+	return b
+}
+
+func (b *MemoryBackend) cleanupLoop() {
+	for {
+		select {
+		case <-b.cleanup.C:
+			b.cleanupOld()
+		case <-b.stopCleanup:
+			return
+		}
+	}
+}
+
+// cleanupOld удаляет ключи с полным ведром: такая запись ничем не отличается от
+// отсутствующей.
+func (b *MemoryBackend) cleanupOld() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	for key, tat := range b.tat {
+		if !tat.After(now) {
+			delete(b.tat, key)
+		}
+	}
+	for key, lk := range b.lockouts {
+		if !lk.expires.After(now) {
+			delete(b.lockouts, key)
+		}
+	}
+}
+
+func (b *MemoryBackend) Take(_ context.Context, key string, limits []Limit, consume bool) ([]time.Duration, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	ahead := make([]time.Duration, len(limits))
+	allowed := true
+	for i, l := range limits {
+		tat := b.tat[l.Name+"\x00"+key]
+		if tat.After(now) {
+			ahead[i] = tat.Sub(now)
+		}
+		if ahead[i]+l.Interval > l.Capacity {
+			allowed = false
+		}
+	}
+	if allowed && consume {
+		for i, l := range limits {
+			b.tat[l.Name+"\x00"+key] = now.Add(ahead[i] + l.Interval)
+		}
+	}
+	return ahead, allowed, nil
+}
+
+func (b *MemoryBackend) Refund(_ context.Context, key string, limits []Limit) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	for _, l := range limits {
+		k := l.Name + "\x00" + key
+		if tat := b.tat[k].Add(-l.Interval); tat.After(now) {
+			b.tat[k] = tat
+		} else {
+			delete(b.tat, k)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Reset(_ context.Context, key string, names ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, name := range names {
+		delete(b.tat, name+"\x00"+key)
+	}
+	return nil
+}
+
+// lockout вызывается под b.mu.
+func (b *MemoryBackend) lockout(key string, now time.Time) Lockout {
+	lk, ok := b.lockouts[key]
+	if !ok || !lk.expires.After(now) {
+		return Lockout{Key: key}
+	}
+	return lk.Lockout
+}
+
+func (b *MemoryBackend) Fail(_ context.Context, key string, weight int, p LockoutPolicy) (Lockout, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	lk, started := b.lockout(key, now).fail(now, weight, p)
+	b.lockouts[key] = memoryLockout{Lockout: lk, expires: lk.expires(p)}
+	return lk, started, nil
+}
+
+func (b *MemoryBackend) Lockout(_ context.Context, key string) (Lockout, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lockout(key, b.now()), nil
+}
+
+func (b *MemoryBackend) Lockouts(_ context.Context) ([]Lockout, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	var result []Lockout
+	for _, lk := range b.lockouts {
+		if lk.Locked(now) {
+			result = append(result, lk.Lockout)
+		}
+	}
+	sortLockouts(result)
+	return result, nil
+}
+
+func (b *MemoryBackend) ClearLockout(_ context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.lockouts, key)
+	return nil
+}
+
+// sortLockouts - сначала дольше всех заблокированные.
+func sortLockouts(list []Lockout) {
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].LockedUntil.Equal(list[j].LockedUntil) {
+			return list[i].LockedUntil.After(list[j].LockedUntil)
+		}
+		return list[i].Key < list[j].Key
+	})
+}
+
+func (b *MemoryBackend) Close() error {
+	b.closeOnce.Do(func() {
+		b.cleanup.Stop()
+		close(b.stopCleanup)
+	})
+	return nil
+}
@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Заголовки, из которых берется адрес клиента за доверенным прокси
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderForwarded     = "Forwarded"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// ValidProxyHeader сообщает, умеет ли Limiter читать адрес клиента из заголовка name.
+func ValidProxyHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case HeaderXForwardedFor, HeaderForwarded, http.CanonicalHeaderKey(HeaderXRealIP):
+		return true
+	}
+	return false
+}
+
+// ParseTrustedProxies разбирает список CIDR и отдельных адресов через запятую,
+// например "10.0.0.0/8, 192.168.1.10".
+func ParseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if strings.Contains(s, "/") {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+type clientIPKey struct{}
+
+// ResolveClientIP определяет адрес клиента один раз на запрос и кладет его в контекст,
+// чтобы rate limiter, логи, аудит и сессии видели один и тот же адрес.
+func (l *Limiter) ResolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := l.clientIP(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	})
+}
+
+// clientIP разбирает цепочку прокси справа налево. Правые записи добавлены нашими
+// прокси и им можно верить; первая запись не из доверенной сети - это клиент. Все, что
+// левее, прислал сам клиент и могло быть подделано.
+func (l *Limiter) clientIP(r *http.Request) string {
+	remote, ok := parseHop(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !l.trusted(remote) {
+		return remote.String()
+	}
+
+	var hops []string
+	switch l.proxyHeader {
+	case HeaderForwarded:
+		hops = forwardedFor(r.Header.Values(HeaderForwarded))
+	case HeaderXForwardedFor:
+		for _, v := range r.Header.Values(HeaderXForwardedFor) {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	default:
+		hops = r.Header.Values(HeaderXRealIP)
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			// "unknown", обфусцированный идентификатор или мусор: дальше цепочке
+			// верить нельзя, клиентом считается ближайший известный узел.
+			break
+		}
+		client = addr
+		if !l.trusted(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func (l *Limiter) trusted(addr netip.Addr) bool {
+	for _, p := range l.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor достает параметры for= из заголовков Forwarded (RFC 7239) по порядку.
+// Элемент без for= пропускается: его добавил прокси, который адрес не сообщает.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hops = append(hops, strings.Trim(value, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop разбирает адрес в форматах "1.2.3.4", "1.2.3.4:80", "2001:db8::1"
+// и "[2001:db8::1]:80". IPv4-mapped адреса приводятся к IPv4, зона отбрасывается.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
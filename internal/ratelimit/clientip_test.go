@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trust, err := ParseTrustedProxies("10.0.0.0/8, 2001:db8:ffff::/48")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		trust   string // "none" - пустой список доверенных прокси
+		header  string // заголовок, которому верит Limiter; по умолчанию X-Forwarded-For
+		remote  string
+		headers map[string][]string
+		want    string
+	}{
+		// X-Forwarded-For
+		{name: "no proxy headers", remote: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "untrusted peer", remote: "203.0.113.5:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7"}}, want: "203.0.113.5"},
+		{name: "empty trust list", trust: "none", remote: "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7"}}, want: "10.0.0.1"},
+		{name: "client behind two proxies", remote: "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7, 10.0.0.2"}}, want: "198.51.100.7"},
+		{name: "spoofed left-most entries", remote: "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"1.2.3.4, 10.0.0.99, 198.51.100.7, 10.0.0.2"}}, want: "198.51.100.7"},
+		{name: "spoofed header line", remote: "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"1.2.3.4", "198.51.100.7,10.0.0.2"}}, want: "198.51.100.7"},
+		{name: "only trusted proxies", remote: "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, want: "10.0.0.3"},
+		{name: "garbage stops the walk", remote: "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7, unknown, 10.0.0.2"}}, want: "10.0.0.2"},
+		{name: "hop with port", remote: "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7:4711"}}, want: "198.51.100.7"},
+		{name: "ipv4-mapped hop", remote: "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"::ffff:198.51.100.7"}}, want: "198.51.100.7"},
+		{name: "ipv6 peer and hop", remote: "[2001:db8:ffff::1]:443",
+			headers: map[string][]string{"X-Forwarded-For": {"2001:db8:cafe::17"}}, want: "2001:db8:cafe::17"},
+		{name: "ipv6 zone dropped", remote: "[fe80::1%eth0]:443", want: "fe80::1"},
+		{name: "other headers ignored", remote: "10.0.0.1:1234",
+			headers: map[string][]string{"Forwarded": {"for=1.2.3.4"}, "X-Real-Ip": {"1.2.3.4"}}, want: "10.0.0.1"},
+
+		// Forwarded (RFC 7239)
+		{name: "forwarded", header: HeaderForwarded, remote: "10.0.0.1:1234",
+			headers: map[string][]string{"Forwarded": {"for=198.51.100.7;proto=https, for=10.0.0.2"}}, want: "198.51.100.7"},
+		{name: "forwarded quoted ipv4 with port", header: HeaderForwarded, remote: "10.0.0.1:1234",
+			headers: map[string][]string{"Forwarded": {`for="198.51.100.7:4711"`}}, want: "198.51.100.7"},
+		{name: "forwarded ipv6 brackets and port", header: HeaderForwarded, remote: "10.0.0.1:1234",
+			headers: map[string][]string{"Forwarded": {`for="[2001:db8:cafe::17]:4711"`}}, want: "2001:db8:cafe::17"},
+		{name: "forwarded ipv6 brackets", header: HeaderForwarded, remote: "10.0.0.1:1234",
+			headers: map[string][]string{"Forwarded": {`For="[2001:db8:cafe::17]"`}}, want: "2001:db8:cafe::17"},
+		{name: "forwarded element without for", header: HeaderForwarded, remote: "10.0.0.1:1234",
+			headers: map[string][]string{"Forwarded": {"for=198.51.100.7", "by=10.0.0.9;proto=https"}}, want: "198.51.100.7"},
+		{name: "forwarded spoofed left-most", header: HeaderForwarded, remote: "10.0.0.1:1234",
+			headers: map[string][]string{"Forwarded": {"for=1.2.3.4, for=198.51.100.7;by=10.0.0.2"}}, want: "198.51.100.7"},
+		{name: "forwarded obfuscated", header: HeaderForwarded, remote: "10.0.0.1:1234",
+			headers: map[string][]string{"Forwarded": {"for=_hidden"}}, want: "10.0.0.1"},
+		{name: "forwarded ignores x-forwarded-for", header: HeaderForwarded, remote: "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"1.2.3.4"}}, want: "10.0.0.1"},
+
+		// X-Real-IP
+		{name: "x-real-ip", header: HeaderXRealIP, remote: "10.0.0.1:1234",
+			headers: map[string][]string{"X-Real-Ip": {"198.51.100.7"}}, want: "198.51.100.7"},
+		{name: "x-real-ip from untrusted peer", header: HeaderXRealIP, remote: "203.0.113.5:1234",
+			headers: map[string][]string{"X-Real-Ip": {"198.51.100.7"}}, want: "203.0.113.5"},
+		{name: "x-real-ip ignores x-forwarded-for", header: HeaderXRealIP, remote: "10.0.0.1:1234",
+			headers: map[string][]string{"X-Forwarded-For": {"1.2.3.4"}}, want: "10.0.0.1"},
+
+		{name: "unparsable peer", remote: "@pipe", want: "@pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{TrustedProxies: trust, ProxyHeader: tt.header}
+			if tt.trust == "none" {
+				cfg.TrustedProxies = nil
+			}
+			l := NewLimiter(cfg)
+			defer l.Stop()
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for name, values := range tt.headers {
+				r.Header[name] = values
+			}
+			var got string
+			l.ResolveClientIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = l.GetIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Fatalf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	got, err := ParseTrustedProxies(" 10.1.2.3/8, 192.168.1.10 ,, ::ffff:192.168.1.11, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.10/32", "192.168.1.11/32", "2001:db8::/32"}
+	if len(got) != len(want) {
+		t.Fatalf("ParseTrustedProxies = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Fatalf("ParseTrustedProxies = %v, want %v", got, want)
+		}
+	}
+	for _, bad := range []string{"10.0.0.0/33", "proxy.local", "10.0.0.1, nope"} {
+		if _, err := ParseTrustedProxies(bad); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) accepted", bad)
+		}
+	}
+}
+
+func TestValidProxyHeader(t *testing.T) {
+	for _, name := range []string{"X-Forwarded-For", "x-forwarded-for", "Forwarded", "X-Real-IP", "x-real-ip"} {
+		if !ValidProxyHeader(name) {
+			t.Errorf("ValidProxyHeader(%q) = false", name)
+		}
+	}
+	for _, name := range []string{"", "CF-Connecting-IP", "X-Client-IP"} {
+		if ValidProxyHeader(name) {
+			t.Errorf("ValidProxyHeader(%q) = true", name)
+		}
+	}
+}
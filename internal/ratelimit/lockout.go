@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"donos-hrm/internal/metrics"
+)
+
+var lockoutsStarted = metrics.NewCounterVec("donos_ratelimit_lockouts_total",
+	"Keys locked out after repeated failed logins, by key type (ip, email).", "key_type")
+
+// PolicyLockout - имя в Result.Policy для отказа из-за блокировки. Это не политика
+// GCRA: Limiter.Policy его не знает.
+const PolicyLockout = "lockout"
+
+// LockoutPolicy - блокировка после неудачных входов. Неудачи имеют вес; когда сумма
+// весов достигает Threshold, ключ блокируется на Base, и каждая следующая блокировка
+// вдвое длиннее предыдущей, но не длиннее Max. Счет забывается через Window без
+// неудач после окончания последней блокировки.
+type LockoutPolicy struct {
+	Threshold int
+	Window    time.Duration
+	Base      time.Duration
+	Max       time.Duration
+}
+
+var DefaultLockout = LockoutPolicy{Threshold: 5, Window: 15 * time.Minute, Base: time.Minute, Max: time.Hour}
+
+func (p LockoutPolicy) Validate() error {
+	if p.Threshold <= 0 || p.Window <= 0 || p.Base <= 0 || p.Max <= 0 {
+		return fmt.Errorf("lockout threshold, window, base and max must be positive")
+	}
+	if p.Base > p.Max {
+		return fmt.Errorf("lockout base %s exceeds max %s", p.Base, p.Max)
+	}
+	return nil
+}
+
+// duration - длительность блокировки номер strikes (с единицы).
+func (p LockoutPolicy) duration(strikes int) time.Duration {
+	d := p.Base
+	for i := 1; i < strikes && d < p.Max; i++ {
+		d *= 2
+	}
+	return min(d, p.Max)
+}
+
+// Lockout - счет неудач ключа.
+type Lockout struct {
+	Key         string
+	Score       int // сумма весов неудач с последней блокировки
+	Strikes     int // сколько раз ключ уже блокировался
+	LockedUntil time.Time
+	LastFailure time.Time
+}
+
+func (lk Lockout) Locked(now time.Time) bool {
+	return lk.LockedUntil.After(now)
+}
+
+// expires - когда запись можно забыть.
+func (lk Lockout) expires(p LockoutPolicy) time.Time {
+	last := lk.LastFailure
+	if lk.LockedUntil.After(last) {
+		last = lk.LockedUntil
+	}
+	return last.Add(p.Window)
+}
+
+// fail добавляет неудачу к записи; заблокированный ключ не копит счет дальше.
+// started - неудача начала новую блокировку. Ту же логику повторяет lockoutScript.
+func (lk Lockout) fail(now time.Time, weight int, p LockoutPolicy) (_ Lockout, started bool) {
+	if lk.Locked(now) {
+		return lk, false
+	}
+	lk.Score += weight
+	lk.LastFailure = now
+	if lk.Score >= p.Threshold {
+		lk.Strikes++
+		lk.LockedUntil = now.Add(p.duration(lk.Strikes))
+		lk.Score = 0
+		return lk, true
+	}
+	return lk, false
+}
+
+// Locked сообщает, заблокирован ли key. Если бэкенд недоступен, решает FailOpen:
+// без него ключ считается заблокированным на LockoutPolicy.Base.
+func (l *Limiter) Locked(ctx context.Context, key string) (Lockout, bool) {
+	lk, err := l.backend.Lockout(ctx, key)
+	if err != nil {
+		if l.backendFailed(ctx, err) {
+			return Lockout{}, false
+		}
+		return Lockout{Key: key, LockedUntil: time.Now().Add(l.lockout.Base)}, true
+	}
+	return lk, lk.Locked(time.Now())
+}
+
+// Fail учитывает неудачный вход ключа key с весом weight. Возвращает состояние после
+// неудачи и started, если она начала блокировку.
+func (l *Limiter) Fail(ctx context.Context, key string, weight int) (_ Lockout, started bool) {
+	lk, started, err := l.backend.Fail(ctx, key, weight, l.lockout)
+	if err != nil {
+		l.backendFailed(ctx, err)
+		return Lockout{Key: key}, false
+	}
+	if started {
+		keyType, _, _ := strings.Cut(key, ":")
+		lockoutsStarted.Inc(keyType)
+		slog.WarnContext(ctx, "key locked out after failed logins",
+			"key_type", keyType, "strikes", lk.Strikes, "until", lk.LockedUntil.Format(time.RFC3339))
+	}
+	return lk, started
+}
+
+// Lockouts возвращает заблокированные сейчас ключи.
+func (l *Limiter) Lockouts(ctx context.Context) ([]Lockout, error) {
+	return l.backend.Lockouts(ctx)
+}
+
+// ClearLockout снимает блокировку и забывает неудачи ключа.
+func (l *Limiter) ClearLockout(ctx context.Context, key string) error {
+	return l.backend.ClearLockout(ctx, key)
+}
+
+// LockoutResult - отказ для TooManyRequests на время блокировки.
+func LockoutResult(lk Lockout) Result {
+	return Result{
+		Policy:     Policy{Name: PolicyLockout},
+		RetryAfter: max(time.Until(lk.LockedUntil), time.Second),
+	}
+}
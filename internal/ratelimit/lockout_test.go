@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLockout(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	b := NewMemoryBackend(0)
+	b.now = clock.Now
+	defer b.Close()
+	checkLockoutEscalation(t, b, clock)
+}
+
+func TestLockoutDuration(t *testing.T) {
+	p := LockoutPolicy{Threshold: 5, Window: time.Hour, Base: time.Minute, Max: 10 * time.Minute}
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, d := range want {
+		if got := p.duration(i + 1); got != d {
+			t.Errorf("duration(%d) = %s, want %s", i+1, got, d)
+		}
+	}
+	// Удвоение останавливается на Max и не переполняется
+	if got := p.duration(1000); got != p.Max {
+		t.Errorf("duration(1000) = %s, want %s", got, p.Max)
+	}
+}
+
+func TestLockoutPolicyValidate(t *testing.T) {
+	if err := DefaultLockout.Validate(); err != nil {
+		t.Fatalf("DefaultLockout: %v", err)
+	}
+	bad := []LockoutPolicy{
+		{},
+		{Threshold: 0, Window: time.Minute, Base: time.Minute, Max: time.Hour},
+		{Threshold: 5, Window: time.Minute, Base: -time.Minute, Max: time.Hour},
+		{Threshold: 5, Window: time.Minute, Base: 2 * time.Hour, Max: time.Hour},
+	}
+	for _, p := range bad {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted", p)
+		}
+	}
+}
+
+// Limiter поверх бэкенда: вес неудач, отказ на время блокировки и ее снятие.
+func TestLimiterLockout(t *testing.T) {
+	p := LockoutPolicy{Threshold: 5, Window: 10 * time.Minute, Base: time.Minute, Max: time.Hour}
+	l, _ := newTestLimiter(t, Config{Lockout: p})
+	ctx := context.Background()
+	const key = "ip:192.0.2.1"
+
+	if _, started := l.Fail(ctx, key, 3); started {
+		t.Fatal("lockout started below threshold")
+	}
+	if _, locked := l.Locked(ctx, key); locked {
+		t.Fatal("key locked below threshold")
+	}
+	lk, started := l.Fail(ctx, key, 2)
+	if !started || lk.Strikes != 1 {
+		t.Fatalf("weighted failures did not lock the key: %+v", lk)
+	}
+	if _, locked := l.Locked(ctx, key); !locked {
+		t.Fatal("key not locked after reaching threshold")
+	}
+	if _, locked := l.Locked(ctx, "ip:192.0.2.2"); locked {
+		t.Fatal("other key locked")
+	}
+	if res := LockoutResult(lk); res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > time.Minute {
+		t.Fatalf("LockoutResult = %+v", res)
+	}
+	list, err := l.Lockouts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Key != key {
+		t.Fatalf("Lockouts = %+v, want %s", list, key)
+	}
+
+	if err := l.ClearLockout(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, locked := l.Locked(ctx, key); locked {
+		t.Fatal("key still locked after ClearLockout")
+	}
+	// Снятая блокировка забывает и счет: следующая снова начинается с Base
+	if lk, started := l.Fail(ctx, key, 5); !started || lk.Strikes != 1 {
+		t.Fatalf("lockout after clear: %+v", lk)
+	}
+}
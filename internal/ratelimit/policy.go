@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Имена политик. Каждая считается отдельно: попытки входа не расходуют лимит API.
+// Отправка жалоб ограничивается квотой пользователя, см. Quota.
+const (
+	PolicyLogin     = "login"
+	PolicyCallback  = "callback"
+	PolicyAPI       = "api"
+	PolicyCSPReport = "csp_report"
+)
+
+// Policy - Limit запросов за Period в среднем, с разовым всплеском до Burst запросов.
+// Интервал между запросами - Period/Limit; после простоя копится не больше Burst.
+type Policy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+// DefaultPolicies - лимиты, если политика не переопределена в конфигурации.
+var DefaultPolicies = []Policy{
+	{Name: PolicyLogin, Limit: 5, Period: time.Minute, Burst: 5},
+	{Name: PolicyCallback, Limit: 5, Period: time.Minute, Burst: 5},
+	{Name: PolicyAPI, Limit: 60, Period: time.Minute, Burst: 20},
+	{Name: PolicyCSPReport, Limit: 30, Period: time.Minute, Burst: 10},
+}
+
+// interval - время, за которое восстанавливается один запрос.
+func (p Policy) interval() time.Duration {
+	return p.Period / time.Duration(p.Limit)
+}
+
+func (p Policy) validate() error {
+	if p.Limit <= 0 || p.Period <= 0 || p.Burst <= 0 {
+		return fmt.Errorf("policy %s: limit, period and burst must be positive", p.Name)
+	}
+	if p.interval() <= 0 {
+		return fmt.Errorf("policy %s: %d requests per %s is too fast", p.Name, p.Limit, p.Period)
+	}
+	return nil
+}
+
+// String возвращает политику в формате ParsePolicy.
+func (p Policy) String() string {
+	return fmt.Sprintf("%d/%s,burst=%d", p.Limit, p.Period, p.Burst)
+}
+
+// ParsePolicy разбирает "5/1m" или "5/1m,burst=10". Без burst всплеск равен Limit.
+func ParsePolicy(name, spec string) (Policy, error) {
+	p := Policy{Name: name}
+	rate, burst, hasBurst := strings.Cut(strings.TrimSpace(spec), ",")
+	limit, period, ok := strings.Cut(rate, "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("policy %s: %q: want <limit>/<period>[,burst=<n>]", name, spec)
+	}
+	var err error
+	if p.Limit, err = strconv.Atoi(strings.TrimSpace(limit)); err != nil {
+		return Policy{}, fmt.Errorf("policy %s: limit: %w", name, err)
+	}
+	if p.Period, err = time.ParseDuration(strings.TrimSpace(period)); err != nil {
+		return Policy{}, fmt.Errorf("policy %s: period: %w", name, err)
+	}
+	p.Burst = p.Limit
+	if hasBurst {
+		key, value, _ := strings.Cut(strings.TrimSpace(burst), "=")
+		if strings.TrimSpace(key) != "burst" {
+			return Policy{}, fmt.Errorf("policy %s: %q: want burst=<n>", name, burst)
+		}
+		if p.Burst, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+			return Policy{}, fmt.Errorf("policy %s: burst: %w", name, err)
+		}
+	}
+	if err := p.validate(); err != nil {
+		return Policy{}, err
+	}
+	return p, nil
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Политики квоты отправки жалоб; лимиты берутся из Quota пользователя.
+const (
+	PolicySubmitHourly = "submission_hourly"
+	PolicySubmitDaily  = "submission_daily"
+)
+
+// Quota - сколько жалоб пользователь может отправить за час и за сутки. Окна скользящие:
+// каждая отправка возвращается в квоту через час и через сутки соответственно.
+type Quota struct {
+	Hourly int `json:"hourly"`
+	Daily  int `json:"daily"`
+}
+
+var DefaultQuota = Quota{Hourly: 5, Daily: 20}
+
+func (q Quota) Validate() error {
+	if q.Hourly <= 0 || q.Daily <= 0 {
+		return fmt.Errorf("quota limits must be positive")
+	}
+	if q.Hourly > q.Daily {
+		return fmt.Errorf("hourly quota %d exceeds daily quota %d", q.Hourly, q.Daily)
+	}
+	return nil
+}
+
+func (q Quota) String() string {
+	return fmt.Sprintf("%d/hour, %d/day", q.Hourly, q.Daily)
+}
+
+func (q Quota) policies() []Policy {
+	return []Policy{
+		{Name: PolicySubmitHourly, Limit: q.Hourly, Period: time.Hour, Burst: q.Hourly},
+		{Name: PolicySubmitDaily, Limit: q.Daily, Period: 24 * time.Hour, Burst: q.Daily},
+	}
+}
+
+// QuotaResult - состояние обоих окон. Встроенный Result - окно, которое ограничивает
+// сильнее: по нему выставляются заголовки и показывается время до повтора.
+type QuotaResult struct {
+	Result
+	Hourly Result
+	Daily  Result
+}
+
+// AllowQuota расходует одну отправку, если ее допускают оба окна.
+func (l *Limiter) AllowQuota(ctx context.Context, key string, q Quota) QuotaResult {
+	return quotaResult(l.take(ctx, key, true, q.policies()...))
+}
+
+// RefundQuota возвращает отправку, расходованную AllowQuota, если жалобу не удалось
+// сохранить.
+func (l *Limiter) RefundQuota(ctx context.Context, key string, q Quota) error {
+	return l.backend.Refund(ctx, key, backendLimits(q.policies()))
+}
+
+// PeekQuota возвращает остаток квоты, ничего не расходуя.
+func (l *Limiter) PeekQuota(ctx context.Context, key string, q Quota) QuotaResult {
+	return quotaResult(l.take(ctx, key, false, q.policies()...))
+}
+
+// ResetQuota забывает отправки ключа. Вызывается при смене квоты: иначе новый лимит
+// применялся бы к уже накопленному долгу и повышение вступало бы в силу не сразу.
+func (l *Limiter) ResetQuota(ctx context.Context, key string) error {
+	return l.backend.Reset(ctx, key, PolicySubmitHourly, PolicySubmitDaily)
+}
+
+func quotaResult(results []Result) QuotaResult {
+	hourly, daily := results[0], results[1]
+	binding := hourly
+	switch {
+	case daily.RetryAfter > hourly.RetryAfter:
+		binding = daily
+	case hourly.RetryAfter == 0 && daily.RetryAfter == 0 && daily.Remaining < hourly.Remaining:
+		binding = daily
+	}
+	binding.Allowed = hourly.Allowed && daily.Allowed
+	return QuotaResult{Result: binding, Hourly: hourly, Daily: daily}
+}
@@ -0,0 +1,230 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuotaOverride - квота, назначенная пользователю администратором вместо DefaultQuota.
+type QuotaOverride struct {
+	Email string    `json:"email"`
+	Quota Quota     `json:"quota"`
+	SetBy string    `json:"set_by"`
+	SetAt time.Time `json:"set_at"`
+}
+
+// QuotaStore хранит индивидуальные квоты. Пользователь без записи получает квоту по умолчанию.
+type QuotaStore interface {
+	// Override возвращает квоту пользователя; ok == false, если она не назначена.
+	Override(email string) (q Quota, ok bool, err error)
+	SetOverride(o QuotaOverride) error
+	DeleteOverride(email string) error
+	Overrides() ([]QuotaOverride, error)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+type MemoryQuotaStore struct {
+	mu        sync.RWMutex
+	overrides map[string]QuotaOverride
+}
+
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{overrides: make(map[string]QuotaOverride)}
+}
+
+func (s *MemoryQuotaStore) Override(email string) (Quota, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.overrides[normalizeEmail(email)]
+	return o.Quota, ok, nil
+}
+
+func (s *MemoryQuotaStore) SetOverride(o QuotaOverride) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o.Email = normalizeEmail(o.Email)
+	s.overrides[o.Email] = o
+	return nil
+}
+
+func (s *MemoryQuotaStore) DeleteOverride(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.overrides, normalizeEmail(email))
+	return nil
+}
+
+func (s *MemoryQuotaStore) Overrides() ([]QuotaOverride, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedOverrides(s.overrides), nil
+}
+
+// FileQuotaStore держит квоты в памяти и переписывает JSON-файл при каждом изменении.
+type FileQuotaStore struct {
+	mu        sync.RWMutex
+	filePath  string
+	overrides map[string]QuotaOverride
+}
+
+func NewFileQuotaStore(filePath string) (*FileQuotaStore, error) {
+	s := &FileQuotaStore{filePath: filePath, overrides: make(map[string]QuotaOverride)}
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var list []QuotaOverride
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+		for _, o := range list {
+			s.overrides[normalizeEmail(o.Email)] = o
+		}
+	}
+	return s, nil
+}
+
+// save вызывается под s.mu.
+func (s *FileQuotaStore) save() error {
+	data, err := json.MarshalIndent(sortedOverrides(s.overrides), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, s.filePath)
+}
+
+func (s *FileQuotaStore) Override(email string) (Quota, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	o, ok := s.overrides[normalizeEmail(email)]
+	return o.Quota, ok, nil
+}
+
+func (s *FileQuotaStore) SetOverride(o QuotaOverride) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o.Email = normalizeEmail(o.Email)
+	prev, had := s.overrides[o.Email]
+	s.overrides[o.Email] = o
+	if err := s.save(); err != nil {
+		// Откатываем изменение
+		if had {
+			s.overrides[o.Email] = prev
+		} else {
+			delete(s.overrides, o.Email)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileQuotaStore) DeleteOverride(email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	email = normalizeEmail(email)
+	prev, had := s.overrides[email]
+	if !had {
+		return nil
+	}
+	delete(s.overrides, email)
+	if err := s.save(); err != nil {
+		s.overrides[email] = prev
+		return err
+	}
+	return nil
+}
+
+func (s *FileQuotaStore) Overrides() ([]QuotaOverride, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedOverrides(s.overrides), nil
+}
+
+// SQLQuotaStore хранит квоты в таблице submission_quotas общей базы.
+type SQLQuotaStore struct {
+	db *sql.DB
+}
+
+func NewSQLQuotaStore(db *sql.DB) (*SQLQuotaStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS submission_quotas (
+		email  TEXT    PRIMARY KEY,
+		hourly INTEGER NOT NULL,
+		daily  INTEGER NOT NULL,
+		set_by TEXT    NOT NULL,
+		set_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLQuotaStore{db: db}, nil
+}
+
+func (s *SQLQuotaStore) Override(email string) (Quota, bool, error) {
+	var q Quota
+	err := s.db.QueryRow(`SELECT hourly, daily FROM submission_quotas WHERE email = ?`, normalizeEmail(email)).
+		Scan(&q.Hourly, &q.Daily)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Quota{}, false, nil
+	}
+	if err != nil {
+		return Quota{}, false, err
+	}
+	return q, true, nil
+}
+
+func (s *SQLQuotaStore) SetOverride(o QuotaOverride) error {
+	_, err := s.db.Exec(`INSERT INTO submission_quotas (email, hourly, daily, set_by, set_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(email) DO UPDATE SET hourly = excluded.hourly, daily = excluded.daily, set_by = excluded.set_by, set_at = excluded.set_at`,
+		normalizeEmail(o.Email), o.Quota.Hourly, o.Quota.Daily, o.SetBy, o.SetAt.UnixNano())
+	return err
+}
+
+func (s *SQLQuotaStore) DeleteOverride(email string) error {
+	_, err := s.db.Exec(`DELETE FROM submission_quotas WHERE email = ?`, normalizeEmail(email))
+	return err
+}
+
+func (s *SQLQuotaStore) Overrides() ([]QuotaOverride, error) {
+	rows, err := s.db.Query(`SELECT email, hourly, daily, set_by, set_at FROM submission_quotas ORDER BY email`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []QuotaOverride
+	for rows.Next() {
+		var (
+			o  QuotaOverride
+			at int64
+		)
+		if err := rows.Scan(&o.Email, &o.Quota.Hourly, &o.Quota.Daily, &o.SetBy, &at); err != nil {
+			return nil, err
+		}
+		o.SetAt = time.Unix(0, at)
+		result = append(result, o)
+	}
+	return result, rows.Err()
+}
+
+func sortedOverrides(m map[string]QuotaOverride) []QuotaOverride {
+	result := make([]QuotaOverride, 0, len(m))
+	for _, o := range m {
+		result = append(result, o)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Email < result[j].Email })
+	return result
+}
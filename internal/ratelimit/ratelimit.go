@@ -1,147 +1,303 @@
 package ratelimit
 
 import (
-	"net"
+	"context"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
+
+	"donos-hrm/internal/metrics"
 )
 
+var rejections = metrics.NewCounterVec("donos_ratelimit_rejections_total",
+	"Requests rejected by the rate limiter, by policy and key type (ip, email, user).", "policy", "key_type")
+
+var backendErrors = metrics.NewCounterVec("donos_ratelimit_backend_errors_total",
+	"Rate limiter backend failures, by how the request was decided (open, closed).", "decision")
+
+// backendLogInterval - не чаще раза за интервал пишем в лог об ошибке бэкенда: при
+// недоступном Redis она повторяется на каждом запросе.
+const backendLogInterval = time.Minute
+
+// Limiter ограничивает частоту запросов алгоритмом GCRA: на ключ хранится одно время -
+// TAT, момент, когда "ведро" снова станет полным. Запрос проходит, если после него
+// TAT уходит вперед не дальше, чем на Burst интервалов. Само состояние хранит Backend.
 type Limiter struct {
-	mu          sync.RWMutex
-	requests    map[string][]time.Time
-	maxRequests int
-	window      time.Duration
-	cleanup     *time.Ticker
-	stopCleanup chan struct{}
+	backend  Backend
+	failOpen bool
+	policies map[string]Policy
+	lockout  LockoutPolicy
+
+	accessStore AccessStore
+	access      atomic.Pointer[accessList]
+	stopAccess  chan struct{}
+	accessDone  chan struct{}
+	// lastBackendLog - UnixNano последней записи в лог об ошибке бэкенда.
+	lastBackendLog atomic.Int64
+
+	trustedProxies []netip.Prefix
+	proxyHeader    string
+
+	// RejectHandler отвечает на запрос, не прошедший Middleware. Заголовки лимита уже
+	// выставлены. По умолчанию - текстовый ответ 429.
+	RejectHandler func(w http.ResponseWriter, r *http.Request, res Result)
+	// ForbidHandler отвечает на запрос с заблокированного адреса. По умолчанию -
+	// текстовый ответ 403.
+	ForbidHandler func(w http.ResponseWriter, r *http.Request)
 }
 
 type Config struct {
-	MaxRequests int           // Максимальное количество запросов
-	Window      time.Duration // Временное окно (например, 1 минута)
-	CleanupInt  time.Duration // Интервал очистки старых записей
+	// Policies переопределяют DefaultPolicies с тем же именем и добавляют новые.
+	Policies   []Policy
+	CleanupInt time.Duration // Интервал очистки старых записей в MemoryBackend
+
+	// Backend - общее хранилище счетчиков для нескольких реплик. По умолчанию -
+	// MemoryBackend, свой у каждого процесса.
+	Backend Backend
+	// FailOpen - пропускать запросы, когда бэкенд недоступен. Иначе они отклоняются.
+	FailOpen bool
+
+	Lockout LockoutPolicy // по умолчанию DefaultLockout
+
+	// Access - правила allow/ban. AccessRefresh (по умолчанию 30 секунд) - как часто
+	// перечитывать их ради изменений с других реплик.
+	Access        AccessStore
+	AccessRefresh time.Duration
+
+	// TrustedProxies - сети прокси, которым можно верить в заголовке ProxyHeader.
+	// Если список пуст, заголовки игнорируются и адресом клиента считается RemoteAddr.
+	TrustedProxies []netip.Prefix
+	// ProxyHeader - X-Forwarded-For (по умолчанию), Forwarded или X-Real-IP.
+	ProxyHeader string
+}
+
+// Result - решение по запросу и данные для заголовков RateLimit-*.
+type Result struct {
+	Allowed   bool
+	Policy    Policy
+	Remaining int
+	// RetryAfter - через сколько пройдет следующий запрос; ноль, если прошел этот.
+	RetryAfter time.Duration
+	// Reset - через сколько лимит восстановится полностью.
+	Reset time.Duration
 }
 
 func NewLimiter(cfg Config) *Limiter {
-	if cfg.CleanupInt == 0 {
-		cfg.CleanupInt = 5 * time.Minute
+	if cfg.Backend == nil {
+		cfg.Backend = NewMemoryBackend(cfg.CleanupInt)
+	}
+	if cfg.Lockout == (LockoutPolicy{}) {
+		cfg.Lockout = DefaultLockout
+	}
+	if cfg.Access == nil {
+		cfg.Access = NewMemoryAccessStore()
+	}
+	if cfg.AccessRefresh <= 0 {
+		cfg.AccessRefresh = 30 * time.Second
+	}
+	policies := make(map[string]Policy)
+	for _, p := range DefaultPolicies {
+		policies[p.Name] = p
+	}
+	for _, p := range cfg.Policies {
+		policies[p.Name] = p
+	}
+	for _, p := range policies {
+		// Политики из конфигурации проверяет ParsePolicy, так что неверная
+		// сюда попадает только из кода.
+		if err := p.validate(); err != nil {
+			panic("ratelimit: " + err.Error())
+		}
 	}
 	l := &Limiter{
-		requests:    make(map[string][]time.Time),
-		maxRequests: cfg.MaxRequests,
-		window:      cfg.Window,
-		cleanup:     time.NewTicker(cfg.CleanupInt),
-		stopCleanup: make(chan struct{}),
+		backend:  cfg.Backend,
+		failOpen: cfg.FailOpen,
+		policies: policies,
+		lockout:  cfg.Lockout,
+
+		accessStore: cfg.Access,
+		stopAccess:  make(chan struct{}),
+		accessDone:  make(chan struct{}),
+
+		trustedProxies: cfg.TrustedProxies,
+		proxyHeader:    http.CanonicalHeaderKey(cfg.ProxyHeader),
+	}
+	if l.proxyHeader == "" {
+		l.proxyHeader = HeaderXForwardedFor
 	}
-	go l.cleanupLoop()
+	l.access.Store(&accessList{})
+	if err := l.ReloadAccess(); err != nil {
+		slog.Error("failed to load access rules", "err", err)
+	}
+	go l.accessLoop(cfg.AccessRefresh)
 	return l
 }
 
-func (l *Limiter) cleanupLoop() {
-	for {
-		select {
-		case <-l.cleanup.C:
-			l.cleanupOld()
-		case <-l.stopCleanup:
-			return
-		}
+// Policy возвращает политику по имени. Неизвестное имя - ошибка программы.
+func (l *Limiter) Policy(name string) Policy {
+	p, ok := l.policies[name]
+	if !ok {
+		panic("ratelimit: unknown policy " + name)
 	}
+	return p
 }
 
-func (l *Limiter) cleanupOld() {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+// Allow расходует один запрос ключа key по политике policy. Ключ начинается с типа
+// ("ip:", "email:", "user:"), по нему считается метрика отказов.
+func (l *Limiter) Allow(ctx context.Context, policy, key string) Result {
+	return l.take(ctx, key, true, l.Policy(policy))[0]
+}
 
-	now := time.Now()
-	cutoff := now.Add(-l.window)
+// take проверяет ключ сразу по нескольким политикам и, если consume, расходует запрос
+// только когда его допускают все. Без consume состояние не меняется.
+func (l *Limiter) take(ctx context.Context, key string, consume bool, policies ...Policy) []Result {
+	limits := backendLimits(policies)
+	ahead, allowed, err := l.backend.Take(ctx, key, limits, consume)
+	if err != nil {
+		return l.backendFailure(ctx, err, policies)
+	}
 
-	for key, times := range l.requests {
-		valid := times[:0]
-		for _, t := range times {
-			if t.After(cutoff) {
-				valid = append(valid, t)
-			}
-		}
-		if len(valid) == 0 {
-			delete(l.requests, key)
+	keyType, _, _ := strings.Cut(key, ":")
+	results := make([]Result, len(policies))
+	for i, p := range policies {
+		interval, capacity := limits[i].Interval, limits[i].Capacity
+		res := Result{Policy: p, Reset: ahead[i]}
+		if next := ahead[i] + interval; next > capacity {
+			res.RetryAfter = next - capacity
 		} else {
-			l.requests[key] = valid
+			res.Allowed = true
+			res.Remaining = int((capacity - ahead[i]) / interval)
 		}
+		switch {
+		case !consume:
+		case !allowed:
+			res.Allowed = false
+			if res.RetryAfter > 0 {
+				rejections.Inc(p.Name, keyType)
+			}
+		default:
+			res.Remaining--
+			res.Reset = ahead[i] + interval
+		}
+		results[i] = res
 	}
+	return results
 }
 
-func (l *Limiter) Allow(key string) bool {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	now := time.Now()
-	cutoff := now.Add(-l.window)
+func backendLimits(policies []Policy) []Limit {
+	limits := make([]Limit, len(policies))
+	for i, p := range policies {
+		limits[i] = Limit{Name: p.Name, Interval: p.interval(), Capacity: p.interval() * time.Duration(p.Burst)}
+	}
+	return limits
+}
 
-	// Очищаем старые записи для этого ключа
-	times := l.requests[key]
-	valid := times[:0]
-	for _, t := range times {
-		if t.After(cutoff) {
-			valid = append(valid, t)
+// backendFailure решает судьбу запроса, когда бэкенд не ответил: при FailOpen запрос
+// проходит, как при полном ведре, иначе отклоняется с повтором через интервал политики.
+func (l *Limiter) backendFailure(ctx context.Context, err error, policies []Policy) []Result {
+	open := l.backendFailed(ctx, err)
+	results := make([]Result, len(policies))
+	for i, p := range policies {
+		if open {
+			results[i] = Result{Allowed: true, Policy: p, Remaining: p.Burst - 1}
+		} else {
+			results[i] = Result{Policy: p, RetryAfter: p.interval(), Reset: p.interval()}
 		}
 	}
+	return results
+}
 
-	// Проверяем лимит
-	if len(valid) >= l.maxRequests {
-		return false
+// backendFailed учитывает ошибку бэкенда и возвращает FailOpen - пропускать ли запрос.
+func (l *Limiter) backendFailed(ctx context.Context, err error) bool {
+	decision := "closed"
+	if l.failOpen {
+		decision = "open"
 	}
-
-	// Добавляем новый запрос
-	valid = append(valid, now)
-	l.requests[key] = valid
-	return true
+	backendErrors.Inc(decision)
+	now := time.Now().UnixNano()
+	if last := l.lastBackendLog.Load(); now-last >= int64(backendLogInterval) && l.lastBackendLog.CompareAndSwap(last, now) {
+		slog.WarnContext(ctx, "rate limit backend failed", "err", err, "fail", decision)
+	}
+	return l.failOpen
 }
 
-func (l *Limiter) GetIP(r *http.Request) string {
-	// Проверяем X-Forwarded-For (для прокси)
-	forwarded := r.Header.Get("X-Forwarded-For")
-	if forwarded != "" {
-		ips := strings.Split(forwarded, ",")
-		if len(ips) > 0 {
-			ip := strings.TrimSpace(ips[0])
-			if ip != "" {
-				return ip
-			}
-		}
+// SetHeaders выставляет RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset и
+// RateLimit-Policy (draft-ietf-httpapi-ratelimit-headers), а при отказе - Retry-After.
+// Для блокировки (PolicyLockout) лимитов нет, выставляется только Retry-After.
+func SetHeaders(h http.Header, res Result) {
+	p := res.Policy
+	if p.Name == PolicyLockout {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+		return
 	}
-
-	// Проверяем X-Real-IP
-	realIP := r.Header.Get("X-Real-IP")
-	if realIP != "" {
-		return realIP
+	h.Set("RateLimit-Limit", strconv.Itoa(p.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	h.Set("RateLimit-Policy", strconv.Itoa(p.Limit)+";w="+strconv.Itoa(ceilSeconds(p.Period))+";burst="+strconv.Itoa(p.Burst))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
 	}
+}
 
-	// Используем RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+// ceilSeconds округляет вверх: Retry-After: 0 до истечения лимита привел бы к
+// немедленному повтору и новому отказу.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// GetIP возвращает адрес клиента, определенный ResolveClientIP; если запрос прошел
+// мимо middleware, адрес вычисляется заново по тем же правилам.
+func (l *Limiter) GetIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
 	}
-	return ip
+	return l.clientIP(r)
 }
 
-func (l *Limiter) Middleware(next http.HandlerFunc) http.HandlerFunc {
+// Middleware ограничивает запросы по IP клиента политикой policy. Заблокированный
+// администратором адрес получает 403, разрешенный проходит без лимита.
+func (l *Limiter) Middleware(policy string, next http.HandlerFunc) http.HandlerFunc {
+	l.Policy(policy) // неизвестная политика обнаружится при регистрации маршрута
 	return func(w http.ResponseWriter, r *http.Request) {
 		ip := l.GetIP(r)
-		if !l.Allow("ip:" + ip) {
-			http.Error(w, "Too many requests. Please try again later.", http.StatusTooManyRequests)
+		switch l.IPAccess(ip) {
+		case AccessBan:
+			l.Forbid(w, r, "ip")
+			return
+		case AccessAllow:
+			next(w, r)
+			return
+		}
+		res := l.Allow(r.Context(), policy, "ip:"+ip)
+		SetHeaders(w.Header(), res)
+		if !res.Allowed {
+			l.Reject(w, r, res)
 			return
 		}
 		next(w, r)
 	}
 }
 
-func (l *Limiter) CheckEmail(email string) bool {
-	return l.Allow("email:" + email)
+// Reject отвечает 429 через RejectHandler.
+func (l *Limiter) Reject(w http.ResponseWriter, r *http.Request, res Result) {
+	if l.RejectHandler != nil {
+		l.RejectHandler(w, r, res)
+		return
+	}
+	http.Error(w, "Too many requests. Please try again later.", http.StatusTooManyRequests)
 }
 
+// Stop останавливает перечитывание правил, дожидается текущего чтения и закрывает
+// бэкенд. После Stop хранилище правил можно закрывать.
 func (l *Limiter) Stop() {
-	l.cleanup.Stop()
-	close(l.stopCleanup)
+	close(l.stopAccess)
+	<-l.accessDone
+	if err := l.backend.Close(); err != nil {
+		slog.Warn("failed to close rate limit backend", "err", err)
+	}
 }
-
@@ -0,0 +1,246 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeClock - часы MemoryBackend, которые двигает тест.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newTestLimiter - Limiter на MemoryBackend с подменными часами.
+func newTestLimiter(t *testing.T, cfg Config) (*Limiter, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{t: time.Now()}
+	backend := NewMemoryBackend(0)
+	backend.now = clock.Now
+	cfg.Backend = backend
+	l := NewLimiter(cfg)
+	t.Cleanup(l.Stop)
+	return l, clock
+}
+
+func checkResult(t *testing.T, step string, got Result, allowed bool, remaining int, retryAfter, reset time.Duration) {
+	t.Helper()
+	if got.Allowed != allowed || got.Remaining != remaining || got.RetryAfter != retryAfter || got.Reset != reset {
+		t.Fatalf("%s: got allowed=%v remaining=%d retry=%s reset=%s; want allowed=%v remaining=%d retry=%s reset=%s",
+			step, got.Allowed, got.Remaining, got.RetryAfter, got.Reset, allowed, remaining, retryAfter, reset)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	// Интервал 10 секунд, всплеск 3 запроса: ведро на 30 секунд
+	p := Policy{Name: "test", Limit: 6, Period: time.Minute, Burst: 3}
+	l, clock := newTestLimiter(t, Config{Policies: []Policy{p}})
+	ctx := context.Background()
+	allow := func() Result { return l.Allow(ctx, p.Name, "ip:192.0.2.1") }
+	s := time.Second
+
+	checkResult(t, "first", allow(), true, 2, 0, 10*s)
+	checkResult(t, "second", allow(), true, 1, 0, 20*s)
+	checkResult(t, "third", allow(), true, 0, 0, 30*s)
+	checkResult(t, "over burst", allow(), false, 0, 10*s, 30*s)
+	checkResult(t, "rejected request is free", allow(), false, 0, 10*s, 30*s)
+
+	clock.Advance(4 * s)
+	checkResult(t, "after 4s", allow(), false, 0, 6*s, 26*s)
+	clock.Advance(6 * s)
+	checkResult(t, "after 10s", allow(), true, 0, 0, 30*s)
+
+	// После простоя копится не больше Burst
+	clock.Advance(time.Hour)
+	checkResult(t, "after idle", allow(), true, 2, 0, 10*s)
+
+	// Ключи и политики считаются отдельно
+	checkResult(t, "other key", l.Allow(ctx, p.Name, "ip:192.0.2.2"), true, 2, 0, 10*s)
+}
+
+func TestAllowBurstAboveLimit(t *testing.T) {
+	// Один запрос в минуту, но после простоя - до пяти подряд
+	p := Policy{Name: "test", Limit: 1, Period: time.Minute, Burst: 5}
+	l, clock := newTestLimiter(t, Config{Policies: []Policy{p}})
+	ctx := context.Background()
+
+	for i := range 5 {
+		if res := l.Allow(ctx, p.Name, "k"); !res.Allowed || res.Remaining != 4-i {
+			t.Fatalf("request %d: %+v", i+1, res)
+		}
+	}
+	checkResult(t, "sixth", l.Allow(ctx, p.Name, "k"), false, 0, time.Minute, 5*time.Minute)
+	clock.Advance(time.Minute)
+	checkResult(t, "a minute later", l.Allow(ctx, p.Name, "k"), true, 0, 0, 5*time.Minute)
+}
+
+func TestTakeAllOrNothing(t *testing.T) {
+	strict := Policy{Name: "strict", Limit: 6, Period: time.Minute, Burst: 1}
+	loose := Policy{Name: "loose", Limit: 6, Period: time.Minute, Burst: 5}
+	l, _ := newTestLimiter(t, Config{Policies: []Policy{strict, loose}})
+	ctx := context.Background()
+
+	// Без consume состояние не меняется
+	for range 2 {
+		res := l.take(ctx, "k", false, strict, loose)
+		checkResult(t, "peek strict", res[0], true, 1, 0, 0)
+		checkResult(t, "peek loose", res[1], true, 5, 0, 0)
+	}
+
+	res := l.take(ctx, "k", true, strict, loose)
+	checkResult(t, "first strict", res[0], true, 0, 0, 10*time.Second)
+	checkResult(t, "first loose", res[1], true, 4, 0, 10*time.Second)
+
+	// strict отказывает - loose не расходуется, хотя сам бы пропустил
+	for range 3 {
+		res = l.take(ctx, "k", true, strict, loose)
+		if res[0].Allowed || res[1].Allowed {
+			t.Fatalf("second request allowed: %+v", res)
+		}
+		if res[0].RetryAfter != 10*time.Second || res[1].RetryAfter != 0 {
+			t.Fatalf("retry after %s and %s, want 10s and 0", res[0].RetryAfter, res[1].RetryAfter)
+		}
+	}
+	res = l.take(ctx, "k", false, strict, loose)
+	checkResult(t, "loose after rejections", res[1], true, 4, 0, 10*time.Second)
+}
+
+func TestQuota(t *testing.T) {
+	l, clock := newTestLimiter(t, Config{})
+	ctx := context.Background()
+	q := Quota{Hourly: 2, Daily: 3}
+	key := "user:anon-test"
+
+	peek := l.PeekQuota(ctx, key, q)
+	if !peek.Allowed || peek.Remaining != 2 || peek.Policy.Name != PolicySubmitHourly {
+		t.Fatalf("fresh quota: %+v", peek)
+	}
+	for i := range 2 {
+		if res := l.AllowQuota(ctx, key, q); !res.Allowed {
+			t.Fatalf("submission %d rejected: %+v", i+1, res)
+		}
+	}
+
+	// Часовое окно исчерпано: отказ по нему, суточное не расходуется
+	res := l.AllowQuota(ctx, key, q)
+	if res.Allowed || res.Policy.Name != PolicySubmitHourly || res.RetryAfter != 30*time.Minute {
+		t.Fatalf("third submission: %+v", res)
+	}
+	if daily := l.PeekQuota(ctx, key, q).Daily; daily.Remaining != 1 {
+		t.Fatalf("daily remaining = %d after hourly rejection, want 1", daily.Remaining)
+	}
+
+	clock.Advance(30 * time.Minute)
+	if res := l.AllowQuota(ctx, key, q); !res.Allowed || res.Daily.Remaining != 0 {
+		t.Fatalf("submission after 30m: %+v", res)
+	}
+
+	// Теперь ограничивает суточное окно, и отказ по нему не трогает часовое
+	clock.Advance(30 * time.Minute)
+	res = l.AllowQuota(ctx, key, q)
+	if res.Allowed || res.Policy.Name != PolicySubmitDaily || res.RetryAfter != 7*time.Hour {
+		t.Fatalf("submission after 1h: %+v", res)
+	}
+	if hourly := l.PeekQuota(ctx, key, q).Hourly; !hourly.Allowed || hourly.Remaining != 1 {
+		t.Fatalf("hourly after daily rejection: %+v", hourly)
+	}
+
+	if err := l.ResetQuota(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if res := l.PeekQuota(ctx, key, q); res.Hourly.Remaining != 2 || res.Daily.Remaining != 3 {
+		t.Fatalf("quota after reset: %+v", res)
+	}
+}
+
+func TestRefundQuota(t *testing.T) {
+	l, _ := newTestLimiter(t, Config{})
+	checkRefundQuota(t, l)
+}
+
+// checkRefundQuota - возврат отправки; общий сценарий для MemoryBackend и RedisBackend.
+func checkRefundQuota(t *testing.T, l *Limiter) {
+	t.Helper()
+	ctx := context.Background()
+	q := Quota{Hourly: 2, Daily: 3}
+	const key = "user:anon-test"
+
+	// Возврат без расхода не дает квоты сверх лимита
+	if err := l.RefundQuota(ctx, key, q); err != nil {
+		t.Fatal(err)
+	}
+	if res := l.PeekQuota(ctx, key, q); res.Hourly.Remaining != 2 || res.Daily.Remaining != 3 {
+		t.Fatalf("quota after refunding nothing: %+v", res)
+	}
+
+	for range 2 {
+		if res := l.AllowQuota(ctx, key, q); !res.Allowed {
+			t.Fatalf("submission rejected: %+v", res)
+		}
+	}
+	if res := l.AllowQuota(ctx, key, q); res.Allowed {
+		t.Fatalf("third submission allowed: %+v", res)
+	}
+	if err := l.RefundQuota(ctx, key, q); err != nil {
+		t.Fatal(err)
+	}
+	if res := l.PeekQuota(ctx, key, q); res.Hourly.Remaining != 1 || res.Daily.Remaining != 2 {
+		t.Fatalf("quota after refund: hourly %d, daily %d; want 1 and 2", res.Hourly.Remaining, res.Daily.Remaining)
+	}
+	if res := l.AllowQuota(ctx, key, q); !res.Allowed {
+		t.Fatalf("submission after refund rejected: %+v", res)
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	p := Policy{Name: "test", Limit: 6, Period: time.Minute, Burst: 3}
+	tests := []struct {
+		name string
+		res  Result
+		want map[string]string
+	}{
+		{"allowed", Result{Allowed: true, Policy: p, Remaining: 2, Reset: 10 * time.Second}, map[string]string{
+			"RateLimit-Limit":     "3",
+			"RateLimit-Remaining": "2",
+			"RateLimit-Reset":     "10",
+			"RateLimit-Policy":    "6;w=60;burst=3",
+			"Retry-After":         "",
+		}},
+		{"rejected", Result{Policy: p, RetryAfter: 6500 * time.Millisecond, Reset: 26500 * time.Millisecond}, map[string]string{
+			"RateLimit-Remaining": "0",
+			"RateLimit-Reset":     "27",
+			"Retry-After":         "7",
+		}},
+		{"lockout", Result{Policy: Policy{Name: PolicyLockout}, RetryAfter: 90 * time.Second}, map[string]string{
+			"Retry-After":     "90",
+			"RateLimit-Limit": "",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			SetHeaders(h, tt.res)
+			for name, want := range tt.want {
+				if got := h.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+
+	// Заголовки от настоящего отказа
+	l, _ := newTestLimiter(t, Config{Policies: []Policy{p}})
+	var res Result
+	for range 4 {
+		res = l.Allow(context.Background(), p.Name, "k")
+	}
+	h := http.Header{}
+	SetHeaders(h, res)
+	if h.Get("RateLimit-Remaining") != "0" || h.Get("RateLimit-Reset") != "30" || h.Get("Retry-After") != "10" {
+		t.Fatalf("headers after burst: %v", h)
+	}
+}
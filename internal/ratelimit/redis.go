@@ -0,0 +1,586 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcraScript - GCRA по нескольким ключам за один вызов, атомарно на стороне Redis.
+// KEYS - ключи политик; ARGV[1] - "1", если запрос нужно расходовать, затем пары
+// interval, capacity в микросекундах. Время берется у Redis, чтобы часы реплик не
+// влияли на результат. Возвращает {allowed, ahead_1, ..., ahead_n}. TAT записывается
+// через string.format: tostring дал бы %.14g и потерял бы микросекунды.
+const gcraScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local allowed = 1
+local ahead = {}
+local nexts = {}
+for i = 1, #KEYS do
+  local interval = tonumber(ARGV[2 * i])
+  local capacity = tonumber(ARGV[2 * i + 1])
+  local tat = tonumber(redis.call('GET', KEYS[i]) or now)
+  if tat < now then tat = now end
+  ahead[i] = tat - now
+  nexts[i] = tat + interval
+  if nexts[i] - now > capacity then allowed = 0 end
+end
+if allowed == 1 and ARGV[1] == '1' then
+  for i = 1, #KEYS do
+    redis.call('SET', KEYS[i], string.format('%.0f', nexts[i]), 'PX', math.ceil((nexts[i] - now) / 1000))
+  end
+end
+local res = {allowed}
+for i = 1, #KEYS do res[i + 1] = ahead[i] end
+return res
+`
+
+// refundScript - MemoryBackend.Refund на стороне Redis. KEYS - ключи политик, ARGV -
+// их interval в микросекундах.
+const refundScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+for i = 1, #KEYS do
+  local tat = redis.call('GET', KEYS[i])
+  if tat then
+    tat = tonumber(tat) - tonumber(ARGV[i])
+    if tat > now then
+      redis.call('SET', KEYS[i], string.format('%.0f', tat), 'PX', math.ceil((tat - now) / 1000))
+    else
+      redis.call('DEL', KEYS[i])
+    end
+  end
+end
+return 1
+`
+
+// lockoutScript - Lockout.fail на стороне Redis. KEYS[1] - запись ключа в формате
+// parseLockout; ARGV - weight, threshold, window, base, max (время в микросекундах).
+// Возвращает {started, score, strikes, locked_until, last_failure}.
+const lockoutScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local weight, threshold = tonumber(ARGV[1]), tonumber(ARGV[2])
+local window, base, max = tonumber(ARGV[3]), tonumber(ARGV[4]), tonumber(ARGV[5])
+local score, strikes, locked, last = 0, 0, 0, 0
+local v = redis.call('GET', KEYS[1])
+if v then
+  local a, b, c, d = string.match(v, '^(%d+) (%d+) (%d+) (%d+)$')
+  if a then score, strikes, locked, last = tonumber(a), tonumber(b), tonumber(c), tonumber(d) end
+end
+local started = 0
+if locked <= now then
+  score = score + weight
+  last = now
+  if score >= threshold then
+    strikes = strikes + 1
+    local d = base * 2 ^ (strikes - 1)
+    if d > max then d = max end
+    locked = now + d
+    score = 0
+    started = 1
+  end
+  local expires = math.max(locked, last) + window
+  redis.call('SET', KEYS[1], string.format('%.0f %.0f %.0f %.0f', score, strikes, locked, last), 'PX', math.ceil((expires - now) / 1000))
+end
+return {started, score, strikes, locked, last}
+`
+
+var (
+	gcraScriptSHA    = scriptSHA(gcraScript)
+	refundScriptSHA  = scriptSHA(refundScript)
+	lockoutScriptSHA = scriptSHA(lockoutScript)
+)
+
+func scriptSHA(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+// RedisConfig - подключение к Redis или совместимому серверу (Valkey, KeyDB, Dragonfly).
+type RedisConfig struct {
+	// URL - redis://[user:password@]host:port[/db]; rediss:// - то же через TLS.
+	URL string
+	// Prefix - общий префикс ключей, по умолчанию "donos:ratelimit:".
+	Prefix string
+	// PoolSize - сколько простаивающих соединений держать открытыми, по умолчанию 8.
+	PoolSize int
+	// Timeout ограничивает подключение и каждую команду, по умолчанию 250 мс.
+	// Запрос пользователя ждет лимитер, так что таймаут должен быть коротким.
+	Timeout time.Duration
+}
+
+// RedisBackend хранит TAT в Redis, так что все реплики делят одни счетчики. Проверка
+// выполняется Lua-скриптом: чтение и запись нескольких ключей атомарны.
+type RedisBackend struct {
+	addr     string
+	useTLS   bool
+	username string
+	password string
+	db       int
+	prefix   string
+	timeout  time.Duration
+
+	pool chan *redisConn
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewRedisBackend(cfg RedisConfig) (*RedisBackend, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		// url.Error повторяет адрес целиком, вместе с паролем.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("redis url: %w", err)
+	}
+	b := &RedisBackend{prefix: cfg.Prefix, timeout: cfg.Timeout}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		b.useTLS = true
+	default:
+		return nil, fmt.Errorf("redis url: unsupported scheme %q", u.Scheme)
+	}
+	b.addr = u.Host
+	if u.Port() == "" {
+		b.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		b.username = u.User.Username()
+		b.password, _ = u.User.Password()
+		if _, ok := u.User.Password(); !ok {
+			// redis://password@host - устаревшая, но распространенная форма.
+			b.username, b.password = "", b.username
+		}
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		if b.db, err = strconv.Atoi(path); err != nil || b.db < 0 {
+			return nil, fmt.Errorf("redis url: invalid database %q", path)
+		}
+	}
+	if b.prefix == "" {
+		b.prefix = "donos:ratelimit:"
+	}
+	if b.timeout <= 0 {
+		b.timeout = 250 * time.Millisecond
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = 8
+	}
+	b.pool = make(chan *redisConn, cfg.PoolSize)
+	return b, nil
+}
+
+// key - ключ политики в Redis. Ключ клиента в фигурных скобках - hash tag: в Redis
+// Cluster ключи всех политик одного клиента попадают в один слот, иначе скрипт
+// не смог бы работать с ними вместе.
+func (b *RedisBackend) key(policy, key string) string {
+	return b.prefix + policy + ":{" + key + "}"
+}
+
+func (b *RedisBackend) Take(ctx context.Context, key string, limits []Limit, consume bool) ([]time.Duration, bool, error) {
+	keys := make([]string, len(limits))
+	args := make([]string, 0, 1+2*len(limits))
+	args = append(args, "0")
+	if consume {
+		args[0] = "1"
+	}
+	for i, l := range limits {
+		keys[i] = b.key(l.Name, key)
+		args = append(args, strconv.FormatInt(l.Interval.Microseconds(), 10), strconv.FormatInt(l.Capacity.Microseconds(), 10))
+	}
+
+	reply, err := b.eval(ctx, gcraScript, gcraScriptSHA, keys, args)
+	if err != nil {
+		return nil, false, err
+	}
+	values, ok := reply.([]any)
+	if !ok || len(values) != 1+len(limits) {
+		return nil, false, fmt.Errorf("redis: unexpected script reply %v", reply)
+	}
+	ints := make([]int64, len(values))
+	for i, v := range values {
+		if ints[i], ok = v.(int64); !ok {
+			return nil, false, fmt.Errorf("redis: unexpected script reply %v", reply)
+		}
+	}
+	ahead := make([]time.Duration, len(limits))
+	for i := range limits {
+		ahead[i] = time.Duration(ints[i+1]) * time.Microsecond
+	}
+	return ahead, ints[0] == 1, nil
+}
+
+// eval выполняет скрипт по хешу и загружает его, если сервер его еще не знает
+// (после перезапуска или SCRIPT FLUSH).
+func (b *RedisBackend) eval(ctx context.Context, script, sha string, keys, args []string) (any, error) {
+	cmd := make([]string, 0, 3+len(keys)+len(args))
+	cmd = append(cmd, "EVALSHA", sha, strconv.Itoa(len(keys)))
+	cmd = append(cmd, keys...)
+	cmd = append(cmd, args...)
+	reply, err := b.do(ctx, cmd...)
+	var rerr redisError
+	if errors.As(err, &rerr) && strings.HasPrefix(string(rerr), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", script
+		reply, err = b.do(ctx, cmd...)
+	}
+	return reply, err
+}
+
+func (b *RedisBackend) Refund(ctx context.Context, key string, limits []Limit) error {
+	keys := make([]string, len(limits))
+	args := make([]string, len(limits))
+	for i, l := range limits {
+		keys[i] = b.key(l.Name, key)
+		args[i] = strconv.FormatInt(l.Interval.Microseconds(), 10)
+	}
+	_, err := b.eval(ctx, refundScript, refundScriptSHA, keys, args)
+	return err
+}
+
+func (b *RedisBackend) Reset(ctx context.Context, key string, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	cmd := []string{"DEL"}
+	for _, name := range names {
+		cmd = append(cmd, b.key(name, key))
+	}
+	_, err := b.do(ctx, cmd...)
+	return err
+}
+
+// lockoutKey - запись неудач ключа. Заблокированные ключи дополнительно лежат в
+// сортированном множестве lockoutIndex со временем окончания блокировки в мс: его
+// читает только страница администратора, поэтому оно обновляется вне скрипта, и
+// скрипт в Redis Cluster трогает один слот.
+func (b *RedisBackend) lockoutKey(key string) string {
+	return b.prefix + "lockout:{" + key + "}"
+}
+
+func (b *RedisBackend) lockoutIndex() string {
+	return b.prefix + "lockouts"
+}
+
+func (b *RedisBackend) Fail(ctx context.Context, key string, weight int, p LockoutPolicy) (Lockout, bool, error) {
+	us := func(d time.Duration) string { return strconv.FormatInt(d.Microseconds(), 10) }
+	reply, err := b.eval(ctx, lockoutScript, lockoutScriptSHA, []string{b.lockoutKey(key)},
+		[]string{strconv.Itoa(weight), strconv.Itoa(p.Threshold), us(p.Window), us(p.Base), us(p.Max)})
+	if err != nil {
+		return Lockout{}, false, err
+	}
+	values, ok := reply.([]any)
+	if !ok || len(values) != 5 {
+		return Lockout{}, false, fmt.Errorf("redis: unexpected script reply %v", reply)
+	}
+	ints := make([]int64, len(values))
+	for i, v := range values {
+		if ints[i], ok = v.(int64); !ok {
+			return Lockout{}, false, fmt.Errorf("redis: unexpected script reply %v", reply)
+		}
+	}
+	lk := Lockout{
+		Key:         key,
+		Score:       int(ints[1]),
+		Strikes:     int(ints[2]),
+		LockedUntil: fromMicros(ints[3]),
+		LastFailure: fromMicros(ints[4]),
+	}
+	started := ints[0] == 1
+	if started {
+		until := strconv.FormatInt(lk.LockedUntil.UnixMilli(), 10)
+		if _, err := b.do(ctx, "ZADD", b.lockoutIndex(), until, key); err != nil {
+			return lk, started, err
+		}
+	}
+	return lk, started, nil
+}
+
+func (b *RedisBackend) Lockout(ctx context.Context, key string) (Lockout, error) {
+	reply, err := b.do(ctx, "GET", b.lockoutKey(key))
+	if err != nil || reply == nil {
+		return Lockout{Key: key}, err
+	}
+	v, ok := reply.([]byte)
+	if !ok {
+		return Lockout{}, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	return parseLockout(key, string(v))
+}
+
+func (b *RedisBackend) Lockouts(ctx context.Context) ([]Lockout, error) {
+	now := time.Now()
+	if _, err := b.do(ctx, "ZREMRANGEBYSCORE", b.lockoutIndex(), "-inf", strconv.FormatInt(now.UnixMilli(), 10)); err != nil {
+		return nil, err
+	}
+	reply, err := b.do(ctx, "ZRANGE", b.lockoutIndex(), "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	members, _ := reply.([]any)
+	var result []Lockout
+	for _, m := range members {
+		key, ok := m.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("redis: unexpected reply %v", reply)
+		}
+		lk, err := b.Lockout(ctx, string(key))
+		if err != nil {
+			return nil, err
+		}
+		if lk.Locked(now) {
+			result = append(result, lk)
+		}
+	}
+	sortLockouts(result)
+	return result, nil
+}
+
+func (b *RedisBackend) ClearLockout(ctx context.Context, key string) error {
+	if _, err := b.do(ctx, "DEL", b.lockoutKey(key)); err != nil {
+		return err
+	}
+	_, err := b.do(ctx, "ZREM", b.lockoutIndex(), key)
+	return err
+}
+
+// parseLockout разбирает запись lockoutScript: score strikes locked_until last_failure,
+// время в микросекундах.
+func parseLockout(key, v string) (Lockout, error) {
+	var score, strikes int
+	var locked, last int64
+	if _, err := fmt.Sscanf(v, "%d %d %d %d", &score, &strikes, &locked, &last); err != nil {
+		return Lockout{}, fmt.Errorf("redis: invalid lockout record %q", v)
+	}
+	return Lockout{Key: key, Score: score, Strikes: strikes, LockedUntil: fromMicros(locked), LastFailure: fromMicros(last)}, nil
+}
+
+// fromMicros переводит 0 в нулевое время.
+func fromMicros(us int64) time.Time {
+	if us == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(us)
+}
+
+// Ping проверяет соединение и авторизацию.
+func (b *RedisBackend) Ping(ctx context.Context) error {
+	_, err := b.do(ctx, "PING")
+	return err
+}
+
+func (b *RedisBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.pool)
+	for c := range b.pool {
+		c.conn.Close()
+	}
+	return nil
+}
+
+// do выполняет команду на соединении из пула. Соединение после ошибки ввода-вывода
+// закрывается: в нем мог остаться непрочитанный ответ. Ответ-ошибка Redis
+// соединение не портит.
+func (b *RedisBackend) do(ctx context.Context, args ...string) (any, error) {
+	c, err := b.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(ctx, b.timeout, args...)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		c.conn.Close()
+		return nil, err
+	}
+	b.put(c)
+	return reply, err
+}
+
+func (b *RedisBackend) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case c, ok := <-b.pool:
+		if ok {
+			return c, nil
+		}
+		return nil, errors.New("redis: backend closed")
+	default:
+	}
+	return b.dial(ctx)
+}
+
+func (b *RedisBackend) put(c *redisConn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		c.conn.Close()
+		return
+	}
+	select {
+	case b.pool <- c:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (b *RedisBackend) dial(ctx context.Context) (*redisConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	var (
+		conn net.Conn
+		err  error
+	)
+	if b.useTLS {
+		d := &tls.Dialer{Config: &tls.Config{MinVersion: tls.VersionTLS12}}
+		conn, err = d.DialContext(ctx, "tcp", b.addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", b.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	var setup [][]string
+	switch {
+	case b.username != "":
+		setup = append(setup, []string{"AUTH", b.username, b.password})
+	case b.password != "":
+		setup = append(setup, []string{"AUTH", b.password})
+	}
+	if b.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(b.db)})
+	}
+	for _, cmd := range setup {
+		if _, err := c.do(ctx, b.timeout, cmd...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("redis: %s: %w", cmd[0], err)
+		}
+	}
+	return c, nil
+}
+
+// redisError - ответ-ошибка сервера ("-ERR ...").
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn - одно соединение по протоколу RESP2.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func (c *redisConn) do(ctx context.Context, timeout time.Duration, args ...string) (any, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if err := writeCommand(c.w, args); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+func writeCommand(w *bufio.Writer, args []string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(w, "$%d\r\n", len(a))
+		w.WriteString(a)
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readReply читает один ответ: string для простых строк, int64, []byte или nil для
+// bulk-строк, []any для массивов. Ответ-ошибка возвращается как redisError.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+	payload := line[1:]
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", payload)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: invalid array length %q", payload)
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		values := make([]any, n)
+		for i := range values {
+			// Ошибка внутри массива - значение, а не сбой: остаток массива нужно дочитать.
+			var rerr redisError
+			if values[i], err = readReply(r); errors.As(err, &rerr) {
+				values[i] = rerr
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
@@ -0,0 +1,395 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// testRedis - miniredis с управляемыми часами: TIME в скриптах и сроки ключей
+// двигаются вместе.
+type testRedis struct {
+	*miniredis.Miniredis
+	now time.Time
+}
+
+func startRedis(t *testing.T) *testRedis {
+	t.Helper()
+	r := &testRedis{Miniredis: miniredis.RunT(t), now: time.Now().Truncate(time.Microsecond)}
+	r.SetTime(r.now)
+	return r
+}
+
+func (r *testRedis) Now() time.Time { return r.now }
+
+func (r *testRedis) Advance(d time.Duration) {
+	r.now = r.now.Add(d)
+	r.SetTime(r.now)
+	r.FastForward(d)
+}
+
+func (r *testRedis) url(path string) string {
+	return "redis://" + r.Addr() + path
+}
+
+func newTestRedisBackend(t *testing.T, url string) *RedisBackend {
+	t.Helper()
+	b, err := NewRedisBackend(RedisConfig{URL: url, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestRedisAllow(t *testing.T) {
+	r := startRedis(t)
+	p := Policy{Name: "test", Limit: 6, Period: time.Minute, Burst: 3}
+	l := NewLimiter(Config{Backend: newTestRedisBackend(t, r.url("")), Policies: []Policy{p}})
+	defer l.Stop()
+	ctx := context.Background()
+	allow := func() Result { return l.Allow(ctx, p.Name, "ip:192.0.2.1") }
+	s := time.Second
+
+	// Та же арифметика, что и у MemoryBackend в TestAllow
+	checkResult(t, "first", allow(), true, 2, 0, 10*s)
+	checkResult(t, "second", allow(), true, 1, 0, 20*s)
+	checkResult(t, "third", allow(), true, 0, 0, 30*s)
+	checkResult(t, "over burst", allow(), false, 0, 10*s, 30*s)
+	r.Advance(4 * s)
+	checkResult(t, "after 4s", allow(), false, 0, 6*s, 26*s)
+	r.Advance(6 * s)
+	checkResult(t, "after 10s", allow(), true, 0, 0, 30*s)
+
+	if keys := r.Keys(); len(keys) != 1 || keys[0] != "donos:ratelimit:test:{ip:192.0.2.1}" {
+		t.Fatalf("keys = %v", keys)
+	}
+	// Ключ живет, пока ведро не наполнится, и после этого пропадает
+	r.Advance(30 * s)
+	if keys := r.Keys(); len(keys) != 0 {
+		t.Fatalf("keys after the bucket refilled = %v", keys)
+	}
+	checkResult(t, "after idle", allow(), true, 2, 0, 10*s)
+}
+
+func TestRedisTakeAllOrNothing(t *testing.T) {
+	r := startRedis(t)
+	strict := Policy{Name: "strict", Limit: 6, Period: time.Minute, Burst: 1}
+	loose := Policy{Name: "loose", Limit: 6, Period: time.Minute, Burst: 5}
+	l := NewLimiter(Config{Backend: newTestRedisBackend(t, r.url("")), Policies: []Policy{strict, loose}})
+	defer l.Stop()
+	ctx := context.Background()
+
+	// consume=false ничего не пишет
+	for range 2 {
+		res := l.take(ctx, "k", false, strict, loose)
+		checkResult(t, "peek strict", res[0], true, 1, 0, 0)
+		checkResult(t, "peek loose", res[1], true, 5, 0, 0)
+	}
+	if keys := r.Keys(); len(keys) != 0 {
+		t.Fatalf("peek wrote keys %v", keys)
+	}
+
+	res := l.take(ctx, "k", true, strict, loose)
+	checkResult(t, "first strict", res[0], true, 0, 0, 10*time.Second)
+	checkResult(t, "first loose", res[1], true, 4, 0, 10*time.Second)
+	for range 3 {
+		if res := l.take(ctx, "k", true, strict, loose); res[0].Allowed || res[1].Allowed {
+			t.Fatalf("request over the strict limit allowed: %+v", res)
+		}
+	}
+	res = l.take(ctx, "k", false, strict, loose)
+	checkResult(t, "loose after rejections", res[1], true, 4, 0, 10*time.Second)
+
+	if err := l.backend.Reset(ctx, "k", strict.Name, loose.Name); err != nil {
+		t.Fatal(err)
+	}
+	if keys := r.Keys(); len(keys) != 0 {
+		t.Fatalf("keys after reset = %v", keys)
+	}
+}
+
+func TestRedisRefundQuota(t *testing.T) {
+	r := startRedis(t)
+	l := NewLimiter(Config{Backend: newTestRedisBackend(t, r.url(""))})
+	defer l.Stop()
+	checkRefundQuota(t, l)
+}
+
+func TestRedisLockout(t *testing.T) {
+	r := startRedis(t)
+	checkLockoutEscalation(t, newTestRedisBackend(t, r.url("")), r)
+}
+
+// lockoutClock - часы бэкенда в сценарии блокировок.
+type lockoutClock interface {
+	Now() time.Time
+	Advance(d time.Duration)
+}
+
+// checkLockoutEscalation прогоняет один сценарий блокировок на бэкенде: MemoryBackend
+// и lockoutScript должны вести себя одинаково.
+func checkLockoutEscalation(t *testing.T, b Backend, clock lockoutClock) {
+	t.Helper()
+	ctx := context.Background()
+	p := LockoutPolicy{Threshold: 3, Window: 10 * time.Minute, Base: time.Minute, Max: 4 * time.Minute}
+	const key = "email:user@example.com"
+	fail := func(step string, weight int, wantStarted bool, score, strikes int, lockedFor time.Duration) Lockout {
+		t.Helper()
+		lk, started, err := b.Fail(ctx, key, weight, p)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		wantUntil := clock.Now().Add(lockedFor)
+		lockOK := lk.LockedUntil.Equal(wantUntil)
+		if lockedFor == 0 {
+			lockOK = !lk.Locked(clock.Now())
+		}
+		if started != wantStarted || lk.Score != score || lk.Strikes != strikes || !lockOK {
+			t.Fatalf("%s: started=%v score=%d strikes=%d until=%s; want started=%v score=%d strikes=%d locked for %s",
+				step, started, lk.Score, lk.Strikes, lk.LockedUntil, wantStarted, score, strikes, lockedFor)
+		}
+		return lk
+	}
+	stored := func(step string) Lockout {
+		t.Helper()
+		lk, err := b.Lockout(ctx, key)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		return lk
+	}
+
+	// Неудачи копятся до порога
+	fail("first failure", 1, false, 1, 0, 0)
+	clock.Advance(time.Minute)
+	fail("second failure", 1, false, 2, 0, 0)
+	locked := fail("threshold", 1, true, 0, 1, time.Minute)
+	if !locked.LastFailure.Equal(clock.Now()) {
+		t.Fatalf("LastFailure = %s, want %s", locked.LastFailure, clock.Now())
+	}
+
+	// Заблокированный ключ счет не копит
+	clock.Advance(30 * time.Second)
+	lk, started, err := b.Fail(ctx, key, 5, p)
+	if err != nil || started || lk.Score != 0 || lk.Strikes != 1 || !lk.LockedUntil.Equal(locked.LockedUntil) {
+		t.Fatalf("failure while locked: %+v started=%v err=%v", lk, started, err)
+	}
+	if lk := stored("while locked"); !lk.Locked(clock.Now()) || lk.Strikes != 1 {
+		t.Fatalf("stored lockout %+v", lk)
+	}
+
+	// Каждая следующая блокировка вдвое длиннее, но не длиннее Max
+	clock.Advance(30 * time.Second)
+	if lk := stored("lock ended"); lk.Locked(clock.Now()) {
+		t.Fatalf("still locked after Base: %+v", lk)
+	}
+	fail("second lockout", 3, true, 0, 2, 2*time.Minute)
+	clock.Advance(2 * time.Minute)
+	fail("third lockout", 3, true, 0, 3, 4*time.Minute)
+	clock.Advance(4 * time.Minute)
+	fail("capped lockout", 3, true, 0, 4, 4*time.Minute)
+
+	// Счет забывается через Window без неудач после конца блокировки
+	clock.Advance(4 * time.Minute)
+	fail("after lockout", 1, false, 1, 4, 0)
+	clock.Advance(p.Window - time.Second)
+	if lk := stored("inside window"); lk.Strikes != 4 {
+		t.Fatalf("forgotten before Window: %+v", lk)
+	}
+	clock.Advance(2 * time.Second)
+	if lk := stored("after window"); lk.Strikes != 0 || lk.Score != 0 {
+		t.Fatalf("remembered after Window: %+v", lk)
+	}
+	fail("fresh start", 3, true, 0, 1, time.Minute)
+
+	if err := b.ClearLockout(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if lk := stored("cleared"); lk.Locked(clock.Now()) || lk.Strikes != 0 {
+		t.Fatalf("lockout after ClearLockout: %+v", lk)
+	}
+}
+
+func TestRedisLockouts(t *testing.T) {
+	r := startRedis(t)
+	b := newTestRedisBackend(t, r.url(""))
+	ctx := context.Background()
+	p := LockoutPolicy{Threshold: 1, Window: time.Minute, Base: time.Minute, Max: time.Hour}
+
+	for _, key := range []string{"ip:192.0.2.1", "email:a@example.com", "email:b@example.com"} {
+		if _, _, err := b.Fail(ctx, key, 1, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Вторая блокировка дольше, поэтому в списке первая
+	r.Advance(time.Minute)
+	if _, _, err := b.Fail(ctx, "email:b@example.com", 1, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ClearLockout(ctx, "ip:192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := b.Lockouts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, lk := range list {
+		keys = append(keys, fmt.Sprintf("%s/%d", lk.Key, lk.Strikes))
+	}
+	if strings.Join(keys, " ") != "email:b@example.com/2 email:a@example.com/1" {
+		t.Fatalf("Lockouts = %v", keys)
+	}
+}
+
+// После SCRIPT FLUSH или перезапуска Redis скрипты загружаются заново через EVAL.
+func TestRedisScriptReload(t *testing.T) {
+	r := startRedis(t)
+	b := newTestRedisBackend(t, r.url(""))
+	ctx := context.Background()
+	limits := []Limit{{Name: "test", Interval: 10 * time.Second, Capacity: 30 * time.Second}}
+	p := LockoutPolicy{Threshold: 2, Window: time.Minute, Base: time.Minute, Max: time.Hour}
+	loaded := func() string {
+		t.Helper()
+		reply, err := b.do(ctx, "SCRIPT", "EXISTS", gcraScriptSHA, lockoutScriptSHA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(reply)
+	}
+
+	if got := loaded(); got != "[0 0]" {
+		t.Fatalf("scripts loaded before first use: %s", got)
+	}
+	for i := range 2 {
+		ahead, allowed, err := b.Take(ctx, "k", limits, true)
+		if err != nil || !allowed || ahead[0] != time.Duration(i)*10*time.Second {
+			t.Fatalf("take %d: ahead=%v allowed=%v err=%v", i+1, ahead, allowed, err)
+		}
+		if _, _, err := b.Fail(ctx, "k", 1, p); err != nil {
+			t.Fatal(err)
+		}
+		if got := loaded(); got != "[1 1]" {
+			t.Fatalf("scripts loaded = %s, want both", got)
+		}
+		if _, err := b.do(ctx, "SCRIPT", "FLUSH"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Счетчики пережили перезагрузку скриптов
+	if lk, err := b.Lockout(ctx, "k"); err != nil || lk.Strikes != 1 {
+		t.Fatalf("lockout after reload: %+v, %v", lk, err)
+	}
+}
+
+func TestRedisAuthAndSelect(t *testing.T) {
+	r := startRedis(t)
+	r.RequireAuth("secret")
+	r.RequireUserAuth("limiter", "s3cret")
+	addr := r.Addr()
+
+	tests := []struct {
+		name string
+		url  string
+		ok   bool
+	}{
+		{"password", "redis://:secret@" + addr, true},
+		{"legacy password form", "redis://secret@" + addr, true},
+		{"acl user", "redis://limiter:s3cret@" + addr, true},
+		{"wrong password", "redis://:nope@" + addr, false},
+		{"wrong user", "redis://admin:s3cret@" + addr, false},
+		{"no password", "redis://" + addr, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newTestRedisBackend(t, tt.url).Ping(context.Background())
+			if tt.ok && err != nil {
+				t.Fatalf("Ping: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("Ping succeeded")
+			}
+			if err != nil && strings.Contains(err.Error(), "nope") {
+				t.Fatalf("error leaks the password: %v", err)
+			}
+		})
+	}
+
+	t.Run("select", func(t *testing.T) {
+		b := newTestRedisBackend(t, "redis://:secret@"+addr+"/5")
+		limits := []Limit{{Name: "test", Interval: time.Second, Capacity: time.Second}}
+		if _, _, err := b.Take(context.Background(), "k", limits, true); err != nil {
+			t.Fatal(err)
+		}
+		if keys := r.DB(5).Keys(); len(keys) != 1 {
+			t.Fatalf("db 5 keys = %v", keys)
+		}
+		if keys := r.DB(0).Keys(); len(keys) != 0 {
+			t.Fatalf("db 0 keys = %v", keys)
+		}
+	})
+}
+
+func TestNewRedisBackend(t *testing.T) {
+	for _, url := range []string{"http://localhost", "redis://localhost/db", "redis://localhost/-1", "redis://user:pa ss@local host"} {
+		_, err := NewRedisBackend(RedisConfig{URL: url})
+		if err == nil {
+			t.Errorf("NewRedisBackend(%q) accepted", url)
+		} else if strings.Contains(err.Error(), "pa ss") {
+			t.Errorf("error leaks the password: %v", err)
+		}
+	}
+	b, err := NewRedisBackend(RedisConfig{URL: "rediss://:pw@redis.internal/2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.addr != "redis.internal:6379" || !b.useTLS || b.password != "pw" || b.db != 2 {
+		t.Fatalf("parsed %+v", b)
+	}
+}
+
+// Недоступный Redis: FailOpen пропускает запросы, иначе они отклоняются, а ключи
+// считаются заблокированными. После возврата сервера лимитер снова работает.
+func TestRedisUnavailable(t *testing.T) {
+	p := Policy{Name: "test", Limit: 6, Period: time.Minute, Burst: 3}
+	for _, failOpen := range []bool{true, false} {
+		t.Run(fmt.Sprintf("fail open %v", failOpen), func(t *testing.T) {
+			r := startRedis(t)
+			l := NewLimiter(Config{Backend: newTestRedisBackend(t, r.url("")), Policies: []Policy{p}, FailOpen: failOpen})
+			defer l.Stop()
+			ctx := context.Background()
+
+			if res := l.Allow(ctx, p.Name, "k"); !res.Allowed {
+				t.Fatalf("allow with redis up: %+v", res)
+			}
+			r.Close()
+
+			res := l.Allow(ctx, p.Name, "k")
+			if failOpen {
+				checkResult(t, "fail open", res, true, 2, 0, 0)
+			} else {
+				checkResult(t, "fail closed", res, false, 0, 10*time.Second, 10*time.Second)
+			}
+			if quota := l.PeekQuota(ctx, "user:u", DefaultQuota); quota.Allowed != failOpen {
+				t.Fatalf("quota allowed = %v, want %v", quota.Allowed, failOpen)
+			}
+			if _, locked := l.Locked(ctx, "email:user@example.com"); locked == failOpen {
+				t.Fatalf("locked = %v with fail open %v", locked, failOpen)
+			}
+			if lk, started := l.Fail(ctx, "email:user@example.com", 1); started || lk.Strikes != 0 {
+				t.Fatalf("Fail with redis down: %+v, started %v", lk, started)
+			}
+
+			if err := r.Restart(); err != nil {
+				t.Fatal(err)
+			}
+			checkResult(t, "after restart", l.Allow(ctx, p.Name, "k"), true, 1, 0, 20*time.Second)
+		})
+	}
+}
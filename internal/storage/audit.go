@@ -0,0 +1,442 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Действия, которые пишутся в журнал аудита.
+const (
+	AuditComplaintHide   = "complaint.hide"
+	AuditComplaintUnhide = "complaint.unhide"
+	AuditComplaintStatus = "complaint.status"
+	AuditComplaintPurge  = "complaint.purge"
+	AuditComplaintExport = "complaint.export"
+	AuditRoleChange      = "role.change"
+	AuditSessionsRevoke  = "sessions.revoke"
+	AuditTokenCreate     = "token.create"
+	AuditTokenRevoke     = "token.revoke"
+	AuditLoginSuccess    = "login.success"
+	AuditLoginDenied     = "login.denied"
+	AuditCSRFRejected    = "csrf.rejected"
+	AuditWebhookCreate   = "webhook.create"
+	AuditWebhookUpdate   = "webhook.update"
+	AuditWebhookDelete   = "webhook.delete"
+	AuditWebhookReplay   = "webhook.replay"
+	AuditQuotaChange     = "quota.change"
+	AuditLoginLockout    = "login.lockout"
+	AuditLockoutClear    = "lockout.clear"
+	AuditAccessChange    = "access.change"
+)
+
+// AuditActions перечисляет действия для фильтра на странице журнала.
+var AuditActions = []string{
+	AuditComplaintHide, AuditComplaintUnhide, AuditComplaintStatus, AuditComplaintPurge,
+	AuditComplaintExport, AuditRoleChange, AuditSessionsRevoke, AuditTokenCreate,
+	AuditTokenRevoke, AuditLoginSuccess, AuditLoginDenied, AuditCSRFRejected,
+	AuditWebhookCreate, AuditWebhookUpdate, AuditWebhookDelete, AuditWebhookReplay,
+	AuditQuotaChange, AuditLoginLockout, AuditLockoutClear, AuditAccessChange,
+}
+
+var ErrAuditChainBroken = errors.New("audit hash chain broken")
+
+// AuditEntry - запись журнала. Hash покрывает все поля записи и PrevHash, поэтому
+// изменение или удаление любой записи ломает цепочку для всех последующих.
+type AuditEntry struct {
+	Seq         int64     `json:"seq"`
+	At          time.Time `json:"at"`
+	Actor       string    `json:"actor"`
+	Action      string    `json:"action"`
+	ComplaintID int       `json:"complaint_id,omitempty"`
+	Target      string    `json:"target,omitempty"` // email или другой объект действия, кроме жалобы
+	IP          string    `json:"ip,omitempty"`
+	Before      string    `json:"before,omitempty"`
+	After       string    `json:"after,omitempty"`
+	PrevHash    string    `json:"prev_hash"`
+	Hash        string    `json:"hash"`
+}
+
+type AuditFilter struct {
+	Actor       string
+	Action      string
+	ComplaintID int
+	Since       time.Time
+	Until       time.Time
+	AfterSeq    int64 // только записи с Seq больше этого
+	Limit       int   // 0 - без ограничения
+}
+
+func (f AuditFilter) match(e AuditEntry) bool {
+	switch {
+	case f.Actor != "" && !strings.EqualFold(e.Actor, f.Actor):
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case f.ComplaintID != 0 && e.ComplaintID != f.ComplaintID:
+		return false
+	case !f.Since.IsZero() && e.At.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !e.At.Before(f.Until):
+		return false
+	case e.Seq <= f.AfterSeq:
+		return false
+	}
+	return true
+}
+
+// AuditStore - журнал только на добавление. Append сам назначает Seq, PrevHash и Hash.
+type AuditStore interface {
+	Append(e AuditEntry) (AuditEntry, error)
+	// Entries возвращает записи по фильтру, новые первыми.
+	Entries(f AuditFilter) ([]AuditEntry, error)
+}
+
+// auditHash считает хеш записи. Время берется в наносекундах, чтобы хеш не зависел
+// от часового пояса, в котором запись прочитана из хранилища.
+func auditHash(e AuditEntry) string {
+	payload, _ := json.Marshal(struct {
+		Seq         int64
+		At          int64
+		Actor       string
+		Action      string
+		ComplaintID int
+		Target      string
+		IP          string
+		Before      string
+		After       string
+		PrevHash    string
+	}{e.Seq, e.At.UnixNano(), e.Actor, e.Action, e.ComplaintID, e.Target, e.IP, e.Before, e.After, e.PrevHash})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// chain заполняет служебные поля новой записи после last.
+func chain(e AuditEntry, last AuditEntry) AuditEntry {
+	e.Seq = last.Seq + 1
+	e.PrevHash = last.Hash
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	e.Hash = auditHash(e)
+	return e
+}
+
+// VerifyAuditChain проверяет всю цепочку и возвращает номер первой испорченной записи.
+func VerifyAuditChain(store AuditStore) (int64, error) {
+	_, brokenAt, err := verifyAfter(store, AuditEntry{})
+	return brokenAt, err
+}
+
+// verifyAfter проверяет записи после уже проверенной prev. Возвращает последнюю
+// верную запись и номер первой испорченной.
+func verifyAfter(store AuditStore, prev AuditEntry) (AuditEntry, int64, error) {
+	entries, err := store.Entries(AuditFilter{AfterSeq: prev.Seq})
+	if err != nil {
+		return prev, 0, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	for _, e := range entries {
+		if e.Seq != prev.Seq+1 || e.PrevHash != prev.Hash || e.Hash != auditHash(e) {
+			return prev, e.Seq, ErrAuditChainBroken
+		}
+		prev = e
+	}
+	return prev, 0, nil
+}
+
+// AuditVerifier проверяет цепочку по мере роста журнала: проверенное начало
+// запоминается, и каждый вызов Verify читает только новые записи. Журнал только
+// дописывается, поэтому начало меняется лишь в обход приложения; такие правки
+// заметит первая проверка после перезапуска.
+type AuditVerifier struct {
+	store AuditStore
+	mu    sync.Mutex
+	last  AuditEntry // последняя проверенная запись
+}
+
+func NewAuditVerifier(store AuditStore) *AuditVerifier {
+	return &AuditVerifier{store: store}
+}
+
+// Verify возвращает номер первой испорченной записи, как VerifyAuditChain.
+func (v *AuditVerifier) Verify() (int64, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	last, brokenAt, err := verifyAfter(v.store, v.last)
+	v.last = last
+	return brokenAt, err
+}
+
+// filterEntries применяет фильтр к записям, упорядоченным от старых к новым.
+func filterEntries(entries []AuditEntry, f AuditFilter) []AuditEntry {
+	var result []AuditEntry
+	for i := len(entries) - 1; i >= 0 && entries[i].Seq > f.AfterSeq; i-- {
+		if !f.match(entries[i]) {
+			continue
+		}
+		result = append(result, entries[i])
+		if f.Limit > 0 && len(result) == f.Limit {
+			break
+		}
+	}
+	return result
+}
+
+type MemoryAuditStore struct {
+	mu      sync.RWMutex
+	entries []AuditEntry
+}
+
+func NewMemoryAuditStore() *MemoryAuditStore {
+	return &MemoryAuditStore{}
+}
+
+func (s *MemoryAuditStore) Append(e AuditEntry) (AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last AuditEntry
+	if n := len(s.entries); n > 0 {
+		last = s.entries[n-1]
+	}
+	e = chain(e, last)
+	s.entries = append(s.entries, e)
+	return e, nil
+}
+
+func (s *MemoryAuditStore) Entries(f AuditFilter) ([]AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return filterEntries(s.entries, f), nil
+}
+
+// FileAuditStore дописывает записи в файл JSON Lines и никогда его не переписывает.
+// Единственное исключение - недописанная последняя строка: ее отрезает Append после
+// ошибки записи или NewFileAuditStore после сбоя посреди записи.
+type FileAuditStore struct {
+	mu      sync.RWMutex
+	file    *os.File
+	size    int64 // длина файла до конца последней целой записи
+	entries []AuditEntry
+}
+
+func NewFileAuditStore(filePath string) (*FileAuditStore, error) {
+	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileAuditStore{file: f}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) > 0 {
+			// Строка без перевода строки - запись, прерванная сбоем; Append о ней уже
+			// сообщил ошибку, в журнал она не попала.
+			slog.Warn("audit log: dropping incomplete last line", "path", filePath, "bytes", len(line))
+			if err := f.Truncate(s.size); err != nil {
+				f.Close()
+				return nil, err
+			}
+			break
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		s.size += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("audit log line %d: %w", len(s.entries)+1, err)
+		}
+		s.entries = append(s.entries, e)
+	}
+	return s, nil
+}
+
+// Append при ошибке записи обрезает файл до прежней длины, чтобы кусок строки не
+// сломал следующий запуск.
+func (s *FileAuditStore) Append(e AuditEntry) (AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last AuditEntry
+	if n := len(s.entries); n > 0 {
+		last = s.entries[n-1]
+	}
+	e = chain(e, last)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	data = append(data, '\n')
+	if _, err := s.file.Write(data); err != nil {
+		return AuditEntry{}, s.rollback(err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return AuditEntry{}, s.rollback(err)
+	}
+	s.size += int64(len(data))
+	s.entries = append(s.entries, e)
+	return e, nil
+}
+
+// rollback отрезает от файла то, что успела записать неудачная Append.
+func (s *FileAuditStore) rollback(err error) error {
+	if terr := s.file.Truncate(s.size); terr != nil {
+		return errors.Join(err, fmt.Errorf("truncate audit log: %w", terr))
+	}
+	return err
+}
+
+func (s *FileAuditStore) Entries(f AuditFilter) ([]AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return filterEntries(s.entries, f), nil
+}
+
+func (s *FileAuditStore) Close() error {
+	return s.file.Close()
+}
+
+// SQLiteAuditStore хранит журнал в таблице audit_log; триггеры запрещают UPDATE и DELETE.
+type SQLiteAuditStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteAuditStore(db *sql.DB) (*SQLiteAuditStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS audit_log (
+		seq          INTEGER PRIMARY KEY,
+		at           INTEGER NOT NULL,
+		actor        TEXT    NOT NULL,
+		action       TEXT    NOT NULL,
+		complaint_id INTEGER NOT NULL DEFAULT 0,
+		target       TEXT    NOT NULL DEFAULT '',
+		ip           TEXT    NOT NULL DEFAULT '',
+		before_value TEXT    NOT NULL DEFAULT '',
+		after_value  TEXT    NOT NULL DEFAULT '',
+		prev_hash    TEXT    NOT NULL,
+		hash         TEXT    NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_actor ON audit_log(actor);
+	CREATE INDEX IF NOT EXISTS idx_audit_complaint ON audit_log(complaint_id);
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteAuditStore{db: db}, nil
+}
+
+const auditColumns = `seq, at, actor, action, complaint_id, target, ip, before_value, after_value, prev_hash, hash`
+
+func (s *SQLiteAuditStore) Append(e AuditEntry) (AuditEntry, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	defer tx.Rollback()
+
+	last, err := scanAudit(tx.QueryRow(`SELECT ` + auditColumns + ` FROM audit_log ORDER BY seq DESC LIMIT 1`))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return AuditEntry{}, err
+	}
+	e = chain(e, last)
+	if _, err := tx.Exec(`INSERT INTO audit_log (`+auditColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Seq, e.At.UnixNano(), e.Actor, e.Action, e.ComplaintID, e.Target, e.IP, e.Before, e.After, e.PrevHash, e.Hash,
+	); err != nil {
+		return AuditEntry{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return AuditEntry{}, err
+	}
+	return e, nil
+}
+
+func (s *SQLiteAuditStore) Entries(f AuditFilter) ([]AuditEntry, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.Actor != "" {
+		where = append(where, `actor = ? COLLATE NOCASE`)
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		where = append(where, `action = ?`)
+		args = append(args, f.Action)
+	}
+	if f.ComplaintID != 0 {
+		where = append(where, `complaint_id = ?`)
+		args = append(args, f.ComplaintID)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, `at >= ?`)
+		args = append(args, f.Since.UnixNano())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, `at < ?`)
+		args = append(args, f.Until.UnixNano())
+	}
+	if f.AfterSeq > 0 {
+		where = append(where, `seq > ?`)
+		args = append(args, f.AfterSeq)
+	}
+
+	q := `SELECT ` + auditColumns + ` FROM audit_log`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	q += ` ORDER BY seq DESC`
+	if f.Limit > 0 {
+		q += fmt.Sprintf(` LIMIT %d`, f.Limit)
+	}
+
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []AuditEntry
+	for rows.Next() {
+		e, err := scanAudit(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+func scanAudit(row rowScanner) (AuditEntry, error) {
+	var (
+		e  AuditEntry
+		at int64
+	)
+	err := row.Scan(&e.Seq, &at, &e.Actor, &e.Action, &e.ComplaintID, &e.Target, &e.IP, &e.Before, &e.After, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	e.At = time.Unix(0, at)
+	return e, nil
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// auditBackend - журнал и способ переписать его в обход Append, как это сделал бы
+// человек с доступом к файлу или базе.
+type auditBackend struct {
+	store   func() AuditStore
+	rewrite func(t *testing.T, entries []AuditEntry)
+}
+
+func eachAuditStore(t *testing.T, test func(t *testing.T, b auditBackend)) {
+	t.Run("memory", func(t *testing.T) {
+		s := NewMemoryAuditStore()
+		test(t, auditBackend{
+			store: func() AuditStore { return s },
+			rewrite: func(t *testing.T, entries []AuditEntry) {
+				s.entries = entries
+			},
+		})
+	})
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		open := func() *FileAuditStore {
+			s, err := NewFileAuditStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		}
+		s := open()
+		test(t, auditBackend{
+			store: func() AuditStore { return s },
+			rewrite: func(t *testing.T, entries []AuditEntry) {
+				var data []byte
+				for _, e := range entries {
+					line, err := json.Marshal(e)
+					if err != nil {
+						t.Fatal(err)
+					}
+					data = append(append(data, line...), '\n')
+				}
+				if err := os.WriteFile(path, data, 0600); err != nil {
+					t.Fatal(err)
+				}
+				s = open()
+			},
+		})
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := NewSQLiteStore(filepath.Join(t.TempDir(), "audit.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		s, err := NewSQLiteAuditStore(db.DB())
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, auditBackend{
+			store: func() AuditStore { return s },
+			rewrite: func(t *testing.T, entries []AuditEntry) {
+				stmts := []string{`DROP TRIGGER audit_log_no_update`, `DROP TRIGGER audit_log_no_delete`, `DELETE FROM audit_log`}
+				for _, q := range stmts {
+					if _, err := db.DB().Exec(q); err != nil {
+						t.Fatal(err)
+					}
+				}
+				for _, e := range entries {
+					_, err := db.DB().Exec(`INSERT INTO audit_log (`+auditColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+						e.Seq, e.At.UnixNano(), e.Actor, e.Action, e.ComplaintID, e.Target, e.IP, e.Before, e.After, e.PrevHash, e.Hash)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+		})
+	})
+}
+
+// appendEntries пишет n записей и возвращает журнал от старых к новым.
+func appendEntries(t *testing.T, s AuditStore, n int) []AuditEntry {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		if _, err := s.Append(AuditEntry{Actor: "admin@example.com", Action: AuditRoleChange, Target: fmt.Sprintf("user%d@example.com", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := s.Entries(AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	return entries
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(entries []AuditEntry) []AuditEntry
+		want   int64
+	}{
+		{"intact", func(entries []AuditEntry) []AuditEntry { return entries }, 0},
+		{"edit", func(entries []AuditEntry) []AuditEntry {
+			entries[2].Target = "someone-else@example.com"
+			return entries
+		}, 3},
+		{"edit with rehash", func(entries []AuditEntry) []AuditEntry {
+			// Пересчитанный хеш записи не совпадет с PrevHash следующей
+			entries[2].Target = "someone-else@example.com"
+			entries[2].Hash = auditHash(entries[2])
+			return entries
+		}, 4},
+		{"delete", func(entries []AuditEntry) []AuditEntry {
+			return append(entries[:2:2], entries[3:]...)
+		}, 4},
+		{"delete last", func(entries []AuditEntry) []AuditEntry {
+			// Удаление хвоста цепочка не ловит: это ограничение хеш-цепочки
+			return entries[:4]
+		}, 0},
+		{"reorder", func(entries []AuditEntry) []AuditEntry {
+			// Запись 3 переставлена перед записью 2 с перенумерацией
+			entries[1], entries[2] = entries[2], entries[1]
+			entries[1].Seq, entries[2].Seq = 2, 3
+			return entries
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eachAuditStore(t, func(t *testing.T, b auditBackend) {
+				entries := appendEntries(t, b.store(), 5)
+				b.rewrite(t, tt.tamper(entries))
+
+				got, err := VerifyAuditChain(b.store())
+				if tt.want == 0 && err != nil {
+					t.Fatalf("VerifyAuditChain = %d, %v; want intact", got, err)
+				}
+				if tt.want != 0 && (got != tt.want || !errors.Is(err, ErrAuditChainBroken)) {
+					t.Fatalf("VerifyAuditChain = %d, %v; want broken at %d", got, err, tt.want)
+				}
+			})
+		})
+	}
+}
+
+// countingAudit запоминает фильтры, с которыми читали журнал.
+type countingAudit struct {
+	AuditStore
+	afterSeq []int64
+}
+
+func (c *countingAudit) Entries(f AuditFilter) ([]AuditEntry, error) {
+	c.afterSeq = append(c.afterSeq, f.AfterSeq)
+	return c.AuditStore.Entries(f)
+}
+
+func TestAuditVerifier(t *testing.T) {
+	eachAuditStore(t, func(t *testing.T, b auditBackend) {
+		appendEntries(t, b.store(), 3)
+		counting := &countingAudit{AuditStore: b.store()}
+		v := NewAuditVerifier(counting)
+
+		if got, err := v.Verify(); got != 0 || err != nil {
+			t.Fatalf("Verify = %d, %v", got, err)
+		}
+		appendEntries(t, b.store(), 2)
+		if got, err := v.Verify(); got != 0 || err != nil {
+			t.Fatalf("Verify = %d, %v", got, err)
+		}
+		// Вторая проверка читает только записи после уже проверенных
+		if fmt.Sprint(counting.afterSeq) != "[0 3]" {
+			t.Fatalf("read after seq %v, want [0 3]", counting.afterSeq)
+		}
+
+		// Порча в еще не проверенной части находится и не забывается
+		entries := appendEntries(t, b.store(), 2)
+		entries[5].After = "tampered"
+		b.rewrite(t, entries)
+		counting.AuditStore = b.store()
+		for range 2 {
+			if got, err := v.Verify(); got != 6 || !errors.Is(err, ErrAuditChainBroken) {
+				t.Fatalf("Verify = %d, %v; want broken at 6", got, err)
+			}
+		}
+	})
+}
+
+// Недописанная строка после сбоя не мешает открыть журнал, а цепочка продолжается
+// от последней целой записи.
+func TestFileAuditIncompleteLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	open := func() *FileAuditStore {
+		t.Helper()
+		s, err := NewFileAuditStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+	appendPartial := func() {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(`{"seq":3,"actor":"admin@exa`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fileSize := func() int64 {
+		t.Helper()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+
+	s := open()
+	appendEntries(t, s, 2)
+	intact := fileSize()
+
+	t.Run("failed append", func(t *testing.T) {
+		// Короткая запись оставила кусок строки; Append отрезает его
+		appendPartial()
+		if err := s.rollback(errors.New("short write")); err == nil {
+			t.Fatal("rollback lost the write error")
+		}
+		if got := fileSize(); got != intact {
+			t.Fatalf("file size %d after rollback, want %d", got, intact)
+		}
+	})
+
+	t.Run("crash during append", func(t *testing.T) {
+		s.Close()
+		appendPartial()
+		s = open()
+		if got := fileSize(); got != intact {
+			t.Fatalf("file size %d after reopening, want %d", got, intact)
+		}
+		if entries, _ := s.Entries(AuditFilter{}); len(entries) != 2 {
+			t.Fatalf("%d entries, want 2", len(entries))
+		}
+	})
+
+	if e, err := s.Append(AuditEntry{Actor: "admin@example.com", Action: AuditRoleChange}); err != nil || e.Seq != 3 {
+		t.Fatalf("Append = %+v, %v; want seq 3", e, err)
+	}
+	s.Close()
+	s = open()
+	if got, err := VerifyAuditChain(s); got != 0 || err != nil {
+		t.Fatalf("VerifyAuditChain = %d, %v; want intact", got, err)
+	}
+
+	// Испорченная строка в середине - не сбой записи, а правка файла: журнал не открывается
+	s.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append([]byte("{broken\n"), data...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileAuditStore(path); err == nil {
+		t.Fatal("corrupted audit log opened")
+	}
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBlobNotFound = errors.New("blob not found")
+	ErrInvalidKey   = errors.New("invalid blob key")
+)
+
+// Attachment - файл, приложенный к жалобе. Содержимое лежит в BlobStore под ключом Key.
+type Attachment struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// BlobInfo описывает сохраненный объект; ModTime нужен сборщику мусора.
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore хранит содержимое вложений. Ключи выдает вызывающий код (см. ValidBlobKey).
+type BlobStore interface {
+	Put(key string, r io.Reader) (int64, error)
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+	List() ([]BlobInfo, error)
+}
+
+// ValidBlobKey допускает только hex-ключи, чтобы ключ нельзя было превратить в путь.
+func ValidBlobKey(key string) bool {
+	if len(key) < 16 || len(key) > 128 {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalBlobStore хранит вложения файлами в каталоге dir, разложенными по префиксу ключа.
+type LocalBlobStore struct {
+	dir string
+}
+
+func NewLocalBlobStore(dir string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &LocalBlobStore{dir: dir}, nil
+}
+
+func (s *LocalBlobStore) path(key string) string {
+	return filepath.Join(s.dir, key[:2], key)
+}
+
+func (s *LocalBlobStore) Put(key string, r io.Reader) (int64, error) {
+	if !ValidBlobKey(key) {
+		return 0, ErrInvalidKey
+	}
+	dst := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return 0, err
+	}
+
+	// Пишем во временный файл и переименовываем, чтобы не оставить половину объекта
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *LocalBlobStore) Open(key string) (io.ReadCloser, error) {
+	if !ValidBlobKey(key) {
+		return nil, ErrInvalidKey
+	}
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (s *LocalBlobStore) Delete(key string) error {
+	if !ValidBlobKey(key) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalBlobStore) List() ([]BlobInfo, error) {
+	var result []BlobInfo
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !ValidBlobKey(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		result = append(result, BlobInfo{Key: d.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return result, err
+}
+
+// MemoryBlobStore - BlobStore для драйвера memory.
+type MemoryBlobStore struct {
+	mu    sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemoryBlobStore() *MemoryBlobStore {
+	return &MemoryBlobStore{blobs: make(map[string]memoryBlob)}
+}
+
+func (s *MemoryBlobStore) Put(key string, r io.Reader) (int64, error) {
+	if !ValidBlobKey(key) {
+		return 0, ErrInvalidKey
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[key] = memoryBlob{data: data, modTime: time.Now()}
+	return int64(len(data)), nil
+}
+
+func (s *MemoryBlobStore) Open(key string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.blobs[key]
+	if !ok {
+		return nil, ErrBlobNotFound
+	}
+	return io.NopCloser(bytes.NewReader(b.data)), nil
+}
+
+func (s *MemoryBlobStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, key)
+	return nil
+}
+
+func (s *MemoryBlobStore) List() ([]BlobInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]BlobInfo, 0, len(s.blobs))
+	for k, b := range s.blobs {
+		result = append(result, BlobInfo{Key: k, Size: int64(len(b.data)), ModTime: b.modTime})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
+}
+
+// CollectGarbage удаляет объекты, на которые не ссылается ни одна жалоба. Трогает только
+// объекты старше olderThan, чтобы не удалить вложение загружаемой прямо сейчас жалобы.
+func CollectGarbage(store Store, blobs BlobStore, olderThan time.Time) (int, error) {
+	complaints, err := store.ListAll()
+	if err != nil {
+		return 0, err
+	}
+	referenced := make(map[string]struct{})
+	for _, c := range complaints {
+		for _, a := range c.Attachments {
+			referenced[a.Key] = struct{}{}
+		}
+	}
+
+	infos, err := blobs.List()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, info := range infos {
+		if _, ok := referenced[info.Key]; ok || info.ModTime.After(olderThan) {
+			continue
+		}
+		if err := blobs.Delete(info.Key); err != nil {
+			return removed, fmt.Errorf("delete blob %s: %w", info.Key, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
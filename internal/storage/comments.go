@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrEmptyComment = errors.New("comment body required")
+
+// Comment - сообщение в переписке по жалобе. Internal-заметки видят только сотрудники.
+// Если пишет автор жалобы, Author совпадает с Complaint.Reporter, поэтому в анонимной
+// жалобе автор остается под псевдонимом.
+type Comment struct {
+	ID           int       `json:"id"`
+	ComplaintID  int       `json:"complaint_id"`
+	Author       string    `json:"author"`
+	FromReporter bool      `json:"from_reporter,omitempty"`
+	Internal     bool      `json:"internal,omitempty"`
+	Body         string    `json:"body"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// prepareComment проверяет комментарий и заполняет служебные поля перед сохранением.
+func prepareComment(c *Comment) error {
+	if c.Body == "" {
+		return ErrEmptyComment
+	}
+	c.CreatedAt = time.Now()
+	return nil
+}
@@ -2,24 +2,42 @@ package storage
 
 import (
 	"encoding/json"
-	"errors"
 	"os"
 	"sync"
 	"time"
+
+	"donos-hrm/internal/metrics"
+)
+
+var (
+	fileSaveDuration = metrics.NewHistogramVec("donos_filestore_save_duration_seconds",
+		"Time spent rewriting the complaints file.", nil)
+	fileSaveErrors = metrics.NewCounterVec("donos_filestore_save_errors_total",
+		"Failed rewrites of the complaints file.")
 )
 
 type FileStore struct {
-	mu         sync.RWMutex
-	filePath   string
-	complaints []Complaint
-	nextID     int
+	mu            sync.RWMutex
+	filePath      string
+	complaints    []Complaint
+	comments      []Comment
+	nextID        int
+	nextCommentID int
+}
+
+// fileComplaint - запись в файле: переписка хранится рядом с жалобой, а формат остается
+// совместимым со старыми файлами, где комментариев еще не было.
+type fileComplaint struct {
+	Complaint
+	Comments []Comment `json:"comments,omitempty"`
 }
 
 func NewFileStore(filePath string) (*FileStore, error) {
 	store := &FileStore{
-		filePath:   filePath,
-		complaints: []Complaint{},
-		nextID:     1,
+		filePath:      filePath,
+		complaints:    []Complaint{},
+		nextID:        1,
+		nextCommentID: 1,
 	}
 
 	// Загружаем данные из файла если он существует
@@ -37,6 +55,11 @@ func NewFileStore(filePath string) (*FileStore, error) {
 		}
 		store.nextID = maxID + 1
 	}
+	for _, c := range store.comments {
+		if c.ID >= store.nextCommentID {
+			store.nextCommentID = c.ID + 1
+		}
+	}
 
 	return store, nil
 }
@@ -49,14 +72,39 @@ func (s *FileStore) load() error {
 	if len(data) == 0 {
 		return nil
 	}
-	return json.Unmarshal(data, &s.complaints)
+	var records []fileComplaint
+	if err := json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+	s.complaints = make([]Complaint, len(records))
+	for i, rec := range records {
+		s.complaints[i] = rec.Complaint
+		s.comments = append(s.comments, rec.Comments...)
+	}
+	// Жалобы, сохраненные до появления статусов
+	for i := range s.complaints {
+		if s.complaints[i].Status == "" {
+			s.complaints[i].Status = StatusNew
+		}
+	}
+	return nil
 }
 
-func (s *FileStore) save() error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+// save вызывается под s.mu, удерживаемым вызывающим кодом.
+func (s *FileStore) save() (err error) {
+	start := time.Now()
+	defer func() {
+		fileSaveDuration.ObserveSince(start)
+		if err != nil {
+			fileSaveErrors.Inc()
+		}
+	}()
 
-	data, err := json.MarshalIndent(s.complaints, "", "  ")
+	records := make([]fileComplaint, len(s.complaints))
+	for i, c := range s.complaints {
+		records[i] = fileComplaint{Complaint: c, Comments: commentsFor(s.comments, c.ID)}
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -73,23 +121,24 @@ func (s *FileStore) save() error {
 
 func (s *FileStore) Add(c Complaint) (Complaint, error) {
 	if c.Subject == "" || c.Description == "" {
-		return Complaint{}, errors.New("subject and description required")
+		return Complaint{}, ErrMissingFields
 	}
 
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	c.ID = s.nextID
 	c.CreatedAt = time.Now()
 	c.Hidden = false
+	c.Status = StatusNew
+	c.History = nil
 	s.nextID++
 	s.complaints = append([]Complaint{c}, s.complaints...) // newest first
-	s.mu.Unlock()
 
 	if err := s.save(); err != nil {
 		// Откатываем изменения при ошибке сохранения
-		s.mu.Lock()
 		s.complaints = s.complaints[1:]
 		s.nextID--
-		s.mu.Unlock()
 		return Complaint{}, err
 	}
 
@@ -118,6 +167,13 @@ func (s *FileStore) ListAll() ([]Complaint, error) {
 	return result, nil
 }
 
+func (s *FileStore) Query(q Query) (Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return queryComplaints(s.complaints, q)
+}
+
 func (s *FileStore) Get(id int) (Complaint, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -127,24 +183,87 @@ func (s *FileStore) Get(id int) (Complaint, error) {
 			return c, nil
 		}
 	}
-	return Complaint{}, errors.New("complaint not found")
+	return Complaint{}, ErrNotFound
 }
 
 func (s *FileStore) SetHidden(id int, hidden bool) error {
+	_, err := s.Update(id, ComplaintUpdate{Hidden: &hidden})
+	return err
+}
+
+func (s *FileStore) SetStatus(id int, to Status, actor, note string) error {
+	_, err := s.Update(id, ComplaintUpdate{Status: &to, Actor: actor, Note: note})
+	return err
+}
+
+func (s *FileStore) Update(id int, u ComplaintUpdate) (Complaint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.complaints {
+		if s.complaints[i].ID == id {
+			prev := s.complaints[i]
+			if _, err := u.apply(&s.complaints[i]); err != nil {
+				return Complaint{}, err
+			}
+			if err := s.save(); err != nil {
+				// Откатываем изменение
+				s.complaints[i] = prev
+				return Complaint{}, err
+			}
+			return prev, nil
+		}
+	}
+	return Complaint{}, ErrNotFound
+}
+
+func (s *FileStore) Delete(id int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	for i := range s.complaints {
 		if s.complaints[i].ID == id {
-			s.complaints[i].Hidden = hidden
+			prevComplaints, prevComments := s.complaints, s.comments
+			s.complaints = append(s.complaints[:i:i], s.complaints[i+1:]...)
+			s.comments = withoutComments(s.comments, id)
 			if err := s.save(); err != nil {
 				// Откатываем изменение
-				s.complaints[i].Hidden = !hidden
+				s.complaints, s.comments = prevComplaints, prevComments
 				return err
 			}
 			return nil
 		}
 	}
-	return errors.New("complaint not found")
+	return ErrNotFound
 }
 
+func (s *FileStore) AddComment(c Comment) (Comment, error) {
+	if err := prepareComment(&c); err != nil {
+		return Comment{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !containsComplaint(s.complaints, c.ComplaintID) {
+		return Comment{}, ErrNotFound
+	}
+	c.ID = s.nextCommentID
+	s.nextCommentID++
+	s.comments = append(s.comments, c)
+
+	if err := s.save(); err != nil {
+		// Откатываем изменения при ошибке сохранения
+		s.comments = s.comments[:len(s.comments)-1]
+		s.nextCommentID--
+		return Comment{}, err
+	}
+	return c, nil
+}
+
+func (s *FileStore) Comments(complaintID int) ([]Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return commentsFor(s.comments, complaintID), nil
+}
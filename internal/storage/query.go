@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// Sort - порядок выдачи жалоб в Query.
+type Sort string
+
+const (
+	SortNewest Sort = "newest"
+	SortOldest Sort = "oldest"
+)
+
+// Sorts перечисляет порядки сортировки для интерфейса.
+var Sorts = []Sort{SortNewest, SortOldest}
+
+func ParseSort(s string) (Sort, bool) {
+	for _, v := range Sorts {
+		if string(v) == s {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func (s Sort) Label() string {
+	if s == SortOldest {
+		return "Oldest first"
+	}
+	return "Newest first"
+}
+
+// Query - выборка жалоб. Пустые поля не фильтруют.
+type Query struct {
+	Text      string    // подстрока темы или описания, без учета регистра
+	Reporters []string  // email или псевдонимы автора; подходит любой
+	Since     time.Time // создана не раньше
+	Until     time.Time // создана раньше
+	Hidden    *bool     // nil - и скрытые, и видимые
+	Status    Status
+	Sort      Sort
+	Limit     int    // 0 - без ограничения
+	Offset    int    // пропустить столько жалоб; не используется вместе с Cursor
+	Cursor    string // NextCursor предыдущей страницы
+}
+
+// Page - одна страница результата Query.
+type Page struct {
+	Complaints []Complaint
+	Total      int    // сколько всего жалоб подходит под фильтр
+	NextCursor string // пусто на последней странице
+}
+
+// Bool возвращает указатель для Query.Hidden.
+func Bool(v bool) *bool {
+	return &v
+}
+
+func (q Query) match(c Complaint) bool {
+	switch {
+	case q.Text != "" && !containsFold(c.Subject, q.Text) && !containsFold(c.Description, q.Text):
+		return false
+	case len(q.Reporters) > 0 && !containsEqualFold(q.Reporters, c.Reporter):
+		return false
+	case !q.Since.IsZero() && c.CreatedAt.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !c.CreatedAt.Before(q.Until):
+		return false
+	case q.Hidden != nil && c.Hidden != *q.Hidden:
+		return false
+	case q.Status != "" && c.Status.orNew() != q.Status:
+		return false
+	}
+	return true
+}
+
+func containsEqualFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// cursor - позиция последней жалобы на странице. Жалобы упорядочены по (CreatedAt, ID),
+// поэтому курсор остается верным, даже если между запросами появились новые жалобы.
+type cursor struct {
+	at int64
+	id int
+}
+
+func encodeCursor(c Complaint) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%d.%d", c.CreatedAt.UnixNano(), c.ID))
+}
+
+func decodeCursor(s string) (cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	var cur cursor
+	if _, err := fmt.Sscanf(string(raw), "%d.%d", &cur.at, &cur.id); err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	return cur, nil
+}
+
+// after сообщает, идет ли c после курсора в порядке order.
+func (cur cursor) after(c Complaint, order Sort) bool {
+	at := c.CreatedAt.UnixNano()
+	if order == SortOldest {
+		return at > cur.at || at == cur.at && c.ID > cur.id
+	}
+	return at < cur.at || at == cur.at && c.ID < cur.id
+}
+
+// queryComplaints выполняет Query над жалобами в памяти (MemoryStore, FileStore).
+func queryComplaints(complaints []Complaint, q Query) (Page, error) {
+	var matched []Complaint
+	for _, c := range complaints {
+		if q.match(c) {
+			matched = append(matched, c)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if q.Sort == SortOldest {
+			a, b = b, a
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+
+	page := Page{Total: len(matched)}
+	start := q.Offset
+	if q.Cursor != "" {
+		cur, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		start = sort.Search(len(matched), func(i int) bool { return cur.after(matched[i], q.Sort) })
+	}
+	start = max(0, min(start, len(matched)))
+	end := len(matched)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+		page.NextCursor = encodeCursor(matched[end-1])
+	}
+	page.Complaints = matched[start:end]
+	return page, nil
+}
@@ -0,0 +1,604 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite" // pure-Go драйвер, работает с CGO_ENABLED=0
+)
+
+// Встроенные lower() и LIKE в SQLite не учитывают регистр только для ASCII, поэтому поиск
+// по тексту использует casefold на strings.ToLower - так же, как MemoryStore и FileStore.
+func init() {
+	sqlite.MustRegisterDeterministicScalarFunction("casefold", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if s, ok := args[0].(string); ok {
+			return strings.ToLower(s), nil
+		}
+		return args[0], nil
+	})
+}
+
+// Миграции применяются по порядку; номер последней примененной хранится в PRAGMA user_version.
+var sqliteMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS complaints (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		reporter    TEXT    NOT NULL,
+		subject     TEXT    NOT NULL,
+		description TEXT    NOT NULL,
+		created_at  INTEGER NOT NULL,
+		hidden      INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_complaints_reporter ON complaints(reporter);
+	CREATE INDEX IF NOT EXISTS idx_complaints_created_at ON complaints(created_at);`,
+
+	`ALTER TABLE complaints ADD COLUMN status TEXT NOT NULL DEFAULT 'new';
+	CREATE INDEX idx_complaints_status ON complaints(status);
+	CREATE TABLE complaint_status_history (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		complaint_id INTEGER NOT NULL REFERENCES complaints(id) ON DELETE CASCADE,
+		from_status  TEXT    NOT NULL,
+		to_status    TEXT    NOT NULL,
+		actor        TEXT    NOT NULL,
+		note         TEXT    NOT NULL DEFAULT '',
+		changed_at   INTEGER NOT NULL
+	);
+	CREATE INDEX idx_status_history_complaint ON complaint_status_history(complaint_id);`,
+
+	`ALTER TABLE complaints ADD COLUMN anonymous INTEGER NOT NULL DEFAULT 0;`,
+
+	`CREATE TABLE complaint_comments (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		complaint_id  INTEGER NOT NULL REFERENCES complaints(id) ON DELETE CASCADE,
+		author        TEXT    NOT NULL,
+		from_reporter INTEGER NOT NULL DEFAULT 0,
+		internal      INTEGER NOT NULL DEFAULT 0,
+		body          TEXT    NOT NULL,
+		created_at    INTEGER NOT NULL
+	);
+	CREATE INDEX idx_comments_complaint ON complaint_comments(complaint_id);`,
+
+	`CREATE TABLE complaint_attachments (
+		blob_key     TEXT    PRIMARY KEY,
+		complaint_id INTEGER NOT NULL REFERENCES complaints(id) ON DELETE CASCADE,
+		name         TEXT    NOT NULL,
+		content_type TEXT    NOT NULL,
+		size         INTEGER NOT NULL,
+		position     INTEGER NOT NULL
+	);
+	CREATE INDEX idx_attachments_complaint ON complaint_attachments(complaint_id);`,
+}
+
+const complaintColumns = `id, reporter, subject, description, created_at, hidden, status, anonymous`
+
+// Прагмы по умолчанию, если в DSN не передано ни одной своей
+var sqliteDefaultPragmas = []string{
+	"busy_timeout(5000)",
+	"journal_mode(WAL)",
+	"foreign_keys(1)",
+}
+
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteStore(dsn string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", sqliteDSN(dsn))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA не поддерживает плейсхолдеры
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// sqliteDSN принимает путь к файлу или URI вида file:path?... и добавляет прагмы по умолчанию.
+func sqliteDSN(dsn string) string {
+	dsn = strings.TrimPrefix(dsn, "sqlite://")
+	if strings.Contains(dsn, "_pragma=") {
+		return dsn
+	}
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	for _, p := range sqliteDefaultPragmas {
+		dsn += sep + "_pragma=" + p
+		sep = "&"
+	}
+	// BEGIN IMMEDIATE сразу берет блокировку на запись, чтобы не ловить SQLITE_BUSY при апгрейде
+	if !strings.Contains(dsn, "_txlock=") {
+		dsn += "&_txlock=immediate"
+	}
+	return dsn
+}
+
+// DB отдает соединение, чтобы другие подсистемы могли хранить данные в той же базе.
+func (s *SQLiteStore) DB() *sql.DB {
+	return s.db
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) Add(c Complaint) (Complaint, error) {
+	if c.Subject == "" || c.Description == "" {
+		return Complaint{}, ErrMissingFields
+	}
+
+	c.CreatedAt = time.Now()
+	c.Hidden = false
+	c.Status = StatusNew
+	c.History = nil
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Complaint{}, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO complaints (reporter, subject, description, created_at, hidden, status, anonymous) VALUES (?, ?, ?, ?, 0, ?, ?)`,
+		c.Reporter, c.Subject, c.Description, c.CreatedAt.UnixNano(), c.Status, c.Anonymous,
+	)
+	if err != nil {
+		return Complaint{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Complaint{}, err
+	}
+	for i, a := range c.Attachments {
+		if _, err := tx.Exec(
+			`INSERT INTO complaint_attachments (blob_key, complaint_id, name, content_type, size, position) VALUES (?, ?, ?, ?, ?, ?)`,
+			a.Key, id, a.Name, a.ContentType, a.Size, i,
+		); err != nil {
+			return Complaint{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Complaint{}, err
+	}
+	c.ID = int(id)
+	return c, nil
+}
+
+func (s *SQLiteStore) List() ([]Complaint, error) {
+	return s.queryWithHistory(`SELECT ` + complaintColumns + `
+		FROM complaints WHERE hidden = 0 ORDER BY created_at DESC, id DESC`)
+}
+
+func (s *SQLiteStore) ListAll() ([]Complaint, error) {
+	return s.queryWithHistory(`SELECT ` + complaintColumns + `
+		FROM complaints ORDER BY created_at DESC, id DESC`)
+}
+
+func (s *SQLiteStore) Query(q Query) (Page, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if q.Text != "" {
+		where = append(where, `(instr(casefold(subject), ?) > 0 OR instr(casefold(description), ?) > 0)`)
+		text := strings.ToLower(q.Text)
+		args = append(args, text, text)
+	}
+	if len(q.Reporters) > 0 {
+		where = append(where, `reporter COLLATE NOCASE IN (?`+strings.Repeat(`, ?`, len(q.Reporters)-1)+`)`)
+		for _, r := range q.Reporters {
+			args = append(args, r)
+		}
+	}
+	if !q.Since.IsZero() {
+		where = append(where, `created_at >= ?`)
+		args = append(args, q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, `created_at < ?`)
+		args = append(args, q.Until.UnixNano())
+	}
+	if q.Hidden != nil {
+		where = append(where, `hidden = ?`)
+		args = append(args, *q.Hidden)
+	}
+	if q.Status != "" {
+		where = append(where, `status = ?`)
+		args = append(args, q.Status)
+	}
+
+	filter := ``
+	if len(where) > 0 {
+		filter = ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	var page Page
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM complaints`+filter, args...).Scan(&page.Total); err != nil {
+		return Page{}, err
+	}
+
+	order, cmp := `DESC`, `<`
+	if q.Sort == SortOldest {
+		order, cmp = `ASC`, `>`
+	}
+	if q.Cursor != "" {
+		cur, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		where = append(where, `(created_at, id) `+cmp+` (?, ?)`)
+		args = append(args, cur.at, cur.id)
+	}
+	stmt := `SELECT ` + complaintColumns + ` FROM complaints`
+	if len(where) > 0 {
+		stmt += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	stmt += ` ORDER BY created_at ` + order + `, id ` + order
+	if q.Limit > 0 {
+		// Берем на одну больше, чтобы узнать, есть ли следующая страница
+		stmt += fmt.Sprintf(` LIMIT %d`, q.Limit+1)
+		if q.Cursor == "" && q.Offset > 0 {
+			stmt += fmt.Sprintf(` OFFSET %d`, q.Offset)
+		}
+	} else if q.Cursor == "" && q.Offset > 0 {
+		stmt += fmt.Sprintf(` LIMIT -1 OFFSET %d`, q.Offset)
+	}
+
+	complaints, err := s.queryWithHistory(stmt, args...)
+	if err != nil {
+		return Page{}, err
+	}
+	if q.Limit > 0 && len(complaints) > q.Limit {
+		complaints = complaints[:q.Limit]
+		page.NextCursor = encodeCursor(complaints[q.Limit-1])
+	}
+	page.Complaints = complaints
+	return page, nil
+}
+
+func (s *SQLiteStore) Get(id int) (Complaint, error) {
+	row := s.db.QueryRow(`SELECT `+complaintColumns+` FROM complaints WHERE id = ?`, id)
+	c, err := scanComplaint(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Complaint{}, ErrNotFound
+	}
+	if err != nil {
+		return Complaint{}, err
+	}
+	c.History, err = s.history(id)
+	if err != nil {
+		return Complaint{}, err
+	}
+	c.Attachments, err = s.attachments(`WHERE complaint_id = ?`, id)
+	if err != nil {
+		return Complaint{}, err
+	}
+	return c, nil
+}
+
+// attachmentsByComplaint загружает вложения по условию where, сгруппированные по id жалобы.
+func (s *SQLiteStore) attachmentsByComplaint(where string, args ...any) (map[int][]Attachment, error) {
+	rows, err := s.db.Query(`SELECT complaint_id, blob_key, name, content_type, size
+		FROM complaint_attachments `+where+` ORDER BY complaint_id, position`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int][]Attachment)
+	for rows.Next() {
+		var (
+			id int
+			a  Attachment
+		)
+		if err := rows.Scan(&id, &a.Key, &a.Name, &a.ContentType, &a.Size); err != nil {
+			return nil, err
+		}
+		result[id] = append(result[id], a)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLiteStore) attachments(where string, id int) ([]Attachment, error) {
+	byComplaint, err := s.attachmentsByComplaint(where, id)
+	if err != nil {
+		return nil, err
+	}
+	return byComplaint[id], nil
+}
+
+func (s *SQLiteStore) Delete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Дочерние строки удаляем явно: foreign_keys может быть выключен прагмами из DATABASE_URL
+	for _, q := range []string{
+		`DELETE FROM complaint_status_history WHERE complaint_id = ?`,
+		`DELETE FROM complaint_comments WHERE complaint_id = ?`,
+		`DELETE FROM complaint_attachments WHERE complaint_id = ?`,
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			return err
+		}
+	}
+	res, err := tx.Exec(`DELETE FROM complaints WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) history(id int) ([]StatusChange, error) {
+	rows, err := s.db.Query(`SELECT from_status, to_status, actor, note, changed_at
+		FROM complaint_status_history WHERE complaint_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []StatusChange
+	for rows.Next() {
+		var (
+			ch StatusChange
+			at int64
+		)
+		if err := rows.Scan(&ch.From, &ch.To, &ch.Actor, &ch.Note, &at); err != nil {
+			return nil, err
+		}
+		ch.At = time.Unix(0, at)
+		result = append(result, ch)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLiteStore) SetHidden(id int, hidden bool) error {
+	_, err := s.Update(id, ComplaintUpdate{Hidden: &hidden})
+	return err
+}
+
+func (s *SQLiteStore) SetStatus(id int, to Status, actor, note string) error {
+	_, err := s.Update(id, ComplaintUpdate{Status: &to, Actor: actor, Note: note})
+	return err
+}
+
+func (s *SQLiteStore) Update(id int, u ComplaintUpdate) (Complaint, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Complaint{}, err
+	}
+	defer tx.Rollback()
+
+	prev, err := scanComplaint(tx.QueryRow(`SELECT `+complaintColumns+` FROM complaints WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Complaint{}, ErrNotFound
+	}
+	if err != nil {
+		return Complaint{}, err
+	}
+
+	c := prev
+	change, err := u.apply(&c)
+	if err != nil {
+		return Complaint{}, err
+	}
+	if _, err := tx.Exec(`UPDATE complaints SET status = ?, hidden = ? WHERE id = ?`, c.Status, c.Hidden, id); err != nil {
+		return Complaint{}, err
+	}
+	if change != nil {
+		if _, err := tx.Exec(
+			`INSERT INTO complaint_status_history (complaint_id, from_status, to_status, actor, note, changed_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			id, change.From, change.To, change.Actor, change.Note, change.At.UnixNano(),
+		); err != nil {
+			return Complaint{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Complaint{}, err
+	}
+	return prev, nil
+}
+
+func (s *SQLiteStore) AddComment(c Comment) (Comment, error) {
+	if err := prepareComment(&c); err != nil {
+		return Comment{}, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Comment{}, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRow(`SELECT 1 FROM complaints WHERE id = ?`, c.ComplaintID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Comment{}, ErrNotFound
+	}
+	if err != nil {
+		return Comment{}, err
+	}
+
+	res, err := tx.Exec(
+		`INSERT INTO complaint_comments (complaint_id, author, from_reporter, internal, body, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		c.ComplaintID, c.Author, c.FromReporter, c.Internal, c.Body, c.CreatedAt.UnixNano(),
+	)
+	if err != nil {
+		return Comment{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Comment{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Comment{}, err
+	}
+	c.ID = int(id)
+	return c, nil
+}
+
+func (s *SQLiteStore) Comments(complaintID int) ([]Comment, error) {
+	rows, err := s.db.Query(`SELECT id, complaint_id, author, from_reporter, internal, body, created_at
+		FROM complaint_comments WHERE complaint_id = ? ORDER BY id`, complaintID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Comment
+	for rows.Next() {
+		var (
+			c  Comment
+			at int64
+		)
+		if err := rows.Scan(&c.ID, &c.ComplaintID, &c.Author, &c.FromReporter, &c.Internal, &c.Body, &at); err != nil {
+			return nil, err
+		}
+		c.CreatedAt = time.Unix(0, at)
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLiteStore) query(q string, args ...any) ([]Complaint, error) {
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Complaint
+	for rows.Next() {
+		c, err := scanComplaint(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+// sqliteBatch - сколько id подставлять в один IN (...): число параметров запроса
+// в SQLite ограничено.
+const sqliteBatch = 500
+
+// queryWithHistory догружает историю статусов и вложения только для найденных жалоб,
+// по запросу на каждые sqliteBatch штук.
+func (s *SQLiteStore) queryWithHistory(q string, args ...any) ([]Complaint, error) {
+	complaints, err := s.query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	for start := 0; start < len(complaints); start += sqliteBatch {
+		if err := s.loadDetails(complaints[start:min(start+sqliteBatch, len(complaints))]); err != nil {
+			return nil, err
+		}
+	}
+	return complaints, nil
+}
+
+// loadDetails заполняет History и Attachments жалоб из complaints.
+func (s *SQLiteStore) loadDetails(complaints []Complaint) error {
+	index := make(map[int]int, len(complaints))
+	ids := make([]any, len(complaints))
+	for i, c := range complaints {
+		index[c.ID] = i
+		ids[i] = c.ID
+	}
+	in := `complaint_id IN (?` + strings.Repeat(`, ?`, len(ids)-1) + `)`
+
+	rows, err := s.db.Query(`SELECT complaint_id, from_status, to_status, actor, note, changed_at
+		FROM complaint_status_history WHERE `+in+` ORDER BY id`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id int
+			ch StatusChange
+			at int64
+		)
+		if err := rows.Scan(&id, &ch.From, &ch.To, &ch.Actor, &ch.Note, &at); err != nil {
+			return err
+		}
+		ch.At = time.Unix(0, at)
+		i := index[id]
+		complaints[i].History = append(complaints[i].History, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	attachments, err := s.attachmentsByComplaint(`WHERE `+in, ids...)
+	if err != nil {
+		return err
+	}
+	for id, list := range attachments {
+		complaints[index[id]].Attachments = list
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanComplaint(row rowScanner) (Complaint, error) {
+	var (
+		c       Complaint
+		created int64
+	)
+	if err := row.Scan(&c.ID, &c.Reporter, &c.Subject, &c.Description, &created, &c.Hidden, &c.Status, &c.Anonymous); err != nil {
+		return Complaint{}, err
+	}
+	c.CreatedAt = time.Unix(0, created)
+	return c, nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// Детали жалоб догружаются пачками по sqliteBatch: каждая жалоба должна получить
+// свою историю и свои вложения, в том числе на границе пачек и на страницах Query.
+func TestSQLiteDetailsPerComplaint(t *testing.T) {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "complaints.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	n := sqliteBatch + 20
+	for i := 1; i <= n; i++ {
+		c, err := s.Add(Complaint{
+			Reporter:    "user@example.com",
+			Subject:     fmt.Sprint(i),
+			Description: "description",
+			Attachments: []Attachment{{Key: fmt.Sprintf("%064x", i), Name: fmt.Sprintf("%d.txt", i), ContentType: "text/plain", Size: int64(i)}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%7 == 0 {
+			if err := s.SetStatus(c.ID, StatusTriaged, "mod@example.com", fmt.Sprint(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	check := func(t *testing.T, complaints []Complaint) {
+		t.Helper()
+		for _, c := range complaints {
+			if len(c.Attachments) != 1 || c.Attachments[0].Name != c.Subject+".txt" {
+				t.Fatalf("complaint %s has attachments %+v", c.Subject, c.Attachments)
+			}
+			var i int
+			fmt.Sscan(c.Subject, &i)
+			wantHistory := 0
+			if i%7 == 0 {
+				wantHistory = 1
+			}
+			if len(c.History) != wantHistory || (wantHistory == 1 && c.History[0].Note != c.Subject) {
+				t.Fatalf("complaint %s has history %+v", c.Subject, c.History)
+			}
+		}
+	}
+
+	all, err := s.ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != n {
+		t.Fatalf("ListAll returned %d complaints, want %d", len(all), n)
+	}
+	check(t, all)
+
+	q := Query{Limit: 50, Offset: sqliteBatch - 25}
+	page, err := s.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Complaints) != 45 || page.Total != n {
+		t.Fatalf("page has %d of %d complaints", len(page.Complaints), page.Total)
+	}
+	check(t, page.Complaints)
+}
@@ -0,0 +1,111 @@
+package storage
+
+import "time"
+
+type Status string
+
+const (
+	StatusNew           Status = "new"
+	StatusTriaged       Status = "triaged"
+	StatusInvestigating Status = "investigating"
+	StatusResolved      Status = "resolved"
+	StatusRejected      Status = "rejected"
+	StatusDuplicate     Status = "duplicate"
+)
+
+// Statuses перечисляет все статусы в порядке жизненного цикла.
+var Statuses = []Status{
+	StatusNew,
+	StatusTriaged,
+	StatusInvestigating,
+	StatusResolved,
+	StatusRejected,
+	StatusDuplicate,
+}
+
+// Допустимые переходы; resolved, rejected и duplicate - конечные статусы.
+var transitions = map[Status][]Status{
+	StatusNew:           {StatusTriaged, StatusRejected, StatusDuplicate},
+	StatusTriaged:       {StatusInvestigating, StatusRejected, StatusDuplicate},
+	StatusInvestigating: {StatusResolved, StatusRejected, StatusDuplicate},
+}
+
+// StatusChange - одна запись в истории статусов жалобы.
+type StatusChange struct {
+	From  Status    `json:"from"`
+	To    Status    `json:"to"`
+	Actor string    `json:"actor"`
+	Note  string    `json:"note,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+func ParseStatus(s string) (Status, bool) {
+	for _, st := range Statuses {
+		if string(st) == s {
+			return st, true
+		}
+	}
+	return "", false
+}
+
+// Next возвращает статусы, в которые можно перейти из текущего.
+func (s Status) Next() []Status {
+	return transitions[s.orNew()]
+}
+
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, st := range s.Next() {
+		if st == next {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Status) IsFinal() bool {
+	return len(s.Next()) == 0
+}
+
+func (s Status) Label() string {
+	switch s.orNew() {
+	case StatusNew:
+		return "New"
+	case StatusTriaged:
+		return "Triaged"
+	case StatusInvestigating:
+		return "Investigating"
+	case StatusResolved:
+		return "Resolved"
+	case StatusRejected:
+		return "Rejected"
+	case StatusDuplicate:
+		return "Duplicate"
+	}
+	return string(s)
+}
+
+// orNew трактует пустой статус (жалобы, созданные до появления статусов) как new.
+func (s Status) orNew() Status {
+	if s == "" {
+		return StatusNew
+	}
+	return s
+}
+
+// applyStatus проверяет переход и дописывает его в историю жалобы.
+func applyStatus(c *Complaint, to Status, actor, note string) (StatusChange, error) {
+	from := c.Status.orNew()
+	if !from.CanTransitionTo(to) {
+		return StatusChange{}, ErrInvalidTransition
+	}
+	change := StatusChange{
+		From:  from,
+		To:    to,
+		Actor: actor,
+		Note:  note,
+		At:    time.Now(),
+	}
+	c.Status = to
+	c.History = append(c.History, change)
+	return change, nil
+}
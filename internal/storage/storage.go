@@ -6,42 +6,96 @@ import (
 	"time"
 )
 
+var (
+	ErrNotFound          = errors.New("complaint not found")
+	ErrInvalidTransition = errors.New("invalid status transition")
+	ErrMissingFields     = errors.New("subject and description required")
+)
+
 type Complaint struct {
-	ID          int       `json:"id"`
-	Reporter    string    `json:"reporter"`
-	Subject     string    `json:"subject"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
-	Hidden      bool      `json:"hidden"`
+	ID          int            `json:"id"`
+	Reporter    string         `json:"reporter"`            // email автора или псевдоним, если Anonymous
+	Anonymous   bool           `json:"anonymous,omitempty"` // автор скрыт за HMAC-псевдонимом
+	Subject     string         `json:"subject"`
+	Description string         `json:"description"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Hidden      bool           `json:"hidden"`
+	Status      Status         `json:"status"`
+	History     []StatusChange `json:"history,omitempty"`
+	Attachments []Attachment   `json:"attachments,omitempty"`
 }
 
 type Store interface {
 	Add(c Complaint) (Complaint, error)
 	List() ([]Complaint, error)
 	ListAll() ([]Complaint, error) // Для админа - все отзывы включая скрытые
+	// Query возвращает страницу жалоб по фильтру; ErrInvalidCursor, если курсор испорчен.
+	Query(q Query) (Page, error)
 	SetHidden(id int, hidden bool) error
+	// SetStatus переводит жалобу в новый статус и пишет переход в историю.
+	// Возвращает ErrInvalidTransition, если переход не разрешен.
+	SetStatus(id int, to Status, actor, note string) error
+	// Update применяет смену статуса и видимости одной операцией: при ошибке
+	// не меняется ничего. Возвращает жалобу в состоянии до изменения; History и
+	// Attachments в ней могут быть не заполнены.
+	Update(id int, u ComplaintUpdate) (Complaint, error)
 	Get(id int) (Complaint, error)
+	// Delete безвозвратно удаляет жалобу вместе с историей и перепиской.
+	// Содержимое вложений удаляет вызывающий код (см. CollectGarbage).
+	Delete(id int) error
+	// AddComment добавляет сообщение в переписку; ErrNotFound, если жалобы нет.
+	AddComment(c Comment) (Comment, error)
+	// Comments возвращает всю переписку по жалобе, включая внутренние заметки, от старых к новым.
+	Comments(complaintID int) ([]Comment, error)
+}
+
+// ComplaintUpdate - изменения жалобы модератором; nil-поля не меняются.
+type ComplaintUpdate struct {
+	Status *Status
+	Hidden *bool
+	Actor  string // для истории статусов
+	Note   string
+}
+
+// apply меняет c; если переход статуса не разрешен, c остается прежней.
+func (u ComplaintUpdate) apply(c *Complaint) (*StatusChange, error) {
+	var change *StatusChange
+	if u.Status != nil {
+		ch, err := applyStatus(c, *u.Status, u.Actor, u.Note)
+		if err != nil {
+			return nil, err
+		}
+		change = &ch
+	}
+	if u.Hidden != nil {
+		c.Hidden = *u.Hidden
+	}
+	return change, nil
 }
 
 type MemoryStore struct {
-	mu         sync.RWMutex
-	complaints []Complaint
-	nextID     int
+	mu            sync.RWMutex
+	complaints    []Complaint
+	comments      []Comment
+	nextID        int
+	nextCommentID int
 }
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{nextID: 1}
+	return &MemoryStore{nextID: 1, nextCommentID: 1}
 }
 
 func (s *MemoryStore) Add(c Complaint) (Complaint, error) {
 	if c.Subject == "" || c.Description == "" {
-		return Complaint{}, errors.New("subject and description required")
+		return Complaint{}, ErrMissingFields
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	c.ID = s.nextID
 	c.CreatedAt = time.Now()
 	c.Hidden = false
+	c.Status = StatusNew
+	c.History = nil
 	s.nextID++
 	s.complaints = append([]Complaint{c}, s.complaints...) // newest first
 	return c, nil
@@ -67,6 +121,12 @@ func (s *MemoryStore) ListAll() ([]Complaint, error) {
 	return result, nil
 }
 
+func (s *MemoryStore) Query(q Query) (Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return queryComplaints(s.complaints, q)
+}
+
 func (s *MemoryStore) Get(id int) (Complaint, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -75,17 +135,93 @@ func (s *MemoryStore) Get(id int) (Complaint, error) {
 			return c, nil
 		}
 	}
-	return Complaint{}, errors.New("complaint not found")
+	return Complaint{}, ErrNotFound
 }
 
 func (s *MemoryStore) SetHidden(id int, hidden bool) error {
+	_, err := s.Update(id, ComplaintUpdate{Hidden: &hidden})
+	return err
+}
+
+func (s *MemoryStore) SetStatus(id int, to Status, actor, note string) error {
+	_, err := s.Update(id, ComplaintUpdate{Status: &to, Actor: actor, Note: note})
+	return err
+}
+
+func (s *MemoryStore) Update(id int, u ComplaintUpdate) (Complaint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.complaints {
+		if s.complaints[i].ID == id {
+			prev := s.complaints[i]
+			if _, err := u.apply(&s.complaints[i]); err != nil {
+				return Complaint{}, err
+			}
+			return prev, nil
+		}
+	}
+	return Complaint{}, ErrNotFound
+}
+
+func (s *MemoryStore) Delete(id int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	for i := range s.complaints {
 		if s.complaints[i].ID == id {
-			s.complaints[i].Hidden = hidden
+			s.complaints = append(s.complaints[:i:i], s.complaints[i+1:]...)
+			s.comments = withoutComments(s.comments, id)
 			return nil
 		}
 	}
-	return errors.New("complaint not found")
+	return ErrNotFound
+}
+
+func (s *MemoryStore) AddComment(c Comment) (Comment, error) {
+	if err := prepareComment(&c); err != nil {
+		return Comment{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !containsComplaint(s.complaints, c.ComplaintID) {
+		return Comment{}, ErrNotFound
+	}
+	c.ID = s.nextCommentID
+	s.nextCommentID++
+	s.comments = append(s.comments, c)
+	return c, nil
+}
+
+func (s *MemoryStore) Comments(complaintID int) ([]Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return commentsFor(s.comments, complaintID), nil
+}
+
+func containsComplaint(complaints []Complaint, id int) bool {
+	for _, c := range complaints {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func commentsFor(comments []Comment, complaintID int) []Comment {
+	var result []Comment
+	for _, c := range comments {
+		if c.ComplaintID == complaintID {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func withoutComments(comments []Comment, complaintID int) []Comment {
+	var result []Comment
+	for _, c := range comments {
+		if c.ComplaintID != complaintID {
+			result = append(result, c)
+		}
+	}
+	return result
 }
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// eachStore запускает test на пустом хранилище каждого вида.
+func eachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("file", func(t *testing.T) {
+		s, err := NewFileStore(filepath.Join(t.TempDir(), "complaints.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, s)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "complaints.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		test(t, s)
+	})
+}
+
+func mustAdd(t *testing.T, s Store, subject string) Complaint {
+	t.Helper()
+	c, err := s.Add(Complaint{Reporter: "user@example.com", Subject: subject, Description: "description"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func mustGet(t *testing.T, s Store, id int) Complaint {
+	t.Helper()
+	c, err := s.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestUpdate(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		c := mustAdd(t, s, "subject")
+		hidden := true
+
+		// Недопустимый переход отменяет и смену видимости
+		resolved := StatusResolved
+		_, err := s.Update(c.ID, ComplaintUpdate{Status: &resolved, Hidden: &hidden, Actor: "mod@example.com"})
+		if !errors.Is(err, ErrInvalidTransition) {
+			t.Fatalf("Update = %v, want ErrInvalidTransition", err)
+		}
+		if got := mustGet(t, s, c.ID); got.Hidden || got.Status != StatusNew || len(got.History) != 0 {
+			t.Fatalf("rejected update changed the complaint: hidden=%v status=%s history=%d", got.Hidden, got.Status, len(got.History))
+		}
+
+		triaged := StatusTriaged
+		prev, err := s.Update(c.ID, ComplaintUpdate{Status: &triaged, Hidden: &hidden, Actor: "mod@example.com", Note: "seen"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prev.ID != c.ID || prev.Subject != "subject" || prev.Hidden || prev.Status != StatusNew {
+			t.Fatalf("Update returned %+v, want the complaint before the change", prev)
+		}
+		got := mustGet(t, s, c.ID)
+		if !got.Hidden || got.Status != StatusTriaged {
+			t.Fatalf("got hidden=%v status=%s, want hidden triaged", got.Hidden, got.Status)
+		}
+		if len(got.History) != 1 || got.History[0].Actor != "mod@example.com" || got.History[0].Note != "seen" {
+			t.Fatalf("history = %+v", got.History)
+		}
+
+		// Только видимость: история не растет
+		visible := false
+		if prev, err := s.Update(c.ID, ComplaintUpdate{Hidden: &visible}); err != nil || !prev.Hidden || prev.Status != StatusTriaged {
+			t.Fatalf("Update = %+v, %v; want the hidden triaged complaint", prev, err)
+		}
+		if got := mustGet(t, s, c.ID); got.Hidden || got.Status != StatusTriaged || len(got.History) != 1 {
+			t.Fatalf("got hidden=%v status=%s history=%d", got.Hidden, got.Status, len(got.History))
+		}
+
+		if _, err := s.Update(c.ID+100, ComplaintUpdate{Hidden: &hidden}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Update of missing complaint = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+// Прежнее состояние, которое возвращает Update, согласовано с параллельными правками:
+// каждое скрытие и раскрытие видно ровно одному вызову.
+func TestConcurrentUpdate(t *testing.T) {
+	eachStore(t, func(t *testing.T, s Store) {
+		c := mustAdd(t, s, "subject")
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			flips   int
+			failure error
+		)
+		for i := range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				hidden := i%2 == 0
+				prev, err := s.Update(c.ID, ComplaintUpdate{Hidden: &hidden})
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err != nil:
+					failure = err
+				case !prev.Hidden && hidden:
+					flips++
+				case prev.Hidden && !hidden:
+					flips--
+				}
+			}()
+		}
+		wg.Wait()
+		if failure != nil {
+			t.Fatal(failure)
+		}
+		want := 0
+		if mustGet(t, s, c.ID).Hidden {
+			want = 1
+		}
+		if flips != want {
+			t.Fatalf("previous states add up to %d hide changes, want %d", flips, want)
+		}
+	})
+}
@@ -3,8 +3,14 @@ package templates
 import (
 	"html/template"
 	"path/filepath"
+	texttemplate "text/template"
 )
 
 func Load() (*template.Template, error) {
 	return template.ParseGlob(filepath.Join("templates", "*.gohtml"))
 }
+
+// LoadEmail загружает текстовые шаблоны писем.
+func LoadEmail() (*texttemplate.Template, error) {
+	return texttemplate.ParseGlob(filepath.Join("templates", "email", "*.gotxt"))
+}
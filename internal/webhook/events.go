@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"time"
+
+	"donos-hrm/internal/storage"
+)
+
+// Данные событий, поле data в теле запроса. Отдельные структуры, а не storage.Complaint,
+// чтобы формат webhooks не менялся вместе с хранилищем.
+
+type ComplaintData struct {
+	ID          int       `json:"id"`
+	Reporter    string    `json:"reporter"` // для анонимных жалоб - псевдоним
+	Anonymous   bool      `json:"anonymous"`
+	Subject     string    `json:"subject"`
+	Description string    `json:"description"`
+	Status      string    `json:"status"`
+	Hidden      bool      `json:"hidden"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func Complaint(c storage.Complaint) ComplaintData {
+	return ComplaintData{
+		ID:          c.ID,
+		Reporter:    c.Reporter,
+		Anonymous:   c.Anonymous,
+		Subject:     c.Subject,
+		Description: c.Description,
+		Status:      string(c.Status),
+		Hidden:      c.Hidden,
+		CreatedAt:   c.CreatedAt,
+	}
+}
+
+type StatusChangeData struct {
+	Complaint ComplaintData `json:"complaint"`
+	From      string        `json:"from"`
+	To        string        `json:"to"`
+	Actor     string        `json:"actor"`
+	Note      string        `json:"note,omitempty"`
+}
+
+type HiddenData struct {
+	Complaint ComplaintData `json:"complaint"`
+	Hidden    bool          `json:"hidden"`
+	Actor     string        `json:"actor"`
+}
+
+type CommentData struct {
+	ComplaintID  int       `json:"complaint_id"`
+	ID           int       `json:"id"`
+	Author       string    `json:"author"`
+	FromReporter bool      `json:"from_reporter"`
+	Internal     bool      `json:"internal"`
+	Body         string    `json:"body"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func Comment(c storage.Comment) CommentData {
+	return CommentData{
+		ComplaintID:  c.ComplaintID,
+		ID:           c.ID,
+		Author:       c.Author,
+		FromReporter: c.FromReporter,
+		Internal:     c.Internal,
+		Body:         c.Body,
+		CreatedAt:    c.CreatedAt,
+	}
+}
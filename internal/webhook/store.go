@@ -0,0 +1,587 @@
+package webhook
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrEndpointNotFound = errors.New("webhook endpoint not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// Endpoint - подписка внешнего сервиса на события.
+type Endpoint struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"` // ключ HMAC-подписи
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (e Endpoint) Subscribed(event string) bool {
+	for _, ev := range e.Events {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Состояния доставки
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Delivery - одна попытка донести событие до одного endpoint, она же запись журнала доставок.
+type Delivery struct {
+	ID           int64     `json:"id"`
+	EndpointID   int64     `json:"endpoint_id"`
+	Event        string    `json:"event"`
+	Payload      string    `json:"payload"`
+	Status       string    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	NextAttempt  time.Time `json:"next_attempt"`
+	ResponseCode int       `json:"response_code,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type Store interface {
+	Endpoints() ([]Endpoint, error)
+	Endpoint(id int64) (Endpoint, error)
+	// SaveEndpoint создает endpoint, если ID == 0, иначе обновляет существующий.
+	SaveEndpoint(e Endpoint) (Endpoint, error)
+	DeleteEndpoint(id int64) error
+
+	AddDelivery(d Delivery) (Delivery, error)
+	Delivery(id int64) (Delivery, error)
+	UpdateDelivery(d Delivery) error
+	// DueDeliveries возвращает ожидающие доставки, время которых наступило, от старых к новым.
+	DueDeliveries(now time.Time, limit int) ([]Delivery, error)
+	// Deliveries возвращает журнал доставок, новые первыми.
+	Deliveries(limit int) ([]Delivery, error)
+	// PruneDeliveries удаляет завершенные доставки, обновленные раньше before.
+	PruneDeliveries(before time.Time) (int, error)
+}
+
+// memState - данные MemoryStore и FileStore. Методы вызываются под мьютексом владельца.
+type memState struct {
+	Endpoints      []Endpoint `json:"endpoints"`
+	Deliveries     []Delivery `json:"deliveries"`
+	NextEndpointID int64      `json:"next_endpoint_id"`
+	NextDeliveryID int64      `json:"next_delivery_id"`
+}
+
+func newMemState() memState {
+	return memState{NextEndpointID: 1, NextDeliveryID: 1}
+}
+
+func (s *memState) clone() memState {
+	c := *s
+	c.Endpoints = append([]Endpoint(nil), s.Endpoints...)
+	c.Deliveries = append([]Delivery(nil), s.Deliveries...)
+	return c
+}
+
+func (s *memState) endpoint(id int64) (Endpoint, error) {
+	for _, e := range s.Endpoints {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return Endpoint{}, ErrEndpointNotFound
+}
+
+func (s *memState) saveEndpoint(e Endpoint) (Endpoint, error) {
+	if e.ID == 0 {
+		e.ID = s.NextEndpointID
+		s.NextEndpointID++
+		s.Endpoints = append(s.Endpoints, e)
+		return e, nil
+	}
+	for i := range s.Endpoints {
+		if s.Endpoints[i].ID == e.ID {
+			s.Endpoints[i] = e
+			return e, nil
+		}
+	}
+	return Endpoint{}, ErrEndpointNotFound
+}
+
+func (s *memState) deleteEndpoint(id int64) error {
+	for i := range s.Endpoints {
+		if s.Endpoints[i].ID == id {
+			s.Endpoints = append(s.Endpoints[:i:i], s.Endpoints[i+1:]...)
+			return nil
+		}
+	}
+	return ErrEndpointNotFound
+}
+
+func (s *memState) addDelivery(d Delivery) Delivery {
+	d.ID = s.NextDeliveryID
+	s.NextDeliveryID++
+	s.Deliveries = append(s.Deliveries, d)
+	return d
+}
+
+func (s *memState) delivery(id int64) (Delivery, error) {
+	for _, d := range s.Deliveries {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+	return Delivery{}, ErrDeliveryNotFound
+}
+
+func (s *memState) updateDelivery(d Delivery) error {
+	for i := range s.Deliveries {
+		if s.Deliveries[i].ID == d.ID {
+			s.Deliveries[i] = d
+			return nil
+		}
+	}
+	return ErrDeliveryNotFound
+}
+
+func (s *memState) dueDeliveries(now time.Time, limit int) []Delivery {
+	var result []Delivery
+	for _, d := range s.Deliveries {
+		if d.Status != StatusPending || d.NextAttempt.After(now) {
+			continue
+		}
+		result = append(result, d)
+		if limit > 0 && len(result) == limit {
+			break
+		}
+	}
+	return result
+}
+
+func (s *memState) deliveries(limit int) []Delivery {
+	var result []Delivery
+	for i := len(s.Deliveries) - 1; i >= 0; i-- {
+		result = append(result, s.Deliveries[i])
+		if limit > 0 && len(result) == limit {
+			break
+		}
+	}
+	return result
+}
+
+func (s *memState) pruneDeliveries(before time.Time) int {
+	kept := s.Deliveries[:0:0]
+	for _, d := range s.Deliveries {
+		if d.Status != StatusPending && d.UpdatedAt.Before(before) {
+			continue
+		}
+		kept = append(kept, d)
+	}
+	n := len(s.Deliveries) - len(kept)
+	s.Deliveries = kept
+	return n
+}
+
+type MemoryStore struct {
+	mu    sync.Mutex
+	state memState
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: newMemState()}
+}
+
+func (s *MemoryStore) Endpoints() ([]Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Endpoint(nil), s.state.Endpoints...), nil
+}
+
+func (s *MemoryStore) Endpoint(id int64) (Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.endpoint(id)
+}
+
+func (s *MemoryStore) SaveEndpoint(e Endpoint) (Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.saveEndpoint(e)
+}
+
+func (s *MemoryStore) DeleteEndpoint(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.deleteEndpoint(id)
+}
+
+func (s *MemoryStore) AddDelivery(d Delivery) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.addDelivery(d), nil
+}
+
+func (s *MemoryStore) Delivery(id int64) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.delivery(id)
+}
+
+func (s *MemoryStore) UpdateDelivery(d Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.updateDelivery(d)
+}
+
+func (s *MemoryStore) DueDeliveries(now time.Time, limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.dueDeliveries(now, limit), nil
+}
+
+func (s *MemoryStore) Deliveries(limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.deliveries(limit), nil
+}
+
+func (s *MemoryStore) PruneDeliveries(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.pruneDeliveries(before), nil
+}
+
+// FileStore держит подписки и журнал доставок в памяти и переписывает JSON-файл
+// при каждом изменении.
+type FileStore struct {
+	mu       sync.Mutex
+	filePath string
+	state    memState
+}
+
+func NewFileStore(filePath string) (*FileStore, error) {
+	s := &FileStore{filePath: filePath, state: newMemState()}
+	data, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.state); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// save вызывается под s.mu.
+func (s *FileStore) save() error {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// В файле секреты подписи, поэтому только для владельца
+	tmpFile := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, s.filePath)
+}
+
+// update применяет изменение и сохраняет файл, откатывая изменение при ошибке.
+func (s *FileStore) update(change func(st *memState) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.state.clone()
+	if err := change(&s.state); err != nil {
+		s.state = prev
+		return err
+	}
+	if err := s.save(); err != nil {
+		s.state = prev
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Endpoints() ([]Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Endpoint(nil), s.state.Endpoints...), nil
+}
+
+func (s *FileStore) Endpoint(id int64) (Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.endpoint(id)
+}
+
+func (s *FileStore) SaveEndpoint(e Endpoint) (Endpoint, error) {
+	err := s.update(func(st *memState) (err error) {
+		e, err = st.saveEndpoint(e)
+		return err
+	})
+	return e, err
+}
+
+func (s *FileStore) DeleteEndpoint(id int64) error {
+	return s.update(func(st *memState) error {
+		return st.deleteEndpoint(id)
+	})
+}
+
+func (s *FileStore) AddDelivery(d Delivery) (Delivery, error) {
+	err := s.update(func(st *memState) error {
+		d = st.addDelivery(d)
+		return nil
+	})
+	return d, err
+}
+
+func (s *FileStore) Delivery(id int64) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.delivery(id)
+}
+
+func (s *FileStore) UpdateDelivery(d Delivery) error {
+	return s.update(func(st *memState) error {
+		return st.updateDelivery(d)
+	})
+}
+
+func (s *FileStore) DueDeliveries(now time.Time, limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.dueDeliveries(now, limit), nil
+}
+
+func (s *FileStore) Deliveries(limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.deliveries(limit), nil
+}
+
+func (s *FileStore) PruneDeliveries(before time.Time) (int, error) {
+	var n int
+	err := s.update(func(st *memState) error {
+		n = st.pruneDeliveries(before)
+		return nil
+	})
+	return n, err
+}
+
+// SQLStore хранит подписки и журнал в таблицах webhook_endpoints и webhook_deliveries.
+type SQLStore struct {
+	db *sql.DB
+}
+
+func NewSQLStore(db *sql.DB) (*SQLStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS webhook_endpoints (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		url        TEXT    NOT NULL,
+		secret     TEXT    NOT NULL,
+		events     TEXT    NOT NULL,
+		active     INTEGER NOT NULL DEFAULT 1,
+		created_by TEXT    NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id            INTEGER PRIMARY KEY AUTOINCREMENT,
+		endpoint_id   INTEGER NOT NULL,
+		event         TEXT    NOT NULL,
+		payload       TEXT    NOT NULL,
+		status        TEXT    NOT NULL,
+		attempts      INTEGER NOT NULL DEFAULT 0,
+		next_attempt  INTEGER NOT NULL,
+		response_code INTEGER NOT NULL DEFAULT 0,
+		last_error    TEXT    NOT NULL DEFAULT '',
+		created_at    INTEGER NOT NULL,
+		updated_at    INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt);`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLStore{db: db}, nil
+}
+
+const (
+	endpointColumns = `id, url, secret, events, active, created_by, created_at`
+	deliveryColumns = `id, endpoint_id, event, payload, status, attempts, next_attempt, response_code, last_error, created_at, updated_at`
+)
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEndpoint(row rowScanner) (Endpoint, error) {
+	var (
+		e      Endpoint
+		events string
+		at     int64
+	)
+	if err := row.Scan(&e.ID, &e.URL, &e.Secret, &events, &e.Active, &e.CreatedBy, &at); err != nil {
+		return Endpoint{}, err
+	}
+	if events != "" {
+		e.Events = strings.Split(events, ",")
+	}
+	e.CreatedAt = time.Unix(0, at)
+	return e, nil
+}
+
+func scanDelivery(row rowScanner) (Delivery, error) {
+	var (
+		d                 Delivery
+		next, at, updated int64
+	)
+	err := row.Scan(&d.ID, &d.EndpointID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &next, &d.ResponseCode, &d.LastError, &at, &updated)
+	if err != nil {
+		return Delivery{}, err
+	}
+	d.NextAttempt = time.Unix(0, next)
+	d.CreatedAt = time.Unix(0, at)
+	d.UpdatedAt = time.Unix(0, updated)
+	return d, nil
+}
+
+func (s *SQLStore) Endpoints() ([]Endpoint, error) {
+	rows, err := s.db.Query(`SELECT ` + endpointColumns + ` FROM webhook_endpoints ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []Endpoint
+	for rows.Next() {
+		e, err := scanEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLStore) Endpoint(id int64) (Endpoint, error) {
+	e, err := scanEndpoint(s.db.QueryRow(`SELECT `+endpointColumns+` FROM webhook_endpoints WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Endpoint{}, ErrEndpointNotFound
+	}
+	return e, err
+}
+
+func (s *SQLStore) SaveEndpoint(e Endpoint) (Endpoint, error) {
+	events := strings.Join(e.Events, ",")
+	if e.ID == 0 {
+		res, err := s.db.Exec(`INSERT INTO webhook_endpoints (url, secret, events, active, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			e.URL, e.Secret, events, e.Active, e.CreatedBy, e.CreatedAt.UnixNano())
+		if err != nil {
+			return Endpoint{}, err
+		}
+		e.ID, err = res.LastInsertId()
+		return e, err
+	}
+	res, err := s.db.Exec(`UPDATE webhook_endpoints SET url = ?, secret = ?, events = ?, active = ? WHERE id = ?`,
+		e.URL, e.Secret, events, e.Active, e.ID)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Endpoint{}, ErrEndpointNotFound
+	}
+	return e, nil
+}
+
+func (s *SQLStore) DeleteEndpoint(id int64) error {
+	res, err := s.db.Exec(`DELETE FROM webhook_endpoints WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrEndpointNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) AddDelivery(d Delivery) (Delivery, error) {
+	res, err := s.db.Exec(`INSERT INTO webhook_deliveries (endpoint_id, event, payload, status, attempts, next_attempt, response_code, last_error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.EndpointID, d.Event, d.Payload, d.Status, d.Attempts, d.NextAttempt.UnixNano(), d.ResponseCode, d.LastError, d.CreatedAt.UnixNano(), d.UpdatedAt.UnixNano())
+	if err != nil {
+		return Delivery{}, err
+	}
+	d.ID, err = res.LastInsertId()
+	return d, err
+}
+
+func (s *SQLStore) Delivery(id int64) (Delivery, error) {
+	d, err := scanDelivery(s.db.QueryRow(`SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Delivery{}, ErrDeliveryNotFound
+	}
+	return d, err
+}
+
+func (s *SQLStore) UpdateDelivery(d Delivery) error {
+	res, err := s.db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt = ?, response_code = ?, last_error = ?, updated_at = ? WHERE id = ?`,
+		d.Status, d.Attempts, d.NextAttempt.UnixNano(), d.ResponseCode, d.LastError, d.UpdatedAt.UnixNano(), d.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) DueDeliveries(now time.Time, limit int) ([]Delivery, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	return s.queryDeliveries(`SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE status = ? AND next_attempt <= ? ORDER BY id LIMIT ?`, StatusPending, now.UnixNano(), limit)
+}
+
+func (s *SQLStore) Deliveries(limit int) ([]Delivery, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	return s.queryDeliveries(`SELECT `+deliveryColumns+` FROM webhook_deliveries ORDER BY id DESC LIMIT ?`, limit)
+}
+
+func (s *SQLStore) PruneDeliveries(before time.Time) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM webhook_deliveries WHERE status != ? AND updated_at < ?`, StatusPending, before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLStore) queryDeliveries(q string, args ...any) ([]Delivery, error) {
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []Delivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, d)
+	}
+	return result, rows.Err()
+}
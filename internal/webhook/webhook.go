@@ -0,0 +1,346 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// События, на которые можно подписаться.
+const (
+	EventComplaintCreated = "complaint.created"
+	EventComplaintHidden  = "complaint.hidden"
+	EventStatusChanged    = "complaint.status_changed"
+	EventCommentAdded     = "comment.added"
+)
+
+var Events = []string{EventComplaintCreated, EventComplaintHidden, EventStatusChanged, EventCommentAdded}
+
+func ValidEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	defaultPollInt     = 10 * time.Second
+	defaultMaxAttempts = 10
+	defaultTimeout     = 10 * time.Second
+	// Первый повтор через 30 секунд, дальше интервал удваивается до maxBackoff
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+	batchSize   = 20
+	// Завершенные доставки хранятся в журнале столько
+	deliveryRetention = 30 * 24 * time.Hour
+	// Сколько байт ответа сохранять в журнал при ошибке
+	maxErrorBody = 200
+)
+
+type Config struct {
+	// Context останавливает отправку при его отмене; по умолчанию - context.Background()
+	Context     context.Context
+	Store       Store
+	Client      *http.Client // по умолчанию - клиент с таймаутом 10 секунд
+	PollInt     time.Duration
+	MaxAttempts int
+}
+
+// Dispatcher превращает события в доставки и отправляет их в фоне. Методы можно
+// вызывать на nil - так выглядят выключенные webhooks.
+type Dispatcher struct {
+	store       Store
+	client      *http.Client
+	maxAttempts int
+	poll        *time.Ticker
+	wake        chan struct{}
+	ctx         context.Context
+	cancel      context.CancelFunc
+	done        chan struct{}
+	lastPrune   time.Time
+}
+
+func New(cfg Config) *Dispatcher {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: defaultTimeout}
+	}
+	if cfg.PollInt <= 0 {
+		cfg.PollInt = defaultPollInt
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.Context == nil {
+		cfg.Context = context.Background()
+	}
+	ctx, cancel := context.WithCancel(cfg.Context)
+	d := &Dispatcher{
+		store:       cfg.Store,
+		client:      cfg.Client,
+		maxAttempts: cfg.MaxAttempts,
+		poll:        time.NewTicker(cfg.PollInt),
+		wake:        make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	go d.loop()
+	return d
+}
+
+// Stop останавливает отправку и ждет окончания текущего прохода.
+func (d *Dispatcher) Stop() {
+	if d == nil {
+		return
+	}
+	d.poll.Stop()
+	d.cancel()
+	<-d.done
+}
+
+// Store возвращает хранилище подписок для страницы администратора.
+func (d *Dispatcher) Store() Store {
+	return d.store
+}
+
+// envelope - тело запроса. ID одинаков у всех доставок одного события, включая
+// повторы, чтобы получатель мог отбросить дубликаты.
+type envelope struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Emit ставит событие в очередь для всех активных подписчиков.
+func (d *Dispatcher) Emit(event string, data any) {
+	if d == nil {
+		return
+	}
+	endpoints, err := d.store.Endpoints()
+	if err != nil {
+		slog.Error("webhook: failed to list endpoints", "err", err)
+		return
+	}
+	var payload []byte
+	now := time.Now()
+	queued := false
+	for _, e := range endpoints {
+		if !e.Active || !e.Subscribed(event) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(envelope{ID: eventID(), Event: event, CreatedAt: now, Data: data}); err != nil {
+				slog.Error("webhook: failed to encode event", "event", event, "err", err)
+				return
+			}
+		}
+		_, err := d.store.AddDelivery(Delivery{
+			EndpointID:  e.ID,
+			Event:       event,
+			Payload:     string(payload),
+			Status:      StatusPending,
+			NextAttempt: now,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		})
+		if err != nil {
+			slog.Error("webhook: failed to queue delivery", "event", event, "endpoint", e.ID, "err", err)
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		d.notify()
+	}
+}
+
+// Replay ставит копию доставки в очередь заново. Исходная запись остается в журнале.
+func (d *Dispatcher) Replay(id int64) (Delivery, error) {
+	orig, err := d.store.Delivery(id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	now := time.Now()
+	copy, err := d.store.AddDelivery(Delivery{
+		EndpointID:  orig.EndpointID,
+		Event:       orig.Event,
+		Payload:     orig.Payload,
+		Status:      StatusPending,
+		NextAttempt: now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	})
+	if err != nil {
+		return Delivery{}, err
+	}
+	d.notify()
+	return copy, nil
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) loop() {
+	defer close(d.done)
+	d.deliver()
+	for {
+		select {
+		case <-d.poll.C:
+		case <-d.wake:
+		case <-d.ctx.Done():
+			return
+		}
+		d.deliver()
+	}
+}
+
+func (d *Dispatcher) deliver() {
+	if time.Since(d.lastPrune) > time.Hour {
+		d.lastPrune = time.Now()
+		if n, err := d.store.PruneDeliveries(time.Now().Add(-deliveryRetention)); err != nil {
+			slog.Error("webhook: failed to prune delivery log", "err", err)
+		} else if n > 0 {
+			slog.Info("webhook: pruned old deliveries", "count", n)
+		}
+	}
+
+	// Если журнал не удалось обновить, следующая пачка вернула бы те же доставки:
+	// проход заканчивается до следующего опроса.
+	for {
+		due, err := d.store.DueDeliveries(time.Now(), batchSize)
+		if err != nil {
+			slog.Error("webhook: failed to read deliveries", "err", err)
+			return
+		}
+		for _, del := range due {
+			if d.ctx.Err() != nil || !d.attempt(del) {
+				return
+			}
+		}
+		if len(due) < batchSize {
+			return
+		}
+	}
+}
+
+// attempt отправляет доставку и записывает результат. Возвращает false, если запись
+// не обновилась.
+func (d *Dispatcher) attempt(del Delivery) bool {
+	e, err := d.store.Endpoint(del.EndpointID)
+	if err == nil && !e.Active {
+		err = fmt.Errorf("endpoint is disabled")
+	}
+	if err != nil {
+		del.Status = StatusFailed
+		del.LastError = err.Error()
+		del.UpdatedAt = time.Now()
+		return d.update(del)
+	}
+
+	del.Attempts++
+	del.UpdatedAt = time.Now()
+	del.ResponseCode, err = d.post(e, del)
+	if err != nil && d.ctx.Err() != nil {
+		// Запрос прерван остановкой: доставка остается в очереди без попытки в счет
+		return false
+	}
+	switch {
+	case err == nil:
+		del.Status = StatusSucceeded
+		del.LastError = ""
+	case del.Attempts >= d.maxAttempts:
+		del.Status = StatusFailed
+		del.LastError = err.Error()
+		slog.Warn("webhook: giving up on delivery", "delivery", del.ID, "url", e.URL, "attempts", del.Attempts, "err", err)
+	default:
+		del.LastError = err.Error()
+		del.NextAttempt = time.Now().Add(backoff(del.Attempts))
+	}
+	return d.update(del)
+}
+
+func (d *Dispatcher) update(del Delivery) bool {
+	if err := d.store.UpdateDelivery(del); err != nil {
+		slog.Error("webhook: failed to update delivery", "delivery", del.ID, "err", err)
+		return false
+	}
+	return true
+}
+
+// post отправляет доставку. Успех - любой ответ 2xx.
+func (d *Dispatcher) post(e Endpoint, del Delivery) (int, error) {
+	body := []byte(del.Payload)
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "donos-hrm-webhooks/1")
+	req.Header.Set("X-Webhook-Event", del.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(del.ID, 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(e.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// Sign считает подпись HMAC-SHA256 от "timestamp.body". Метка времени входит в подпись,
+// чтобы получатель мог отвергать старые перехваченные запросы.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret создает ключ подписи для нового endpoint.
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+func eventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,317 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receiver - получатель webhooks для тестов: отвечает status и складывает запросы в requests.
+type receiver struct {
+	srv      *httptest.Server
+	status   atomic.Int32
+	calls    atomic.Int32
+	requests chan request
+}
+
+type request struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T) *receiver {
+	t.Helper()
+	rc := &receiver{requests: make(chan request, 16)}
+	rc.status.Store(http.StatusNoContent)
+	rc.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc.calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		rc.requests <- request{path: r.URL.Path, header: r.Header, body: body}
+		status := int(rc.status.Load())
+		w.WriteHeader(status)
+		if status >= 300 {
+			io.WriteString(w, "  upstream is down  ")
+		}
+	}))
+	t.Cleanup(rc.srv.Close)
+	return rc
+}
+
+func (rc *receiver) wait(t *testing.T) request {
+	t.Helper()
+	select {
+	case r := <-rc.requests:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook received")
+		return request{}
+	}
+}
+
+// newIdleDispatcher - Dispatcher без фонового цикла: доставки отправляет сам тест.
+func newIdleDispatcher(store Store, maxAttempts int) *Dispatcher {
+	return &Dispatcher{
+		store:       store,
+		client:      &http.Client{Timeout: 5 * time.Second},
+		maxAttempts: maxAttempts,
+		wake:        make(chan struct{}, 1),
+		ctx:         context.Background(),
+	}
+}
+
+func addEndpoint(t *testing.T, store Store, e Endpoint) Endpoint {
+	t.Helper()
+	e, err := store.SaveEndpoint(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestSign(t *testing.T) {
+	const want = "11bf4466ea17c3df3fd743af0b435368e16b7a05eb8eced85e8c4670767bdec5"
+	if got := Sign("whsec_test", "1700000000", []byte(`{"id":"1"}`)); got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+	// Метка времени входит в подпись
+	if Sign("whsec_test", "1700000001", []byte(`{"id":"1"}`)) == want {
+		t.Fatal("signature does not depend on the timestamp")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, maxBackoff},
+		{1000, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	rc := newReceiver(t)
+	store := NewMemoryStore()
+	active := addEndpoint(t, store, Endpoint{URL: rc.srv.URL, Secret: "s", Events: Events, Active: true})
+	disabled := addEndpoint(t, store, Endpoint{URL: rc.srv.URL, Secret: "s", Events: Events})
+	d := newIdleDispatcher(store, 3)
+
+	// run отправляет доставку одной попыткой и возвращает ее запись из журнала
+	run := func(t *testing.T, del Delivery) Delivery {
+		t.Helper()
+		del.Event, del.Payload, del.Status = EventComplaintCreated, `{"id":"x"}`, StatusPending
+		del, err := store.AddDelivery(del)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.attempt(del)
+		got, err := store.Delivery(del.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	t.Run("success", func(t *testing.T) {
+		rc.status.Store(http.StatusNoContent)
+		got := run(t, Delivery{EndpointID: active.ID, LastError: "old error"})
+		rc.wait(t)
+		if got.Status != StatusSucceeded || got.Attempts != 1 || got.ResponseCode != http.StatusNoContent || got.LastError != "" {
+			t.Fatalf("delivery = %+v", got)
+		}
+	})
+
+	t.Run("error response is retried", func(t *testing.T) {
+		rc.status.Store(http.StatusServiceUnavailable)
+		got := run(t, Delivery{EndpointID: active.ID})
+		rc.wait(t)
+		if got.Status != StatusPending || got.Attempts != 1 || got.ResponseCode != http.StatusServiceUnavailable {
+			t.Fatalf("delivery = %+v", got)
+		}
+		if !strings.Contains(got.LastError, "503") || !strings.Contains(got.LastError, ": upstream is down") {
+			t.Errorf("LastError = %q, want the status and the response snippet", got.LastError)
+		}
+		if wait := time.Until(got.NextAttempt); wait < 25*time.Second || wait > baseBackoff {
+			t.Errorf("next attempt in %s, want about %s", wait, baseBackoff)
+		}
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		rc.status.Store(http.StatusInternalServerError)
+		got := run(t, Delivery{EndpointID: active.ID, Attempts: 2})
+		rc.wait(t)
+		if got.Status != StatusFailed || got.Attempts != 3 || !strings.Contains(got.LastError, "500") {
+			t.Fatalf("delivery = %+v", got)
+		}
+	})
+
+	t.Run("disabled endpoint", func(t *testing.T) {
+		calls := rc.calls.Load()
+		got := run(t, Delivery{EndpointID: disabled.ID})
+		if got.Status != StatusFailed || got.Attempts != 0 || got.LastError != "endpoint is disabled" {
+			t.Fatalf("delivery = %+v", got)
+		}
+		if rc.calls.Load() != calls {
+			t.Fatal("disabled endpoint was called")
+		}
+	})
+
+	t.Run("deleted endpoint", func(t *testing.T) {
+		got := run(t, Delivery{EndpointID: 999})
+		if got.Status != StatusFailed || got.LastError == "" {
+			t.Fatalf("delivery = %+v", got)
+		}
+	})
+}
+
+func TestEmit(t *testing.T) {
+	rc := newReceiver(t)
+	store := NewMemoryStore()
+	addEndpoint(t, store, Endpoint{URL: rc.srv.URL + "/a", Secret: "secret-a", Events: []string{EventComplaintCreated}, Active: true})
+	addEndpoint(t, store, Endpoint{URL: rc.srv.URL + "/b", Secret: "secret-b", Events: Events, Active: true})
+	addEndpoint(t, store, Endpoint{URL: rc.srv.URL + "/disabled", Secret: "s", Events: Events})
+	addEndpoint(t, store, Endpoint{URL: rc.srv.URL + "/other", Secret: "s", Events: []string{EventCommentAdded}, Active: true})
+	d := New(Config{Store: store, PollInt: time.Hour})
+	defer d.Stop()
+
+	d.Emit(EventComplaintCreated, ComplaintData{ID: 7, Subject: "s"})
+
+	ids := make(map[string]string)
+	for range 2 {
+		req := rc.wait(t)
+		secret := map[string]string{"/a": "secret-a", "/b": "secret-b"}[req.path]
+		if secret == "" {
+			t.Fatalf("webhook sent to %s", req.path)
+		}
+		timestamp := req.header.Get("X-Webhook-Timestamp")
+		if got, want := req.header.Get("X-Webhook-Signature"), "sha256="+Sign(secret, timestamp, req.body); got != want {
+			t.Errorf("%s: signature %s, want %s", req.path, got, want)
+		}
+		if got := req.header.Get("X-Webhook-Event"); got != EventComplaintCreated {
+			t.Errorf("%s: X-Webhook-Event = %q", req.path, got)
+		}
+		var env struct {
+			ID    string        `json:"id"`
+			Event string        `json:"event"`
+			Data  ComplaintData `json:"data"`
+		}
+		if err := json.Unmarshal(req.body, &env); err != nil {
+			t.Fatal(err)
+		}
+		if env.Event != EventComplaintCreated || env.Data.ID != 7 {
+			t.Errorf("%s: payload %s", req.path, req.body)
+		}
+		ids[req.path] = env.ID
+	}
+	if ids["/a"] == "" || ids["/a"] != ids["/b"] {
+		t.Fatalf("payload IDs %v, want one ID for both endpoints", ids)
+	}
+	select {
+	case req := <-rc.requests:
+		t.Fatalf("unexpected webhook to %s", req.path)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReplay(t *testing.T) {
+	store := NewMemoryStore()
+	e := addEndpoint(t, store, Endpoint{URL: "http://127.0.0.1:1", Secret: "s", Events: Events, Active: true})
+	orig, err := store.AddDelivery(Delivery{
+		EndpointID:   e.ID,
+		Event:        EventStatusChanged,
+		Payload:      `{"id":"evt"}`,
+		Status:       StatusFailed,
+		Attempts:     10,
+		ResponseCode: http.StatusInternalServerError,
+		LastError:    "gave up",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newIdleDispatcher(store, 10)
+
+	replay, err := d.Replay(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.ID == orig.ID || replay.EndpointID != e.ID || replay.Event != orig.Event || replay.Payload != orig.Payload {
+		t.Fatalf("replay = %+v, want a new delivery of the same payload", replay)
+	}
+	if replay.Status != StatusPending || replay.Attempts != 0 || replay.LastError != "" || replay.NextAttempt.After(time.Now()) {
+		t.Fatalf("replay = %+v, want a fresh pending delivery", replay)
+	}
+	if got, _ := store.Delivery(orig.ID); got.Status != StatusFailed || got.Attempts != 10 {
+		t.Fatalf("original delivery changed: %+v", got)
+	}
+	due, err := store.DueDeliveries(time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].ID != replay.ID {
+		t.Fatalf("due deliveries %+v, want the replay", due)
+	}
+
+	if _, err := d.Replay(999); err == nil {
+		t.Fatal("replay of a missing delivery succeeded")
+	}
+}
+
+// stuckStore - журнал, который отдает доставки, но не может их обновить.
+type stuckStore struct {
+	Store
+	dueCalls atomic.Int32
+}
+
+func (s *stuckStore) DueDeliveries(now time.Time, limit int) ([]Delivery, error) {
+	s.dueCalls.Add(1)
+	return s.Store.DueDeliveries(now, limit)
+}
+
+func (s *stuckStore) UpdateDelivery(Delivery) error { return errors.New("disk full") }
+
+// Доставки, результат которых не удалось записать, не отправляются по кругу.
+func TestStuckStoreStopsDelivery(t *testing.T) {
+	rc := newReceiver(t)
+	store := &stuckStore{Store: NewMemoryStore()}
+	e := addEndpoint(t, store, Endpoint{URL: rc.srv.URL, Secret: "s", Events: Events, Active: true})
+	for range batchSize + 5 {
+		if _, err := store.AddDelivery(Delivery{EndpointID: e.ID, Event: EventComplaintCreated, Payload: "{}", Status: StatusPending}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := newIdleDispatcher(store, 10)
+
+	done := make(chan struct{})
+	go func() {
+		d.deliver()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliver keeps resending deliveries it cannot update")
+	}
+	if calls := store.dueCalls.Load(); calls != 1 {
+		t.Errorf("journal read %d times, want 1", calls)
+	}
+	if calls := rc.calls.Load(); calls != 1 {
+		t.Errorf("endpoint called %d times, want 1", calls)
+	}
+}